@@ -11,9 +11,13 @@ import (
 
 	"job-executor/internal/api"
 	"job-executor/internal/config"
+	"job-executor/internal/credentials"
 	"job-executor/internal/database"
+	"job-executor/internal/fanout"
 	"job-executor/internal/queue"
+	"job-executor/internal/scheduler"
 	"job-executor/internal/storage"
+	"job-executor/internal/workflow"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -43,6 +47,17 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Server credentials are encrypted with the master key before they are stored. The
+	// API never decrypts them, connection tests are handed to a worker.
+	credentialKeys, err := credentials.LoadKeyring(cfg.CredentialsMasterKey, cfg.CredentialsMasterKeyFile)
+	if err != nil {
+		slog.Error("Failed to load credentials master key", "error", err)
+		os.Exit(1)
+	}
+	if credentialKeys == nil {
+		slog.Warn("No credentials master key configured, server credentials are stored as plaintext")
+	}
+
 	// Initialize job queue (NetQueue polyfill)
 	var jobQueue *queue.NetQueue
 	netqueueAddr := getEnvOrDefault("NETQUEUE_ADDR", "localhost:9000")
@@ -67,17 +82,25 @@ func main() {
 		S3KeyPrefix:  getEnvOrDefault("S3_KEY_PREFIX", "pem-files/"),
 		AWSAccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
 		AWSSecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Type:         os.Getenv("STORAGE_TYPE"),
+		LocalPath:    getEnvOrDefault("STORAGE_PATH", "./pem-files"),
+
+		S3Endpoint:             os.Getenv("S3_ENDPOINT"),
+		S3UsePathStyle:         os.Getenv("S3_USE_PATH_STYLE") == "true",
+		S3ServerSideEncryption: os.Getenv("S3_SERVER_SIDE_ENCRYPTION"),
+	}
+	if os.Getenv("STORAGE_ENCRYPT") == "true" {
+		if credentialKeys == nil {
+			slog.Error("STORAGE_ENCRYPT needs a credentials master key")
+			os.Exit(1)
+		}
+		storageConfig.Keys = credentialKeys
 	}
 
-	var storageService storage.StorageService
-	s3Service, err := storage.NewS3StorageService(storageConfig, logger)
+	storageService, err := storage.NewStorageService(storageConfig, logger)
 	if err != nil {
-		slog.Error("Failed to initialize S3 storage service", "error", err)
-		// Fallback to local storage for development (will return errors)
-		storageService = storage.NewLocalStorageService("./pem-files", logger)
-		slog.Warn("Using local storage service (limited functionality)")
-	} else {
-		storageService = s3Service
+		slog.Error("Failed to initialize storage service", "error", err)
+		os.Exit(1)
 	}
 
 	// Initialize HTTP server
@@ -109,7 +132,21 @@ func main() {
 	router.Use(cors.New(corsConfig))
 
 	// Setup API routes - no worker dependency
-	api.SetupAPIRoutes(router, db, *jobQueue, storageService, logger)
+	api.SetupAPIRoutes(router, db, *jobQueue, storageService, credentialKeys, cfg.Secrets.Dir, logger)
+
+	// Start the cron scheduler. Every API instance may run one, leader election makes
+	// sure only one of them fires schedules.
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	if getEnvOrDefault("SCHEDULER_ENABLED", "true") == "true" {
+		go scheduler.New(db, *jobQueue).Start(backgroundCtx)
+	}
+
+	// Start the workflow controller, which starts workflow steps as their dependencies finish
+	go workflow.New(db, *jobQueue).Start(backgroundCtx)
+
+	// Start the controller that releases the jobs of multi-target runs
+	go fanout.New(db, *jobQueue).Start(backgroundCtx)
 
 	server := &http.Server{
 		Addr:    cfg.ServerAddr,
@@ -131,6 +168,7 @@ func main() {
 	<-quit
 
 	slog.Info("Shutting down API server...")
+	stopBackground()
 
 	// Shutdown server with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
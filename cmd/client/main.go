@@ -51,7 +51,7 @@ func main() {
 		hostname   = flag.String("hostname", "", "Server hostname")
 		port       = flag.Int("port", 22, "SSH port")
 		user       = flag.String("user", "", "SSH user")
-		authType   = flag.String("auth-type", "", "Authentication type: password or key")
+		authType   = flag.String("auth-type", "", "Authentication type: password, key or agent")
 		password   = flag.String("password", "", "SSH password")
 		privateKey = flag.String("private-key", "", "SSH private key content or file path")
 		pemFile    = flag.String("pem-file", "", "PEM file path")
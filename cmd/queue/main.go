@@ -4,15 +4,51 @@ import (
 	"job-executor/internal/netqueue"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
 func main() {
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
 	slog.SetDefault(logger)
 
 	addr := ":9000" // or from env/config
 	slog.Info("Starting NetQueue server", "addr", addr)
-	server := netqueue.NewNetQueueServer()
+
+	var server *netqueue.NetQueueServer
+	if getEnvOrDefault("QUEUE_PERSISTENCE", "true") == "true" {
+		dataDir := getEnvOrDefault("NETQUEUE_DATA_DIR", "./netqueue-data")
+		s, err := netqueue.NewPersistentNetQueueServer(dataDir)
+		if err != nil {
+			slog.Error("Failed to restore NetQueue state", "error", err, "data_dir", dataDir)
+			os.Exit(1)
+		}
+		server = s
+		slog.Info("NetQueue persistence enabled", "data_dir", dataDir)
+	} else {
+		server = netqueue.NewNetQueueServer()
+		slog.Warn("NetQueue persistence disabled, queued jobs will be lost on restart")
+	}
+
+	// Write a final snapshot on shutdown so the next start doesn't replay the whole log
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-quit
+		slog.Info("Shutting down NetQueue server...")
+		if err := server.Close(); err != nil {
+			slog.Error("Failed to close NetQueue journal", "error", err)
+		}
+		os.Exit(0)
+	}()
+
 	if err := server.Start(addr); err != nil {
 		slog.Error("NetQueue server failed", "error", err)
 		os.Exit(1)
@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -10,8 +13,12 @@ import (
 	"time"
 
 	"job-executor/internal/config"
+	"job-executor/internal/credentials"
 	"job-executor/internal/database"
+	"job-executor/internal/models"
 	"job-executor/internal/queue"
+	"job-executor/internal/secrets"
+	"job-executor/internal/ssh"
 	"job-executor/internal/storage"
 	"job-executor/internal/worker"
 
@@ -50,6 +57,11 @@ func maskPassword(url string) string {
 }
 
 func main() {
+	reencrypt := flag.Bool("reencrypt-credentials", false, "Encrypt every server's credentials with the newest master key, then exit")
+	writeSecret := flag.String("write-secret", "", "Encrypt the secret read from stdin into SECRETS_DIR under this name, then exit")
+	migratePem := flag.String("migrate-pem-files", "", "Move every server's PEM file to \"local\" or \"s3\" storage, then exit")
+	flag.Parse()
+
 	// Initialize structured logger with debug level for better diagnostics
 	logLevel := slog.LevelInfo
 	if os.Getenv("LOG_LEVEL") == "debug" {
@@ -70,9 +82,17 @@ func main() {
 	cfg := config.Load()
 	slog.Info("Configuration loaded",
 		"database_url", maskPassword(cfg.DatabaseURL),
-		"rabbitmq_url", maskPassword(cfg.RabbitMQURL),
+		"netqueue_addr", cfg.NetQueueAddr,
 		"worker_pool_size", cfg.WorkerPoolSize)
 
+	if *writeSecret != "" {
+		if err := writeEncryptedSecret(cfg, *writeSecret); err != nil {
+			slog.Error("Failed to write secret", "name", *writeSecret, "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Initialize database with retry logic
 	slog.Info("Initializing database connection...")
 	var db *gorm.DB
@@ -98,6 +118,60 @@ func main() {
 		os.Exit(1)
 	}
 
+	credentialKeys, err := credentials.LoadKeyring(cfg.CredentialsMasterKey, cfg.CredentialsMasterKeyFile)
+	if err != nil {
+		slog.Error("Failed to load credentials master key", "error", err)
+		os.Exit(1)
+	}
+	if *reencrypt {
+		if err := reencryptCredentials(db, credentialKeys); err != nil {
+			slog.Error("Failed to re-encrypt server credentials", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+	secretProvider, err := secrets.NewProvider(cfg.Secrets, credentialKeys)
+	if err != nil {
+		slog.Error("Failed to set up secrets provider", "error", err)
+		os.Exit(1)
+	}
+
+
+	// Initialize storage service
+	storageConfig := &storage.StorageConfig{
+		AWSRegion:    getEnvOrDefault("AWS_REGION", "ap-south-1"),
+		S3Bucket:     getEnvOrDefault("S3_BUCKET", "remora-files"),
+		S3KeyPrefix:  getEnvOrDefault("S3_KEY_PREFIX", "pem-files/"),
+		AWSAccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		AWSSecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Type:         os.Getenv("STORAGE_TYPE"),
+		LocalPath:    getEnvOrDefault("STORAGE_PATH", "./pem-files"),
+
+		S3Endpoint:             os.Getenv("S3_ENDPOINT"),
+		S3UsePathStyle:         os.Getenv("S3_USE_PATH_STYLE") == "true",
+		S3ServerSideEncryption: os.Getenv("S3_SERVER_SIDE_ENCRYPTION"),
+	}
+	if os.Getenv("STORAGE_ENCRYPT") == "true" {
+		if credentialKeys == nil {
+			slog.Error("STORAGE_ENCRYPT needs a credentials master key")
+			os.Exit(1)
+		}
+		storageConfig.Keys = credentialKeys
+	}
+
+	storageService, err := storage.NewStorageService(storageConfig, logger)
+	if err != nil {
+		slog.Error("Failed to initialize storage service", "error", err)
+		os.Exit(1)
+	}
+
+	if *migratePem != "" {
+		if err := migratePemFiles(context.Background(), db, storageConfig, *migratePem, logger); err != nil {
+			slog.Error("Failed to migrate PEM files", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	   // Initialize job queue (NetQueue polyfill)
 	   netqueueAddr := getEnvOrDefault("NETQUEUE_ADDR", "localhost:9000")
@@ -114,33 +188,36 @@ func main() {
 			   }
 	   }()
 
-	// Initialize storage service
-	storageConfig := &storage.StorageConfig{
-		AWSRegion:    getEnvOrDefault("AWS_REGION", "ap-south-1"),
-		S3Bucket:     getEnvOrDefault("S3_BUCKET", "remora-files"),
-		S3KeyPrefix:  getEnvOrDefault("S3_KEY_PREFIX", "pem-files/"),
-		AWSAccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
-		AWSSecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
-	}
-
-	var storageService storage.StorageService
-	s3Service, err := storage.NewS3StorageService(storageConfig, logger)
-	if err != nil {
-		slog.Error("Failed to initialize S3 storage service", "error", err)
-		// Fallback to local storage for development (will return errors)
-		storageService = storage.NewLocalStorageService("./pem-files", logger)
-		slog.Warn("Using local storage service (limited functionality)")
-	} else {
-		storageService = s3Service
-	}
-
 	// Initialize worker
 	   jobWorker := worker.New(db, *jobQueue, storageService)
 
 	// Configure worker pool size based on configuration
 	jobWorker.SetWorkerPoolSize(cfg.WorkerPoolSize)
+	jobWorker.SetPrefetch(cfg.WorkerPrefetch)
+	jobWorker.SetStrictHostKeys(cfg.SSHStrictHostKeys)
+	jobWorker.SetKeyring(credentialKeys)
+	jobWorker.SetOutputLimits(cfg.JobOutputInlineLimit, cfg.JobOutputChunkSize)
+	jobWorker.SetSecrets(secrets.NewResolver(secretProvider, cfg.Secrets.Dir))
+	if cfg.SSHCAKey != "" {
+		ca, err := ssh.LoadCertificateAuthority(cfg.SSHCAKey)
+		if err != nil {
+			slog.Error("Failed to load SSH certificate authority", "error", err)
+			os.Exit(1)
+		}
+		jobWorker.SetCertificateAuthority(ca)
+		slog.Info("Issuing per-job SSH certificates", "ca_public_key", ca.PublicKey())
+	}
+	if cfg.SSHPool.MaxSessions > 0 {
+		jobWorker.SSHPool().SetMaxSessions(cfg.SSHPool.MaxSessions)
+	}
+	if cfg.SSHPool.IdleTimeout > 0 {
+		jobWorker.SSHPool().SetIdleTimeout(cfg.SSHPool.IdleTimeout)
+	}
+	if cfg.SSHPool.KeepaliveInterval > 0 {
+		jobWorker.SSHPool().SetHealthInterval(cfg.SSHPool.KeepaliveInterval)
+	}
 
-	slog.Info("Worker configured", "worker_pool_size", cfg.WorkerPoolSize)
+	slog.Info("Worker configured", "worker_pool_size", cfg.WorkerPoolSize, "prefetch", cfg.WorkerPrefetch)
 
 	// Start worker in background
 	ctx, cancel := context.WithCancel(context.Background())
@@ -161,3 +238,113 @@ func main() {
 
 	slog.Info("Worker exited")
 }
+
+// reencryptCredentials encrypts the credentials of every server with the newest master
+// key, so older keys can be retired. Servers that already use it are left alone, so it
+// can be run again after an interruption.
+func reencryptCredentials(db *gorm.DB, keys *credentials.Keyring) error {
+	if keys == nil {
+		return fmt.Errorf("no credentials master key configured")
+	}
+
+	var servers []models.Server
+	updated := 0
+	result := db.Where("credentials_key_version < ?", keys.Current()).FindInBatches(&servers, 100, func(tx *gorm.DB, batch int) error {
+		for i := range servers {
+			server := &servers[i]
+			changed, err := server.RotateCredentials(keys)
+			if err != nil {
+				return fmt.Errorf("server %s: %w", server.ID, err)
+			}
+			if !changed {
+				continue
+			}
+			// updated_at stays, the credentials themselves haven't changed
+			if err := db.Model(server).UpdateColumns(map[string]interface{}{
+				"password":                server.Password,
+				"private_key":             server.PrivateKey,
+				"pem_file":                server.PemFile,
+				"credentials_key_version": server.CredentialsKeyVersion,
+			}).Error; err != nil {
+				return fmt.Errorf("server %s: %w", server.ID, err)
+			}
+			updated++
+		}
+		return nil
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	slog.Info("Server credentials re-encrypted", "key_version", keys.Current(), "updated", updated)
+	return nil
+}
+
+// writeEncryptedSecret stores the secret given on stdin as a file of the encrypted-file
+// secrets provider, so servers can reference it as secret://name
+func writeEncryptedSecret(cfg *config.Config, name string) error {
+	keys, err := credentials.LoadKeyring(cfg.CredentialsMasterKey, cfg.CredentialsMasterKeyFile)
+	if err != nil {
+		return err
+	}
+	secret, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	if err := secrets.NewEncryptedFileProvider(cfg.Secrets.Dir, keys).WriteEncryptedFile(name, string(secret)); err != nil {
+		return err
+	}
+	slog.Info("Secret written", "name", name, "dir", cfg.Secrets.Dir, "key_version", keys.Current())
+	return nil
+}
+
+// migratePemFiles moves the PEM files of all servers into the storage named by target,
+// "local" or "s3", and points the servers at the new copies. The old copy is deleted
+// once the server is updated, so the migration can be run again if it's interrupted.
+func migratePemFiles(ctx context.Context, db *gorm.DB, storageConfig *storage.StorageConfig, target string, logger *slog.Logger) error {
+	local := storage.NewLocalStorageService(storageConfig.LocalPath, logger)
+	local.SetKeyring(storageConfig.Keys)
+	s3Service, err := storage.NewS3StorageService(storageConfig, logger)
+	if err != nil {
+		return err
+	}
+
+	var from, to storage.StorageService
+	var scheme string
+	switch target {
+	case "local":
+		from, to, scheme = s3Service, local, "s3://"
+	case "s3":
+		from, to, scheme = local, s3Service, "file://"
+	default:
+		return fmt.Errorf("unknown storage %q, expected local or s3", target)
+	}
+
+	// Servers can share a PEM file. It's copied once, and deleted only once all of them
+	// use the copy.
+	var urls []string
+	if err := db.Model(&models.Server{}).Distinct("pem_file_url").Where("pem_file_url LIKE ?", scheme+"%").Pluck("pem_file_url", &urls).Error; err != nil {
+		return err
+	}
+
+	migrated := 0
+	for _, source := range urls {
+		url, err := storage.CopyPemFile(ctx, from, to, source)
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		// Bumping updated_at makes workers reconnect with the new copy
+		result := db.Model(&models.Server{}).Where("pem_file_url = ?", source).Update("pem_file_url", url)
+		if result.Error != nil {
+			to.DeletePemFile(ctx, url)
+			return fmt.Errorf("%s: %w", source, result.Error)
+		}
+		if err := from.DeletePemFile(ctx, source); err != nil {
+			slog.Warn("Failed to delete migrated PEM file", "url", source, "error", err)
+		}
+		migrated += int(result.RowsAffected)
+	}
+
+	slog.Info("PEM files migrated", "target", target, "servers", migrated)
+	return nil
+}
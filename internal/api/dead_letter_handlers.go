@@ -0,0 +1,157 @@
+package api
+
+import (
+	"job-executor/internal/models"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// deadLetterResponse is a dead-lettered job as listed by the API
+type deadLetterResponse struct {
+	ID       string              `json:"id"`
+	Reason   string              `json:"reason"`
+	Attempts int                 `json:"attempts"` // redeliveries after lost leases, as counted by the queue
+	DeadAt   time.Time           `json:"dead_at"`
+	Job      *models.JobResponse `json:"job,omitempty"`
+}
+
+// ListDeadLetters lists the jobs that ran out of retries, oldest first
+func (api *API) ListDeadLetters(c *gin.Context) {
+	letters, err := api.queue.DeadLetters()
+	if err != nil {
+		slog.Error("Failed to list dead letters", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to list dead letters"})
+		return
+	}
+
+	ids := make([]string, 0, len(letters))
+	for _, letter := range letters {
+		ids = append(ids, letter.ID)
+	}
+	jobs := make(map[string]models.Job, len(ids))
+	if len(ids) > 0 {
+		var found []models.Job
+		if err := api.db.Preload("Server").Where("id IN ?", ids).Find(&found).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch jobs"})
+			return
+		}
+		for _, job := range found {
+			jobs[job.ID] = job
+		}
+	}
+
+	responses := make([]deadLetterResponse, 0, len(letters))
+	for _, letter := range letters {
+		response := deadLetterResponse{
+			ID:       letter.ID,
+			Reason:   letter.Reason,
+			Attempts: letter.Attempts,
+			DeadAt:   letter.DeadAt,
+		}
+		if job, ok := jobs[letter.ID]; ok {
+			response.Job = models.NewJobResponse(job)
+			response.Job.CalculateDuration()
+		}
+		responses = append(responses, response)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dead_letters": responses,
+		"count":        len(responses),
+	})
+}
+
+// ReplayDeadLetter resets a dead-lettered job and puts it back into the queue
+func (api *API) ReplayDeadLetter(c *gin.Context) {
+	jobID := c.Param("id")
+
+	if !api.isDeadLettered(c, jobID) {
+		return
+	}
+
+	var job models.Job
+	if err := api.db.First(&job, "id = ?", jobID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job"})
+		return
+	}
+
+	// Reset the job before it is requeued, otherwise a worker could pick it up while
+	// it still looks finished and skip it
+	failedStatus, failedError := job.Status, job.Error
+	job.Status = models.StatusQueued
+	job.Attempt = 1
+	job.Output = ""
+	job.Error = ""
+	job.Stdout = ""
+	job.Stderr = ""
+	job.StdoutLog = nil
+	job.StderrLog = nil
+	job.ExitCode = nil
+	job.StartedAt = nil
+	job.FinishedAt = nil
+	// The artifacts of the earlier attempts go with their output
+	if err := api.db.Where("job_id = ?", jobID).Delete(&models.JobArtifact{}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset job"})
+		return
+	}
+	if err := api.db.Save(&job).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset job"})
+		return
+	}
+
+	if err := api.queue.ReplayDeadLetter(jobID); err != nil {
+		slog.Error("Failed to replay dead letter", "job_id", jobID, "error", err)
+		api.db.Model(&job).Updates(map[string]interface{}{"status": failedStatus, "error": failedError})
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to replay job"})
+		return
+	}
+
+	slog.Info("Dead-lettered job replayed", "job_id", jobID)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Job requeued",
+		"job":     models.NewJobResponse(job),
+	})
+}
+
+// DeleteDeadLetter drops a job from the dead-letter list. The job itself stays failed.
+func (api *API) DeleteDeadLetter(c *gin.Context) {
+	jobID := c.Param("id")
+
+	if !api.isDeadLettered(c, jobID) {
+		return
+	}
+
+	if err := api.queue.DeleteDeadLetter(jobID); err != nil {
+		slog.Error("Failed to delete dead letter", "job_id", jobID, "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to delete dead letter"})
+		return
+	}
+
+	slog.Info("Dead letter deleted", "job_id", jobID)
+	c.JSON(http.StatusOK, gin.H{"message": "Dead letter deleted"})
+}
+
+// isDeadLettered checks the job is on the dead-letter list, writing an error response if not
+func (api *API) isDeadLettered(c *gin.Context, jobID string) bool {
+	letters, err := api.queue.DeadLetters()
+	if err != nil {
+		slog.Error("Failed to list dead letters", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to list dead letters"})
+		return false
+	}
+	for _, letter := range letters {
+		if letter.ID == jobID {
+			return true
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Job is not dead-lettered"})
+	return false
+}
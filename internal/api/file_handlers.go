@@ -0,0 +1,118 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"job-executor/internal/models"
+	"log/slog"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// UploadFile stores a file for jobs to take as input. The returned URL goes into the
+// url of an input when submitting a job.
+func (api *API) UploadFile(c *gin.Context) {
+	if api.storage == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No storage is configured"})
+		return
+	}
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get uploaded file"})
+		return
+	}
+	defer file.Close()
+
+	// The upload keeps its name, under a directory of its own
+	filename := filepath.Base(filepath.Clean("/" + filepath.FromSlash(header.Filename)))
+	if filename == string(filepath.Separator) || filename == "." {
+		filename = "file"
+	}
+	url, err := api.storage.PutFile(c.Request.Context(), path.Join("uploads", uuid.New().String(), filename), file)
+	if err != nil {
+		api.logger.Error("Failed to store uploaded file", slog.Any("error", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store file"})
+		return
+	}
+
+	api.logger.Info("File uploaded",
+		slog.String("filename", filename),
+		slog.String("url", url),
+		slog.Int64("size", header.Size))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"url":      url,
+		"filename": filename,
+		"size":     header.Size,
+	})
+}
+
+// ListJobArtifacts lists the files collected from the target after the job ran, of
+// every attempt
+func (api *API) ListJobArtifacts(c *gin.Context) {
+	jobID := c.Param("id")
+
+	var job models.Job
+	if err := api.db.Select("id").First(&job, "id = ?", jobID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job"})
+		return
+	}
+
+	var artifacts []models.JobArtifact
+	if err := api.db.Where("job_id = ?", jobID).Order("attempt, path").Find(&artifacts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch artifacts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"job_id":    jobID,
+		"artifacts": artifacts,
+		"count":     len(artifacts),
+	})
+}
+
+// DownloadJobArtifact streams an artifact's content from storage
+func (api *API) DownloadJobArtifact(c *gin.Context) {
+	var artifact models.JobArtifact
+	err := api.db.First(&artifact, "id = ? AND job_id = ?", c.Param("artifact_id"), c.Param("id")).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Artifact not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch artifact"})
+		return
+	}
+	if artifact.URL == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artifact wasn't collected: " + artifact.Error})
+		return
+	}
+	if api.storage == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Artifact is in storage, but no storage is configured"})
+		return
+	}
+
+	content, err := api.storage.GetFile(c.Request.Context(), artifact.URL)
+	if err != nil {
+		api.logger.Error("Failed to read artifact", slog.String("artifact_id", artifact.ID), slog.Any("error", err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read artifact from storage"})
+		return
+	}
+	defer content.Close()
+
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(artifact.Path)))
+	c.Header("Content-Length", strconv.FormatInt(artifact.Size, 10))
+	c.Status(http.StatusOK)
+	io.Copy(c.Writer, content)
+}
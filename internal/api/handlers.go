@@ -1,6 +1,8 @@
 package api
 
 import (
+	"job-executor/internal/broadcast"
+	"job-executor/internal/credentials"
 	"job-executor/internal/queue"
 	"job-executor/internal/storage"
 	"job-executor/internal/worker"
@@ -12,40 +14,77 @@ import (
 )
 
 type API struct {
-	db      *gorm.DB
-	queue   queue.NetQueue
-	worker  *worker.Worker
-	storage storage.StorageService
-	logger  *slog.Logger
+	db          *gorm.DB
+	queue       queue.NetQueue
+	worker      *worker.Worker
+	storage     storage.StorageService
+	logger      *slog.Logger
+	broadcaster *broadcast.OutputBroadcaster
+	keys        *credentials.Keyring // encrypts server credentials, nil stores them as plaintext. Only workers decrypt them.
+	secretsDir  string               // file:// credential references must be inside it
 }
 
 // SetupRoutes is the legacy setup function that includes worker dependency
-func SetupRoutes(router *gin.Engine, db *gorm.DB, queue queue.NetQueue, worker *worker.Worker, storage storage.StorageService, logger *slog.Logger) {
-	api := &API{db: db, queue: queue, worker: worker, storage: storage, logger: logger}
+func SetupRoutes(router *gin.Engine, db *gorm.DB, queue queue.NetQueue, worker *worker.Worker, storage storage.StorageService, keys *credentials.Keyring, secretsDir string, logger *slog.Logger) {
+	api := &API{db: db, queue: queue, worker: worker, storage: storage, keys: keys, secretsDir: secretsDir, logger: logger}
 	setupCommonRoutes(router, api)
 }
 
 // SetupAPIRoutes is the new setup function without worker dependency (for API server)
-func SetupAPIRoutes(router *gin.Engine, db *gorm.DB, queue queue.NetQueue, storage storage.StorageService, logger *slog.Logger) {
-	api := &API{db: db, queue: queue, worker: nil, storage: storage, logger: logger}
+func SetupAPIRoutes(router *gin.Engine, db *gorm.DB, queue queue.NetQueue, storage storage.StorageService, keys *credentials.Keyring, secretsDir string, logger *slog.Logger) {
+	api := &API{db: db, queue: queue, worker: nil, storage: storage, keys: keys, secretsDir: secretsDir, logger: logger}
 	setupCommonRoutes(router, api)
 }
 
 func setupCommonRoutes(router *gin.Engine, api *API) {
+	// Live output viewers share one queue consumer per job
+	api.broadcaster = broadcast.GlobalBroadcaster
+	api.broadcaster.SetSource(api.streamOutputSource)
 
 	v1 := router.Group("/api/v1")
 	{
 		// Job routes
 		v1.POST("/jobs", api.SubmitJob)
 		v1.POST("/jobs/script", api.SubmitScriptJob)
+		v1.POST("/jobs/batch", api.SubmitJobBatch)
 		v1.POST("/jobs/:id/duplicate", api.DuplicateJob)
 		v1.GET("/jobs/:id", api.GetJob)
 		v1.POST("/jobs/:id/cancel", api.CancelJob)
 		v1.GET("/jobs/:id/logs", api.GetJobLogs)
 		v1.GET("/jobs/:id/stdout", api.GetJobStdout)
 		v1.GET("/jobs/:id/stderr", api.GetJobStderr)
+		v1.GET("/jobs/:id/stream", api.StreamJob)
+		v1.GET("/jobs/:id/artifacts", api.ListJobArtifacts)
+		v1.GET("/jobs/:id/artifacts/:artifact_id", api.DownloadJobArtifact)
 		v1.GET("/jobs", api.ListJobs)
 
+		// Dead-letter routes
+		v1.GET("/dead-letters", api.ListDeadLetters)
+		v1.POST("/dead-letters/:id/replay", api.ReplayDeadLetter)
+		v1.DELETE("/dead-letters/:id", api.DeleteDeadLetter)
+
+		// Schedule routes
+		v1.POST("/schedules", api.CreateSchedule)
+		v1.GET("/schedules", api.ListSchedules)
+		v1.GET("/schedules/:id", api.GetSchedule)
+		v1.PUT("/schedules/:id", api.UpdateSchedule)
+		v1.DELETE("/schedules/:id", api.DeleteSchedule)
+
+		// Workflow routes
+		v1.POST("/workflows", api.CreateWorkflow)
+		v1.GET("/workflows", api.ListWorkflows)
+		v1.GET("/workflows/:id", api.GetWorkflow)
+		v1.POST("/workflows/:id/cancel", api.CancelWorkflow)
+
+		// Multi-target run routes
+		v1.POST("/runs", api.SubmitRun)
+		v1.GET("/runs", api.ListRuns)
+		v1.GET("/runs/:id", api.GetRun)
+		v1.POST("/runs/:id/cancel", api.CancelRun)
+		v1.POST("/runs/:id/pause", api.PauseRun)
+		v1.POST("/runs/:id/resume", api.ResumeRun)
+		v1.POST("/runs/:id/abort", api.AbortRun)
+
 		// Server configuration routes
 		v1.POST("/servers", api.CreateServer)
 		v1.GET("/servers/:id", api.GetServer)
@@ -53,14 +92,27 @@ func setupCommonRoutes(router *gin.Engine, api *API) {
 		v1.DELETE("/servers/:id", api.DeleteServer)
 		v1.GET("/servers", api.ListServers)
 		v1.POST("/servers/:id/test", api.TestServerConnection)
+		v1.GET("/servers/:id/host-keys", api.GetServerHostKeys)
+		v1.PUT("/servers/:id/host-keys", api.UpdateServerHostKeys)
+		v1.POST("/servers/:id/host-keys/repin", api.RepinServerHostKey)
 		v1.GET("/servers/:id/status", api.CheckServerStatus)
 		v1.GET("/servers/status/all", api.CheckAllServersStatus)
 
+		// Server group routes
+		v1.POST("/server-groups", api.CreateServerGroup)
+		v1.GET("/server-groups", api.ListServerGroups)
+		v1.GET("/server-groups/:id", api.GetServerGroup)
+		v1.PUT("/server-groups/:id", api.UpdateServerGroup)
+		v1.DELETE("/server-groups/:id", api.DeleteServerGroup)
+
 		// System info route
 		v1.GET("/system/info", api.GetSystemInfo)
 
 		// PEM file upload route
 		v1.POST("/pem-files/upload", api.UploadPemFile)
+
+		// Job input file upload route
+		v1.POST("/files", api.UploadFile)
 	}
 
 	// Health check endpoint
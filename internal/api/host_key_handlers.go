@@ -0,0 +1,103 @@
+package api
+
+import (
+	"job-executor/internal/models"
+	"job-executor/internal/queue"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetServerHostKeys lists the host key fingerprints pinned for a server
+func (api *API) GetServerHostKeys(c *gin.Context) {
+	server, ok := api.findServer(c)
+	if !ok {
+		return
+	}
+	respondHostKeys(c, server)
+}
+
+// UpdateServerHostKeys replaces a server's pinned host keys, e.g. to rotate them. Pinning
+// both the old and the new key lets jobs carry on while the server switches over.
+func (api *API) UpdateServerHostKeys(c *gin.Context) {
+	server, ok := api.findServer(c)
+	if !ok {
+		return
+	}
+
+	var req models.HostKeysRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hostKeys, err := models.NormalizeHostKeys(req.HostKeys)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Saving bumps updated_at, so workers drop connections verified against the old keys
+	server.HostKeys = hostKeys
+	if err := api.db.Model(server).Select("host_keys", "updated_at").Updates(server).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update host keys"})
+		return
+	}
+
+	api.logger.Info("Host keys updated", slog.String("server_id", server.ID), slog.Any("host_keys", hostKeys))
+	respondHostKeys(c, server)
+}
+
+// RepinServerHostKey has a worker connect to a server and pin whatever host key it
+// presents now, replacing the pinned ones. Use it after the server's key was regenerated
+// on purpose.
+func (api *API) RepinServerHostKey(c *gin.Context) {
+	server, ok := api.findServer(c)
+	if !ok {
+		return
+	}
+
+	result, ok := api.checkServer(c, queue.ServerCheck{ServerID: server.ID, Repin: true})
+	if !ok {
+		return
+	}
+	if result.Status != "connection_successful" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"server_id": server.ID,
+			"status":    "connection_failed",
+			"error":     result.Error,
+		})
+		return
+	}
+	if !result.HostKeyPinned {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to pin host key"})
+		return
+	}
+
+	server.HostKeys = []string{result.HostKey}
+	api.logger.Info("Host key re-pinned", slog.String("server_id", server.ID), slog.String("host_key", result.HostKey))
+	respondHostKeys(c, server)
+}
+
+// findServer loads the server named in the path, writing an error response if it can't
+func (api *API) findServer(c *gin.Context) (*models.Server, bool) {
+	var server models.Server
+	if err := api.db.First(&server, "id = ?", c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch server"})
+		return nil, false
+	}
+	return &server, true
+}
+
+func respondHostKeys(c *gin.Context, server *models.Server) {
+	hostKeys := server.HostKeys
+	if hostKeys == nil {
+		hostKeys = []string{}
+	}
+	c.JSON(http.StatusOK, models.HostKeysResponse{ServerID: server.ID, HostKeys: hostKeys})
+}
@@ -2,16 +2,19 @@ package api
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
+	"job-executor/internal/broadcast"
 	"job-executor/internal/models"
 	"job-executor/internal/queue"
+	"job-executor/internal/storage"
 	"log/slog"
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
@@ -22,6 +25,46 @@ func (api *API) SubmitJob(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := req.RetryPolicy.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.JobFiles.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	runAt, err := req.ScheduledTime(time.Now().UTC())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A selector fans the job out to every matching server
+	if req.ServerID == "" {
+		if !fanOutAllowed(c, req.ServerSelector, runAt) {
+			return
+		}
+		api.createRun(c, &models.RunRequest{
+			Command:        req.Command,
+			Args:           req.Args,
+			Timeout:        req.Timeout,
+			Priority:       req.Priority,
+			ForwardAgent:   req.ForwardAgent,
+			JobFiles:       req.JobFiles,
+			Strategy:       req.Strategy,
+			ServerSelector: req.ServerSelector,
+			RetryPolicy:    req.RetryPolicy,
+		})
+		return
+	}
+	if req.ServerSelector.IsSet() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "give either server_id or a selector, not both"})
+		return
+	}
+	if req.Strategy != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a strategy needs a selector"})
+		return
+	}
 
 	// Validate that the server exists and is active
 	var server models.Server
@@ -46,12 +89,16 @@ func (api *API) SubmitJob(c *gin.Context) {
 
 	// Create job
 	job := &models.Job{
-		Command:  req.Command,
-		Args:     req.Args,
-		ServerID: req.ServerID,
-		Timeout:  req.Timeout,
-		Priority: req.Priority,
-		Status:   models.StatusQueued,
+		Command:      req.Command,
+		Args:         req.Args,
+		ServerID:     req.ServerID,
+		Timeout:      req.Timeout,
+		Priority:     req.Priority,
+		Status:       initialStatus(runAt),
+		RunAt:        runAt,
+		ForwardAgent: req.ForwardAgent,
+		JobFiles:     req.JobFiles,
+		RetryPolicy:  req.RetryPolicy,
 	}
 
 	// Save to database
@@ -74,10 +121,117 @@ func (api *API) SubmitJob(c *gin.Context) {
 	}
 	slog.Info("Successfully pushed job to queue", "job_id", job.ID)
 
-	response := &models.JobResponse{Job: *job}
+	response := models.NewJobResponse(*job)
 	c.JSON(http.StatusCreated, response)
 }
 
+// SubmitJobBatch creates several jobs and queues them in a single round trip
+func (api *API) SubmitJobBatch(c *gin.Context) {
+	var req models.BatchJobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now().UTC()
+	runAts := make([]*time.Time, len(req.Jobs))
+	for i, jobReq := range req.Jobs {
+		if jobReq.ServerID == "" || jobReq.ServerSelector.IsSet() || jobReq.Strategy != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("job %d: server_id is required, selectors aren't supported in batches", i)})
+			return
+		}
+		if err := jobReq.RetryPolicy.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("job %d: %v", i, err)})
+			return
+		}
+		if err := jobReq.JobFiles.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("job %d: %v", i, err)})
+			return
+		}
+		runAt, err := jobReq.ScheduledTime(now)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("job %d: %v", i, err)})
+			return
+		}
+		runAts[i] = runAt
+	}
+
+	// Validate that every referenced server exists and is active
+	serverIDs := make([]string, 0, len(req.Jobs))
+	seen := make(map[string]bool)
+	for _, jobReq := range req.Jobs {
+		if !seen[jobReq.ServerID] {
+			seen[jobReq.ServerID] = true
+			serverIDs = append(serverIDs, jobReq.ServerID)
+		}
+	}
+	var activeCount int64
+	if err := api.db.Model(&models.Server{}).Where("id IN ? AND is_active = ?", serverIDs, true).Count(&activeCount).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate servers"})
+		return
+	}
+	if int(activeCount) != len(serverIDs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "One or more servers not found or inactive"})
+		return
+	}
+
+	jobs := make([]*models.Job, 0, len(req.Jobs))
+	for i, jobReq := range req.Jobs {
+		// Apply the same defaults as single submissions
+		if jobReq.Timeout <= 0 {
+			jobReq.Timeout = 300
+		}
+		if jobReq.Priority < 1 || jobReq.Priority > 10 {
+			jobReq.Priority = 5
+		}
+		jobs = append(jobs, &models.Job{
+			Command:      jobReq.Command,
+			Args:         jobReq.Args,
+			ServerID:     jobReq.ServerID,
+			Timeout:      jobReq.Timeout,
+			Priority:     jobReq.Priority,
+			Status:       initialStatus(runAts[i]),
+			RunAt:        runAts[i],
+			ForwardAgent: jobReq.ForwardAgent,
+			JobFiles:     jobReq.JobFiles,
+			RetryPolicy:  jobReq.RetryPolicy,
+		})
+	}
+
+	// Save to database
+	if err := api.db.Create(&jobs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create jobs"})
+		return
+	}
+
+	// Add to queue
+	slog.Info("About to push job batch to queue", "count", len(jobs))
+	if err := api.queue.PushBatch(jobs); err != nil {
+		slog.Error("Failed to push job batch to queue", "count", len(jobs), "error", err)
+		// Update job status to failed if can't queue
+		ids := make([]string, 0, len(jobs))
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+		}
+		api.db.Model(&models.Job{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status": models.StatusFailed,
+			"error":  "Failed to queue job: " + err.Error(),
+		})
+
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Job queue is full"})
+		return
+	}
+	slog.Info("Successfully pushed job batch to queue", "count", len(jobs))
+
+	responses := make([]*models.JobResponse, 0, len(jobs))
+	for _, job := range jobs {
+		responses = append(responses, models.NewJobResponse(*job))
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"jobs":  responses,
+		"count": len(responses),
+	})
+}
+
 // SubmitScriptJob handles shell script execution
 func (api *API) SubmitScriptJob(c *gin.Context) {
 	var req models.ScriptJobRequest
@@ -85,6 +239,47 @@ func (api *API) SubmitScriptJob(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := req.RetryPolicy.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.JobFiles.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	runAt, err := req.ScheduledTime(time.Now().UTC())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A selector fans the job out to every matching server
+	if req.ServerID == "" {
+		if !fanOutAllowed(c, req.ServerSelector, runAt) {
+			return
+		}
+		api.createRun(c, &models.RunRequest{
+			Script:         req.Script,
+			Args:           req.Args,
+			Shell:          req.Shell,
+			Timeout:        req.Timeout,
+			Priority:       req.Priority,
+			ForwardAgent:   req.ForwardAgent,
+			JobFiles:       req.JobFiles,
+			Strategy:       req.Strategy,
+			ServerSelector: req.ServerSelector,
+			RetryPolicy:    req.RetryPolicy,
+		})
+		return
+	}
+	if req.ServerSelector.IsSet() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "give either server_id or a selector, not both"})
+		return
+	}
+	if req.Strategy != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a strategy needs a selector"})
+		return
+	}
 
 	// Validate that the server exists and is active
 	var server models.Server
@@ -107,23 +302,8 @@ func (api *API) SubmitScriptJob(c *gin.Context) {
 		req.Priority = 5 // default priority
 	}
 
-	// Set default shell if not provided
-	shell := req.Shell
-	if shell == "" {
-		shell = "/bin/bash"
-	}
-
-	// Create a temporary script file name
-	scriptFileName := fmt.Sprintf("/tmp/script_%s.sh", time.Now().Format("20060102_150405"))
-
-	// Encode the script content to base64 to safely pass it through the command
-	scriptB64 := base64.StdEncoding.EncodeToString([]byte(req.Script))
-
-	// Create command to write script to file and execute it
-	// We use base64 to avoid issues with special characters and quotes
-	command := shell
-	args := fmt.Sprintf(`-c "echo '%s' | base64 -d > %s && chmod +x %s && %s %s; rm -f %s"`,
-		scriptB64, scriptFileName, scriptFileName, scriptFileName, req.Args, scriptFileName)
+	// Wrap the script into a command that writes it to a file on the target and runs it
+	command, args := models.ScriptCommand(req.Script, req.Args, req.Shell)
 
 	// Create job
 	job := &models.Job{
@@ -132,8 +312,12 @@ func (api *API) SubmitScriptJob(c *gin.Context) {
 		ServerID:       req.ServerID,
 		Timeout:        req.Timeout,
 		Priority:       req.Priority,
-		Status:         models.StatusQueued,
+		Status:         initialStatus(runAt),
+		RunAt:          runAt,
 		OriginalScript: req.Script, // Store the original script content
+		ForwardAgent:   req.ForwardAgent,
+		JobFiles:       req.JobFiles,
+		RetryPolicy:    req.RetryPolicy,
 	}
 
 	// Save to database
@@ -156,7 +340,7 @@ func (api *API) SubmitScriptJob(c *gin.Context) {
 	}
 	slog.Info("Successfully pushed script job to queue", "job_id", job.ID)
 
-	response := &models.JobResponse{Job: *job}
+	response := models.NewJobResponse(*job)
 	c.JSON(http.StatusCreated, response)
 }
 
@@ -217,13 +401,16 @@ func (api *API) DuplicateJob(c *gin.Context) {
 
 	// Create duplicated job
 	duplicatedJob := &models.Job{
-		Command:  originalJob.Command,
-		Args:     originalJob.Args,
-		ServerID: serverID,
-		Timeout:  timeout,
-		Priority: priority,
-		Status:   models.StatusQueued,
-		LogLevel: originalJob.LogLevel,
+		Command:      originalJob.Command,
+		Args:         originalJob.Args,
+		ServerID:     serverID,
+		Timeout:      timeout,
+		Priority:     priority,
+		Status:       models.StatusQueued,
+		LogLevel:     originalJob.LogLevel,
+		ForwardAgent: originalJob.ForwardAgent,
+		JobFiles:     originalJob.JobFiles,
+		RetryPolicy:  originalJob.RetryPolicy,
 	}
 
 	// Save to database
@@ -246,7 +433,7 @@ func (api *API) DuplicateJob(c *gin.Context) {
 	}
 	slog.Info("Successfully pushed duplicated job to queue", "job_id", duplicatedJob.ID)
 
-	response := &models.JobResponse{Job: *duplicatedJob}
+	response := models.NewJobResponse(*duplicatedJob)
 	c.JSON(http.StatusCreated, gin.H{
 		"message":      "Job duplicated successfully",
 		"original_job": originalJob.ID,
@@ -267,7 +454,7 @@ func (api *API) GetJob(c *gin.Context) {
 		return
 	}
 
-	response := &models.JobResponse{Job: job}
+	response := models.NewJobResponse(job)
 	response.CalculateDuration()
 
 	c.JSON(http.StatusOK, response)
@@ -287,7 +474,7 @@ func (api *API) CancelJob(c *gin.Context) {
 	}
 
 	// Check if job can be canceled
-	if job.Status != models.StatusQueued && job.Status != models.StatusRunning {
+	if job.Status != models.StatusPending && job.Status != models.StatusQueued && job.Status != models.StatusScheduled && job.Status != models.StatusRunning {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Job cannot be canceled"})
 		return
 	}
@@ -300,7 +487,7 @@ func (api *API) CancelJob(c *gin.Context) {
 	switch job.Status {
 	case models.StatusRunning:
 		// For running jobs, mark as canceled in database
-		// The worker will pick this up via polling and cancel the actual process
+		// The cancel broadcast below makes the worker stop the actual process
 		job.Status = models.StatusCanceled
 		now := time.Now().UTC()
 		job.FinishedAt = &now
@@ -315,18 +502,19 @@ func (api *API) CancelJob(c *gin.Context) {
 		if api.worker != nil {
 			if err := api.worker.CancelJob(jobID); err != nil {
 				slog.Warn("Failed to cancel job via worker API", "job_id", jobID, "error", err)
-				// Continue with database-based cancellation
+				// Continue with queue-based cancellation
 			} else {
 				slog.Info("Job canceled via worker API", "job_id", jobID)
 			}
 		}
 
+		api.publishCancel(jobID)
 		slog.Info("Job marked for cancellation", "job_id", jobID)
-		response = &models.JobResponse{Job: job}
+		response = models.NewJobResponse(job)
 		statusCode = http.StatusOK
 		message = "Job canceled successfully"
 
-	case models.StatusQueued:
+	case models.StatusPending, models.StatusQueued, models.StatusScheduled:
 		// For queued jobs, directly update the status since they haven't started yet
 		job.Status = models.StatusCanceled
 		now := time.Now().UTC()
@@ -338,9 +526,11 @@ func (api *API) CancelJob(c *gin.Context) {
 			return
 		}
 
+		// Drop it from the queue, and stop it if a worker had already picked it up
+		api.publishCancel(jobID)
 		slog.Info("Queued job canceled", "job_id", jobID)
 
-		response = &models.JobResponse{Job: job}
+		response = models.NewJobResponse(job)
 		statusCode = http.StatusOK
 		message = "Job canceled successfully"
 
@@ -357,6 +547,36 @@ func (api *API) CancelJob(c *gin.Context) {
 	})
 }
 
+// fanOutAllowed checks a job submitted without a server ID can be fanned out as a run,
+// writing an error response if not
+func fanOutAllowed(c *gin.Context, selector models.ServerSelector, runAt *time.Time) bool {
+	if !selector.IsSet() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "server_id or a selector is required"})
+		return false
+	}
+	if runAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "run_at and delay can't be combined with a selector"})
+		return false
+	}
+	return true
+}
+
+// initialStatus is the status a new job starts in, depending on whether it is scheduled
+func initialStatus(runAt *time.Time) models.JobStatus {
+	if runAt != nil {
+		return models.StatusScheduled
+	}
+	return models.StatusQueued
+}
+
+// publishCancel removes a job from the queue and broadcasts the cancel to workers.
+// The database already records the cancellation, so failures are only logged.
+func (api *API) publishCancel(jobID string) {
+	if err := api.queue.PublishCancelMessage(jobID); err != nil {
+		slog.Error("Failed to publish cancel message", "job_id", jobID, "error", err)
+	}
+}
+
 func (api *API) GetJobLogs(c *gin.Context) {
 	jobID := c.Param("id")
 
@@ -383,7 +603,7 @@ func (api *API) GetJobLogs(c *gin.Context) {
 		"command":     job.Command,
 		"args":        job.Args,
 		"exit_code":   job.ExitCode,
-		"output":      job.Output, // Combined output (backward compatibility)
+		"output":      job.Stdout, // Same as stdout (backward compatibility)
 		"error":       job.Error,  // Combined error (backward compatibility)
 		"stdout":      job.Stdout, // Explicit stdout
 		"stderr":      job.Stderr, // Explicit stderr
@@ -397,15 +617,29 @@ func (api *API) GetJobLogs(c *gin.Context) {
 
 	// Add metadata about log sizes
 	logs["metadata"] = gin.H{
-		"stdout_length": len(job.Stdout),
-		"stderr_length": len(job.Stderr),
+		"stdout_length": outputLength(job.Stdout, job.StdoutLog),
+		"stderr_length": outputLength(job.Stderr, job.StderrLog),
 		"has_output":    len(job.Stdout) > 0,
 		"has_errors":    len(job.Stderr) > 0,
+		// Output in storage is cut down to its tail here, get it whole from /stdout and /stderr
+		"stdout_truncated": job.StdoutLog != nil,
+		"stderr_truncated": job.StderrLog != nil,
+		// Output storage failed to take is missing from /stdout and /stderr too
+		"stdout_incomplete": job.StdoutLog != nil && job.StdoutLog.Lost > 0,
+		"stderr_incomplete": job.StderrLog != nil && job.StderrLog.Lost > 0,
 	}
 
 	c.JSON(http.StatusOK, logs)
 }
 
+// outputLength is the full length of an output stream, also when only its tail is inline
+func outputLength(text string, log *models.OutputLog) int64 {
+	if log != nil {
+		return log.Size
+	}
+	return int64(len(text))
+}
+
 func (api *API) GetJobStdout(c *gin.Context) {
 	jobID := c.Param("id")
 
@@ -420,8 +654,7 @@ func (api *API) GetJobStdout(c *gin.Context) {
 	}
 
 	// Return stdout as plain text for easier consumption
-	c.Header("Content-Type", "text/plain; charset=utf-8")
-	c.String(http.StatusOK, job.Stdout)
+	api.serveOutput(c, &job, job.Stdout, job.StdoutLog)
 }
 
 func (api *API) GetJobStderr(c *gin.Context) {
@@ -438,8 +671,34 @@ func (api *API) GetJobStderr(c *gin.Context) {
 	}
 
 	// Return stderr as plain text for easier consumption
+	api.serveOutput(c, &job, job.Stderr, job.StderrLog)
+}
+
+// serveOutput writes an output stream of a job as plain text, from storage if it was
+// moved there. Range requests are supported either way, so clients can page through
+// large output or fetch just its end.
+func (api *API) serveOutput(c *gin.Context, job *models.Job, text string, log *models.OutputLog) {
 	c.Header("Content-Type", "text/plain; charset=utf-8")
-	c.String(http.StatusOK, job.Stderr)
+	modified := job.UpdatedAt
+	if job.FinishedAt != nil {
+		modified = *job.FinishedAt
+	}
+
+	if log == nil {
+		http.ServeContent(c.Writer, c.Request, "", modified, strings.NewReader(text))
+		return
+	}
+	if api.storage == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Output is in storage, but no storage is configured"})
+		return
+	}
+	if log.Lost > 0 {
+		// The worker couldn't store all of it
+		c.Header("X-Output-Lost", strconv.FormatInt(log.Lost, 10))
+	}
+	reader := storage.NewLogReader(c.Request.Context(), api.storage, log.URL, log.Size, log.ChunkSize)
+	defer reader.Close()
+	http.ServeContent(c.Writer, c.Request, "", modified, reader)
 }
 
 func (api *API) ListJobs(c *gin.Context) {
@@ -450,6 +709,8 @@ func (api *API) ListJobs(c *gin.Context) {
 	limit := c.DefaultQuery("limit", "20")
 	status := c.Query("status")
 	serverID := c.Query("server_id")
+	scheduleID := c.Query("schedule_id")
+	runID := c.Query("run_id")
 	search := c.Query("search") // New search parameter
 	sortBy := c.DefaultQuery("sort_by", "created_at")
 	sortOrder := c.DefaultQuery("sort_order", "desc")
@@ -478,6 +739,14 @@ func (api *API) ListJobs(c *gin.Context) {
 		query = query.Where("server_id = ?", serverID)
 	}
 
+	if scheduleID != "" {
+		query = query.Where("schedule_id = ?", scheduleID)
+	}
+
+	if runID != "" {
+		query = query.Where("run_id = ?", runID)
+	}
+
 	// Apply search filter (search in command, args, and server name)
 	if search != "" {
 		searchPattern := "%" + search + "%"
@@ -522,9 +791,9 @@ func (api *API) ListJobs(c *gin.Context) {
 		return
 	}
 
-	var responses []models.JobResponse
+	var responses []*models.JobResponse
 	for _, job := range jobs {
-		response := models.JobResponse{Job: job}
+		response := models.NewJobResponse(job)
 		response.CalculateDuration()
 		responses = append(responses, response)
 	}
@@ -554,9 +823,24 @@ func (api *API) ListJobs(c *gin.Context) {
 	})
 }
 
+// StreamJob streams a job's status and live output as Server-Sent Events. Output events
+// carry their line number as the event ID, so a reconnecting EventSource resumes after the
+// last line it saw via Last-Event-ID.
 func (api *API) StreamJob(c *gin.Context) {
 	jobID := c.Param("id")
 
+	// Resume point, sent by the browser on reconnect (or as a query param by other clients)
+	lastLine := 0
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	if lastEventID != "" {
+		if n, err := strconv.Atoi(lastEventID); err == nil && n > 0 {
+			lastLine = n
+		}
+	}
+
 	// Set headers for Server-Sent Events
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
@@ -575,11 +859,11 @@ func (api *API) StreamJob(c *gin.Context) {
 	}
 
 	// Calculate duration if available
-	response := &models.JobResponse{Job: job}
+	response := models.NewJobResponse(job)
 	response.CalculateDuration()
 
 	// Send initial status
-	slog.Info("Starting SSE stream for job", "job_id", jobID, "status", job.Status)
+	slog.Info("Starting SSE stream for job", "job_id", jobID, "status", job.Status, "resume_after_line", lastLine)
 	c.SSEvent("status", response)
 	c.Writer.Flush()
 
@@ -589,46 +873,47 @@ func (api *API) StreamJob(c *gin.Context) {
 		return
 	}
 
-	// Create context for this SSE connection that cancels when client disconnects
-	ctx, cancel := context.WithCancel(c.Request.Context())
-	defer cancel()
+	// Every tab watching this job shares one upstream output consumer. Subscribing
+	// straight away, even while the job is queued, means no output is missed.
+	outputs, backlog := api.broadcaster.Subscribe(jobID)
+	defer api.broadcaster.Unsubscribe(jobID, outputs)
 
-	// Start consuming real-time output events from NetQueue
-	// This creates a unique temporary queue for this SSE client
-	var outputStarted bool
-	if job.Status == models.StatusRunning {
-		outputStarted = true
-		if err := api.queue.StartOutputConsumer(ctx, jobID, func(outputEvent queue.OutputEvent) {
-			// Send real-time output event to this SSE client
-			c.SSEvent("output", gin.H{
+	sendOutput := func(outputEvent broadcast.OutputEvent) {
+		// Skip lines the client already has
+		if outputEvent.LineNum <= lastLine {
+			return
+		}
+		lastLine = outputEvent.LineNum
+		c.Render(-1, sse.Event{
+			Id:    strconv.Itoa(outputEvent.LineNum),
+			Event: "output",
+			Data: gin.H{
 				"job_id":     outputEvent.JobID,
-				"output":     outputEvent.Output,
+				"output":     outputEvent.Content,
 				"is_stderr":  outputEvent.IsStderr,
-				"line_count": outputEvent.LineCount,
+				"line_count": outputEvent.LineNum,
 				"timestamp":  outputEvent.Timestamp,
-			})
-			c.Writer.Flush()
-		}); err != nil {
-			slog.Error("Failed to start output consumer", "job_id", jobID, "error", err)
-		}
+			},
+		})
+		c.Writer.Flush()
+	}
+	for _, outputEvent := range backlog {
+		sendOutput(outputEvent)
 	}
 
-	// Poll for updates every 500ms for running/queued jobs
-	ticker := time.NewTicker(500 * time.Millisecond)
+	// Output arrives as events, so the database is only polled for status changes
+	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
-	// Create a channel to detect client disconnect
-	clientGone := c.Writer.CloseNotify()
-
+	ctx := c.Request.Context()
 	for {
 		select {
-		case <-clientGone:
+		case <-ctx.Done():
 			slog.Info("SSE client disconnected", "job_id", jobID)
 			return
 
-		case <-ctx.Done():
-			slog.Info("SSE context cancelled", "job_id", jobID)
-			return
+		case outputEvent := <-outputs:
+			sendOutput(outputEvent)
 
 		case <-ticker.C:
 			// Fetch updated job status
@@ -637,38 +922,42 @@ func (api *API) StreamJob(c *gin.Context) {
 				return
 			}
 
-			response := &models.JobResponse{Job: job}
+			response := models.NewJobResponse(job)
 			response.CalculateDuration()
 
 			// Send status update
-			slog.Info("Sending SSE status update", "job_id", jobID, "status", job.Status)
+			slog.Debug("Sending SSE status update", "job_id", jobID, "status", job.Status)
 			c.SSEvent("status", response)
 			c.Writer.Flush()
 
 			// If job finished, send complete event and stop streaming
 			if job.Status == models.StatusCompleted || job.Status == models.StatusFailed || job.Status == models.StatusCanceled {
+				// Flush output that arrived before the status change
+				for drained := false; !drained; {
+					select {
+					case outputEvent := <-outputs:
+						sendOutput(outputEvent)
+					default:
+						drained = true
+					}
+				}
 				slog.Info("Job finished, sending complete event", "job_id", jobID, "status", job.Status)
 				c.SSEvent("complete", response)
 				return
 			}
-
-			// If job just started running and we haven't started output consumer yet, start it
-			if job.Status == models.StatusRunning && !outputStarted {
-				outputStarted = true
-				if err := api.queue.StartOutputConsumer(ctx, jobID, func(outputEvent queue.OutputEvent) {
-					// Send real-time output event to this SSE client
-					c.SSEvent("output", gin.H{
-						"job_id":     outputEvent.JobID,
-						"output":     outputEvent.Output,
-						"is_stderr":  outputEvent.IsStderr,
-						"line_count": outputEvent.LineCount,
-						"timestamp":  outputEvent.Timestamp,
-					})
-					c.Writer.Flush()
-				}); err != nil {
-					slog.Error("Failed to start output consumer", "job_id", jobID, "error", err)
-				}
-			}
 		}
 	}
 }
+
+// streamOutputSource feeds a job's output from the queue into the broadcaster
+func (api *API) streamOutputSource(ctx context.Context, jobID string, publish func(broadcast.OutputEvent)) error {
+	return api.queue.StartOutputConsumer(ctx, jobID, func(outputEvent queue.OutputEvent) {
+		publish(broadcast.OutputEvent{
+			JobID:     outputEvent.JobID,
+			Content:   outputEvent.Output,
+			IsStderr:  outputEvent.IsStderr,
+			LineNum:   outputEvent.LineCount,
+			Timestamp: outputEvent.Timestamp,
+		})
+	})
+}
@@ -0,0 +1,275 @@
+package api
+
+import (
+	"errors"
+	"job-executor/internal/fanout"
+	"job-executor/internal/models"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// SubmitRun fans a command out to several servers, creating one job per server
+func (api *API) SubmitRun(c *gin.Context) {
+	var req models.RunRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	api.createRun(c, &req)
+}
+
+// createRun creates a multi-target run and its jobs, then releases the first of them
+func (api *API) createRun(c *gin.Context, req *models.RunRequest) {
+	if (req.Command == "") == (req.Script == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of command or script is required"})
+		return
+	}
+	if err := req.RetryPolicy.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.JobFiles.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var strategy models.RolloutStrategy
+	if req.Strategy != nil {
+		strategy = *req.Strategy
+	}
+	if err := strategy.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strategy.Type == "" {
+		strategy.Type = models.StrategyParallel
+	}
+
+	servers, err := api.resolveRunServers(req.ServerIDs, req.ServerSelector)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Set defaults
+	if req.Timeout <= 0 {
+		req.Timeout = 300
+	}
+	if req.Priority < 1 || req.Priority > 10 {
+		req.Priority = 5
+	}
+
+	command, args := req.Command, req.Args
+	if req.Script != "" {
+		command, args = models.ScriptCommand(req.Script, req.Args, req.Shell)
+	}
+
+	run := &models.Run{
+		Command:     req.Command,
+		Args:        req.Args,
+		Script:      req.Script,
+		Shell:       req.Shell,
+		Status:      models.RunRunning,
+		MaxInFlight: req.MaxInFlight,
+		MaxFailures: req.MaxFailures,
+		Strategy:    strategy,
+	}
+	err = api.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(run).Error; err != nil {
+			return err
+		}
+		for _, server := range servers {
+			// Jobs wait as pending until the run releases them to the queue
+			job := &models.Job{
+				Command:        command,
+				Args:           args,
+				ServerID:       server.ID,
+				Timeout:        req.Timeout,
+				Priority:       req.Priority,
+				Status:         models.StatusPending,
+				OriginalScript: req.Script,
+				RunID:          &run.ID,
+				ForwardAgent:   req.ForwardAgent,
+				JobFiles:       req.JobFiles,
+				RetryPolicy:    req.RetryPolicy,
+			}
+			if err := tx.Create(job).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create run"})
+		return
+	}
+
+	// Release the first jobs right away rather than on the controller's next pass
+	if err := fanout.New(api.db, api.queue).Advance(run.ID); err != nil {
+		slog.Error("Failed to start run", "run_id", run.ID, "error", err)
+	}
+
+	api.respondRun(c, http.StatusCreated, run.ID)
+}
+
+// resolveRunServers loads the servers a run targets: the listed ones, each of which must
+// exist and be active, or the active servers picked by the selector
+func (api *API) resolveRunServers(serverIDs []string, selector models.ServerSelector) ([]models.Server, error) {
+	if len(serverIDs) > 0 && selector.IsSet() {
+		return nil, errors.New("give either server_ids or a selector, not both")
+	}
+	if selector.IsSet() {
+		scope, err := selector.Scope()
+		if err != nil {
+			return nil, err
+		}
+		var servers []models.Server
+		if err := api.db.Scopes(scope).Where("is_active = ?", true).Order("name").Find(&servers).Error; err != nil {
+			return nil, errors.New("Failed to select servers")
+		}
+		if len(servers) == 0 {
+			return nil, errors.New("No active servers match the selector")
+		}
+		return servers, nil
+	}
+	if len(serverIDs) == 0 {
+		return nil, errors.New("server_ids or a selector is required")
+	}
+
+	seen := make(map[string]bool, len(serverIDs))
+	var ids []string
+	for _, id := range serverIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	var servers []models.Server
+	if err := api.db.Where("id IN ? AND is_active = ?", ids, true).Find(&servers).Error; err != nil {
+		return nil, errors.New("Failed to validate servers")
+	}
+	if len(servers) != len(ids) {
+		found := make(map[string]bool, len(servers))
+		for _, server := range servers {
+			found[server.ID] = true
+		}
+		for _, id := range ids {
+			if !found[id] {
+				return nil, errors.New("Server not found or inactive: " + id)
+			}
+		}
+	}
+	return servers, nil
+}
+
+func (api *API) GetRun(c *gin.Context) {
+	api.respondRun(c, http.StatusOK, c.Param("id"))
+}
+
+func (api *API) ListRuns(c *gin.Context) {
+	query := api.db.Model(&models.Run{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	runs := []models.Run{}
+	if err := query.Order("created_at DESC").Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch runs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+func (api *API) CancelRun(c *gin.Context) {
+	api.changeRun(c, "cancel", fanout.New(api.db, api.queue).Cancel)
+}
+
+// PauseRun stops a run from releasing more jobs until it is resumed
+func (api *API) PauseRun(c *gin.Context) {
+	api.changeRun(c, "pause", fanout.New(api.db, api.queue).Pause)
+}
+
+func (api *API) ResumeRun(c *gin.Context) {
+	api.changeRun(c, "resume", fanout.New(api.db, api.queue).Resume)
+}
+
+// AbortRun stops a rollout, canceling the jobs it hasn't released yet
+func (api *API) AbortRun(c *gin.Context) {
+	api.changeRun(c, "abort", fanout.New(api.db, api.queue).Abort)
+}
+
+// changeRun applies one of the run controller's actions and responds with the run
+func (api *API) changeRun(c *gin.Context, action string, apply func(runID string) error) {
+	runID := c.Param("id")
+
+	if err := apply(runID); err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Run not found"})
+		case fanout.ErrNotRunning:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Run is not running"})
+		case fanout.ErrNotPaused:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Run is not paused"})
+		default:
+			slog.Error("Failed to change run", "run_id", runID, "action", action, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " run"})
+		}
+		return
+	}
+
+	api.respondRun(c, http.StatusOK, runID)
+}
+
+// respondRun writes a run with the outcome on each host and counts by status and exit code
+func (api *API) respondRun(c *gin.Context, status int, runID string) {
+	var run models.Run
+	if err := api.db.Preload("Jobs", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at")
+	}).Preload("Jobs.Server").First(&run, "id = ?", runID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Run not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch run"})
+		return
+	}
+
+	response := &models.RunResponse{
+		StatusCounts:   make(map[models.JobStatus]int),
+		ExitCodeCounts: make(map[string]int),
+		Hosts:          make([]models.HostResult, 0, len(run.Jobs)),
+	}
+	for _, job := range run.Jobs {
+		response.StatusCounts[job.Status]++
+		if job.ExitCode != nil {
+			response.ExitCodeCounts[strconv.Itoa(*job.ExitCode)]++
+		}
+
+		host := models.HostResult{
+			ServerID:   job.ServerID,
+			JobID:      job.ID,
+			Batch:      job.Batch,
+			Status:     job.Status,
+			ExitCode:   job.ExitCode,
+			Stdout:     job.Stdout,
+			Stderr:     job.Stderr,
+			StartedAt:  job.StartedAt,
+			FinishedAt: job.FinishedAt,
+		}
+		if job.Server != nil {
+			host.ServerName = job.Server.Name
+			host.Hostname = job.Server.Hostname
+		}
+		response.Hosts = append(response.Hosts, host)
+	}
+
+	// The per-host results replace the raw job list
+	run.Jobs = nil
+	response.Run = run
+	c.JSON(status, response)
+}
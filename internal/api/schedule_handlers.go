@@ -0,0 +1,209 @@
+package api
+
+import (
+	"errors"
+	"job-executor/internal/models"
+	"job-executor/internal/scheduler"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func (api *API) CreateSchedule(c *gin.Context) {
+	var req models.ScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	schedule := &models.Schedule{
+		Name:          req.Name,
+		CronExpr:      req.CronExpr,
+		Timezone:      req.Timezone,
+		Command:       req.Command,
+		Args:          req.Args,
+		Script:        req.Script,
+		Shell:         req.Shell,
+		ServerID:      req.ServerID,
+		Timeout:       req.Timeout,
+		Priority:      req.Priority,
+		OverlapPolicy: req.OverlapPolicy,
+		Enabled:       true,
+		RetryPolicy:   req.RetryPolicy,
+	}
+	if req.Enabled != nil {
+		schedule.Enabled = *req.Enabled
+	}
+
+	if status, err := api.prepareSchedule(schedule); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := api.db.Create(schedule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, schedule)
+}
+
+func (api *API) GetSchedule(c *gin.Context) {
+	var schedule models.Schedule
+	if err := api.db.First(&schedule, "id = ?", c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+func (api *API) UpdateSchedule(c *gin.Context) {
+	var schedule models.Schedule
+	if err := api.db.First(&schedule, "id = ?", c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedule"})
+		return
+	}
+
+	var req models.ScheduleUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Update fields if provided
+	if req.Name != "" {
+		schedule.Name = req.Name
+	}
+	if req.CronExpr != "" {
+		schedule.CronExpr = req.CronExpr
+	}
+	if req.Timezone != "" {
+		schedule.Timezone = req.Timezone
+	}
+	if req.Command != nil {
+		schedule.Command = *req.Command
+	}
+	if req.Args != nil {
+		schedule.Args = *req.Args
+	}
+	if req.Script != nil {
+		schedule.Script = *req.Script
+	}
+	if req.Shell != "" {
+		schedule.Shell = req.Shell
+	}
+	if req.ServerID != "" {
+		schedule.ServerID = req.ServerID
+	}
+	if req.Timeout != nil {
+		schedule.Timeout = *req.Timeout
+	}
+	if req.Priority != nil {
+		schedule.Priority = *req.Priority
+	}
+	if req.OverlapPolicy != "" {
+		schedule.OverlapPolicy = req.OverlapPolicy
+	}
+	if req.Enabled != nil {
+		schedule.Enabled = *req.Enabled
+	}
+	if req.RetryPolicy != nil {
+		schedule.RetryPolicy = *req.RetryPolicy
+	}
+
+	if status, err := api.prepareSchedule(&schedule); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := api.db.Save(&schedule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedule)
+}
+
+func (api *API) DeleteSchedule(c *gin.Context) {
+	result := api.db.Delete(&models.Schedule{}, "id = ?", c.Param("id"))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete schedule"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+
+	// Jobs it already started are kept as history
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted successfully"})
+}
+
+func (api *API) ListSchedules(c *gin.Context) {
+	query := api.db.Model(&models.Schedule{})
+
+	if enabled := c.Query("enabled"); enabled != "" {
+		query = query.Where("enabled = ?", enabled == "true")
+	}
+	if serverID := c.Query("server_id"); serverID != "" {
+		query = query.Where("server_id = ?", serverID)
+	}
+
+	schedules := []models.Schedule{}
+	if err := query.Order("created_at DESC").Find(&schedules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schedules": schedules})
+}
+
+// prepareSchedule validates a schedule, fills in defaults and works out when it runs
+// next. On failure it returns the HTTP status to respond with.
+func (api *API) prepareSchedule(schedule *models.Schedule) (int, error) {
+	if (schedule.Command == "") == (schedule.Script == "") {
+		return http.StatusBadRequest, errors.New("exactly one of command or script is required")
+	}
+	if err := schedule.RetryPolicy.Validate(); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	var server models.Server
+	if err := api.db.First(&server, "id = ? AND is_active = ?", schedule.ServerID, true).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return http.StatusBadRequest, errors.New("Server not found or inactive")
+		}
+		return http.StatusInternalServerError, errors.New("Failed to validate server")
+	}
+
+	if schedule.Timezone == "" {
+		schedule.Timezone = "UTC"
+	}
+	if schedule.Timeout <= 0 {
+		schedule.Timeout = 300
+	}
+	if schedule.Priority < 1 || schedule.Priority > 10 {
+		schedule.Priority = 5
+	}
+	if schedule.OverlapPolicy == "" {
+		schedule.OverlapPolicy = models.OverlapSkip
+	}
+
+	next, err := scheduler.NextRun(schedule.CronExpr, schedule.Timezone, time.Now().UTC())
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	schedule.NextRunAt = &next
+	return 0, nil
+}
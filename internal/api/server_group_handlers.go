@@ -0,0 +1,169 @@
+package api
+
+import (
+	"job-executor/internal/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func (api *API) CreateServerGroup(c *gin.Context) {
+	var req models.ServerGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	servers, ok := api.groupMembers(c, req.ServerIDs)
+	if !ok {
+		return
+	}
+
+	group := &models.ServerGroup{
+		Name:        req.Name,
+		Description: req.Description,
+		Servers:     servers,
+	}
+	if err := api.db.Omit("Servers.*").Create(group).Error; err != nil {
+		var existing int64
+		api.db.Model(&models.ServerGroup{}).Where("name = ?", req.Name).Count(&existing)
+		if existing > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Server group name already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create server group"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.NewServerGroupResponse(*group))
+}
+
+func (api *API) GetServerGroup(c *gin.Context) {
+	var group models.ServerGroup
+	if err := api.db.Preload("Servers").First(&group, "id = ?", c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Server group not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch server group"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.NewServerGroupResponse(group))
+}
+
+func (api *API) UpdateServerGroup(c *gin.Context) {
+	var group models.ServerGroup
+	if err := api.db.First(&group, "id = ?", c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Server group not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch server group"})
+		return
+	}
+
+	var req models.ServerGroupUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Update fields if provided
+	if req.Name != "" {
+		group.Name = req.Name
+	}
+	if req.Description != nil {
+		group.Description = *req.Description
+	}
+
+	var servers []models.Server
+	if req.ServerIDs != nil {
+		var ok bool
+		if servers, ok = api.groupMembers(c, req.ServerIDs); !ok {
+			return
+		}
+	}
+
+	err := api.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&group).Error; err != nil {
+			return err
+		}
+		if req.ServerIDs == nil {
+			return nil
+		}
+		return tx.Model(&group).Omit("Servers.*").Association("Servers").Replace(servers)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update server group"})
+		return
+	}
+
+	api.db.Preload("Servers").First(&group, "id = ?", group.ID)
+	c.JSON(http.StatusOK, models.NewServerGroupResponse(group))
+}
+
+func (api *API) DeleteServerGroup(c *gin.Context) {
+	var group models.ServerGroup
+	if err := api.db.First(&group, "id = ?", c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Server group not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch server group"})
+		return
+	}
+
+	// Deleting the group removes its memberships, the servers themselves stay
+	err := api.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&group).Association("Servers").Clear(); err != nil {
+			return err
+		}
+		return tx.Delete(&group).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete server group"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Server group deleted successfully"})
+}
+
+func (api *API) ListServerGroups(c *gin.Context) {
+	groups := []models.ServerGroup{}
+	if err := api.db.Preload("Servers").Order("name").Find(&groups).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch server groups"})
+		return
+	}
+
+	responses := make([]models.ServerGroupResponse, 0, len(groups))
+	for _, group := range groups {
+		responses = append(responses, *models.NewServerGroupResponse(group))
+	}
+	c.JSON(http.StatusOK, gin.H{"server_groups": responses})
+}
+
+// groupMembers loads the servers to put in a group, writing an error response if any is missing
+func (api *API) groupMembers(c *gin.Context, serverIDs []string) ([]models.Server, bool) {
+	servers := []models.Server{}
+	if len(serverIDs) == 0 {
+		return servers, true
+	}
+	if err := api.db.Where("id IN ?", serverIDs).Find(&servers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate servers"})
+		return nil, false
+	}
+
+	found := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		found[server.ID] = true
+	}
+	for _, id := range serverIDs {
+		if !found[id] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Server not found: " + id})
+			return nil, false
+		}
+	}
+	return servers, true
+}
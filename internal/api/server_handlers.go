@@ -1,9 +1,11 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"job-executor/internal/config"
 	"job-executor/internal/models"
+	"job-executor/internal/queue"
 	"job-executor/internal/ssh"
 	"log/slog"
 	"net/http"
@@ -12,14 +14,20 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// serverCheckTimeout is how long to wait for a worker's connection test, a little longer
+// than the worker gives it
+const serverCheckTimeout = 70 * time.Second
+
 // ServerStatus represents the status of a server check
 type ServerStatus struct {
 	Status    string    `json:"status"`
 	Message   string    `json:"message"`
 	CheckedAt time.Time `json:"checked_at"`
+	Via       string    `json:"via,omitempty"` // jump host probed in place of the server
 }
 
 // checkServerReachability checks if a server is reachable using netcat or platform-specific commands
@@ -69,6 +77,45 @@ func checkServerReachability(hostname string, port int) ServerStatus {
 	}
 }
 
+// validateCertificate checks that a certificate, if given, is an OpenSSH user
+// certificate and that there is a key to go with it
+func validateCertificate(certificate string, hasKey bool) error {
+	if certificate == "" {
+		return nil
+	}
+	if _, err := ssh.ParseCertificate(certificate); err != nil {
+		return err
+	}
+	if !hasKey {
+		return errors.New("Private key, PEM file content, or PEM file URL is required with a certificate")
+	}
+	return nil
+}
+
+// serverReachability probes a server's SSH port. A server behind jump hosts can't be
+// probed from here, so the first jump host, the one dialed directly, is probed instead.
+func (api *API) serverReachability(server *models.Server) ServerStatus {
+	if server.JumpHostID == nil {
+		return checkServerReachability(server.Hostname, server.Port)
+	}
+
+	chain, err := models.JumpChain(api.db, server)
+	if err != nil {
+		return ServerStatus{
+			Status:    "disconnected",
+			Message:   fmt.Sprintf("Server %s:%d has invalid jump hosts: %v", server.Hostname, server.Port, err),
+			CheckedAt: time.Now().UTC(),
+		}
+	}
+
+	entry := chain[0]
+	status := checkServerReachability(entry.Hostname, entry.Port)
+	status.Via = entry.Name
+	status.Message = fmt.Sprintf("Server %s:%d sits behind jump host %s and isn't probed directly. %s",
+		server.Hostname, server.Port, entry.Name, status.Message)
+	return status
+}
+
 // UploadPemFile handles PEM file uploads to object storage
 func (api *API) UploadPemFile(c *gin.Context) {
 	file, header, err := c.Request.FormFile("pem_file")
@@ -125,22 +172,54 @@ func (api *API) CreateServer(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Private key, PEM file content, or PEM file URL is required for key authentication"})
 		return
 	}
+	if err := validateCertificate(req.Certificate, req.PrivateKey != "" || req.PemFile != "" || req.PemFileURL != ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := models.ValidateLabels(req.Labels); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hostKeys, err := models.NormalizeHostKeys(req.HostKeys)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.JumpHostID != nil && *req.JumpHostID == "" {
+		req.JumpHostID = nil
+	}
 
 	// Create server
 	server := &models.Server{
-		Name:       req.Name,
-		Hostname:   req.Hostname,
-		Port:       req.Port,
-		User:       req.User,
-		AuthType:   req.AuthType,
-		Password:   req.Password,
-		PrivateKey: req.PrivateKey,
-		PemFile:    req.PemFile,
-		PemFileURL: req.PemFileURL,
-		IsActive:   *req.IsActive,
-	}
-
-	// Save to database
+		Name:        req.Name,
+		Hostname:    req.Hostname,
+		Port:        req.Port,
+		User:        req.User,
+		AuthType:    req.AuthType,
+		Password:    req.Password,
+		PrivateKey:  req.PrivateKey,
+		PemFile:     req.PemFile,
+		PemFileURL:  req.PemFileURL,
+		IsActive:    *req.IsActive,
+		Certificate: req.Certificate,
+		HostKeys:    hostKeys,
+		JumpHostID:  req.JumpHostID,
+		Labels:      models.LabelsFromMap(req.Labels),
+	}
+	if err := server.ValidateReferences(api.secretsDir); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := models.JumpChain(api.db, server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid jump host: " + err.Error()})
+		return
+	}
+	if err := server.SealCredentials(api.keys); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt credentials"})
+		return
+	}
+
+	// Save to database, labels are created along with the server
 	if err := api.db.Create(server).Error; err != nil {
 		if err.Error() == "UNIQUE constraint failed: servers.name" {
 			c.JSON(http.StatusConflict, gin.H{"error": "Server name already exists"})
@@ -150,7 +229,7 @@ func (api *API) CreateServer(c *gin.Context) {
 		return
 	}
 
-	response := &models.ServerResponse{Server: *server}
+	response := models.NewServerResponse(*server)
 	c.JSON(http.StatusCreated, response)
 }
 
@@ -158,7 +237,7 @@ func (api *API) GetServer(c *gin.Context) {
 	serverID := c.Param("id")
 
 	var server models.Server
-	if err := api.db.First(&server, "id = ?", serverID).Error; err != nil {
+	if err := api.db.Preload("Labels").Preload("Groups").First(&server, "id = ?", serverID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
 			return
@@ -167,7 +246,7 @@ func (api *API) GetServer(c *gin.Context) {
 		return
 	}
 
-	response := &models.ServerResponse{Server: server}
+	response := models.NewServerResponse(server)
 	c.JSON(http.StatusOK, response)
 }
 
@@ -221,6 +300,15 @@ func (api *API) UpdateServer(c *gin.Context) {
 	if req.IsActive != nil {
 		server.IsActive = *req.IsActive
 	}
+	if req.Certificate != nil {
+		server.Certificate = *req.Certificate
+	}
+	if req.JumpHostID != nil {
+		server.JumpHostID = req.JumpHostID
+		if *req.JumpHostID == "" {
+			server.JumpHostID = nil
+		}
+	}
 
 	// Validate auth type requirements after update
 	if server.AuthType == "password" && server.Password == "" {
@@ -231,9 +319,50 @@ func (api *API) UpdateServer(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Private key, PEM file content, or PEM file URL is required for key authentication"})
 		return
 	}
+	if err := validateCertificate(server.Certificate, server.PrivateKey != "" || server.PemFile != "" || server.PemFileURL != ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := server.ValidateReferences(api.secretsDir); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := models.ValidateLabels(req.Labels); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := models.JumpChain(api.db, &server); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid jump host: " + err.Error()})
+		return
+	}
+
+	// Only the credentials just given are encrypted, the stored ones already are
+	if err := server.SealCredentials(api.keys); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt credentials"})
+		return
+	}
 
-	// Save changes
-	if err := api.db.Save(&server).Error; err != nil {
+	// Save changes, replacing the labels if new ones were given
+	err := api.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&server).Error; err != nil {
+			return err
+		}
+		if req.Labels == nil {
+			return nil
+		}
+		if err := tx.Where("server_id = ?", server.ID).Delete(&models.ServerLabel{}).Error; err != nil {
+			return err
+		}
+		labels := models.LabelsFromMap(req.Labels)
+		for i := range labels {
+			labels[i].ServerID = server.ID
+		}
+		if len(labels) > 0 {
+			return tx.Create(&labels).Error
+		}
+		return nil
+	})
+	if err != nil {
 		if err.Error() == "UNIQUE constraint failed: servers.name" {
 			c.JSON(http.StatusConflict, gin.H{"error": "Server name already exists"})
 			return
@@ -242,7 +371,8 @@ func (api *API) UpdateServer(c *gin.Context) {
 		return
 	}
 
-	response := &models.ServerResponse{Server: server}
+	api.db.Preload("Labels").Preload("Groups").First(&server, "id = ?", server.ID)
+	response := models.NewServerResponse(server)
 	c.JSON(http.StatusOK, response)
 }
 
@@ -262,7 +392,7 @@ func (api *API) DeleteServer(c *gin.Context) {
 
 	// Check if there are any active jobs for this server
 	var activeJobCount int64
-	if err := api.db.Model(&models.Job{}).Where("server_id = ? AND status IN ?", serverID, []string{"queued", "running"}).Count(&activeJobCount).Error; err != nil {
+	if err := api.db.Model(&models.Job{}).Where("server_id = ? AND status IN ?", serverID, models.ActiveStatuses).Count(&activeJobCount).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check active jobs"})
 		return
 	}
@@ -270,12 +400,44 @@ func (api *API) DeleteServer(c *gin.Context) {
 	if activeJobCount > 0 {
 		c.JSON(http.StatusConflict, gin.H{
 			"error":       "Cannot delete server with active jobs",
-			"details":     "This server has jobs that are currently queued or running. Please wait for them to complete or cancel them before deleting the server.",
+			"details":     "This server has jobs that are currently queued, scheduled or running. Please wait for them to complete or cancel them before deleting the server.",
 			"active_jobs": activeJobCount,
 		})
 		return
 	}
 
+	// Schedules keep pointing at the server, they have to be moved or deleted first
+	var scheduleCount int64
+	if err := api.db.Model(&models.Schedule{}).Where("server_id = ?", serverID).Count(&scheduleCount).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check schedules"})
+		return
+	}
+
+	if scheduleCount > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":     "Cannot delete server with schedules",
+			"details":   "This server is the target of one or more schedules. Move them to another server or delete them first.",
+			"schedules": scheduleCount,
+		})
+		return
+	}
+
+	// Servers reached through this one would be cut off
+	var jumpingCount int64
+	if err := api.db.Model(&models.Server{}).Where("jump_host_id = ?", serverID).Count(&jumpingCount).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check jump host use"})
+		return
+	}
+
+	if jumpingCount > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Cannot delete a server used as jump host",
+			"details": "Other servers are connected to through this one. Point them at another jump host first.",
+			"servers": jumpingCount,
+		})
+		return
+	}
+
 	// Check for force deletion parameter
 	force := c.Query("force") == "true"
 
@@ -342,13 +504,21 @@ func (api *API) ListServers(c *gin.Context) {
 	// Get query parameters
 	active := c.Query("active")
 
-	query := api.db.Model(&models.Server{})
+	query := api.db.Model(&models.Server{}).Preload("Labels").Preload("Groups")
 
 	if active != "" {
 		isActive := active == "true"
 		query = query.Where("is_active = ?", isActive)
 	}
 
+	// Filter by label selector and group
+	scope, err := serverSelectorFromQuery(c).Scope()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query = query.Scopes(scope)
+
 	if err := query.Order("created_at DESC").Find(&servers).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch servers"})
 		return
@@ -356,75 +526,77 @@ func (api *API) ListServers(c *gin.Context) {
 
 	var responses []models.ServerResponse
 	for _, server := range servers {
-		response := models.ServerResponse{Server: server}
-		responses = append(responses, response)
+		responses = append(responses, *models.NewServerResponse(server))
 	}
 
 	c.JSON(http.StatusOK, gin.H{"servers": responses})
 }
 
+// TestServerConnection has a worker connect to a server to check its credentials. If
+// the server has no pinned host key yet, the key it presents is pinned (trust on first use).
 func (api *API) TestServerConnection(c *gin.Context) {
-	serverID := c.Param("id")
-
-	var server models.Server
-	if err := api.db.First(&server, "id = ?", serverID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch server"})
+	server, ok := api.findServer(c)
+	if !ok {
 		return
 	}
 
-	// Create SSH client configuration
-	sshConfig := &config.SSHConfig{
-		Host:       server.Hostname,
-		Port:       fmt.Sprintf("%d", server.Port),
-		User:       server.User,
-		Password:   server.Password,
-		PrivateKey: server.PrivateKey,
-		PemFileURL: server.PemFileURL,
-	}
-
-	// Use PEM file if provided (legacy support)
-	if server.PemFile != "" {
-		sshConfig.PrivateKey = server.PemFile
-	}
-
-	// Create SSH client with storage service if needed
-	var sshClient *ssh.Client
-	if server.PemFileURL != "" {
-		sshClient = ssh.NewClientWithStorage(sshConfig, api.storage)
-	} else {
-		sshClient = ssh.NewClient(sshConfig)
+	result, ok := api.checkServer(c, queue.ServerCheck{ServerID: server.ID})
+	if !ok {
+		return
 	}
-
-	// Test the connection
-	if err := sshClient.TestConnection(c.Request.Context()); err != nil {
+	if result.Status != "connection_successful" {
 		api.logger.Error("SSH connection test failed",
 			slog.String("server_id", server.ID),
 			slog.String("hostname", server.Hostname),
-			slog.Any("error", err))
+			slog.String("error", result.Error))
 
 		c.JSON(http.StatusServiceUnavailable, gin.H{
 			"server_id": server.ID,
-			"status":    "connection_failed",
-			"error":     err.Error(),
+			"status":    result.Status,
+			"error":     result.Error,
 		})
 		return
 	}
 
 	api.logger.Info("SSH connection test successful",
 		slog.String("server_id", server.ID),
-		slog.String("hostname", server.Hostname))
+		slog.String("hostname", server.Hostname),
+		slog.String("host_key", result.HostKey),
+		slog.Bool("host_key_pinned", result.HostKeyPinned))
 
 	c.JSON(http.StatusOK, gin.H{
-		"server_id": server.ID,
-		"status":    "connection_successful",
-		"message":   "Successfully connected to the server",
+		"server_id":       server.ID,
+		"status":          "connection_successful",
+		"message":         "Successfully connected to the server",
+		"host_key":        result.HostKey,
+		"host_key_pinned": result.HostKeyPinned,
 	})
 }
 
+// checkServer hands a server check to one of the workers, the only ones able to decrypt
+// server credentials, and waits for the result. It writes an error response if no
+// worker took it.
+func (api *API) checkServer(c *gin.Context, check queue.ServerCheck) (*queue.ServerCheckResult, bool) {
+	check.ID = uuid.New().String()
+	ctx, cancel := context.WithTimeout(c.Request.Context(), serverCheckTimeout)
+	defer cancel()
+
+	result, err := api.queue.CheckServer(ctx, check)
+	if err != nil {
+		api.logger.Error("Server check failed", slog.String("server_id", check.ServerID), slog.Any("error", err))
+		switch {
+		case errors.Is(err, queue.ErrNoWorker):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No worker is running to connect to the server"})
+		case errors.Is(err, context.DeadlineExceeded):
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Timed out waiting for a worker to connect to the server"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hand the check to a worker"})
+		}
+		return nil, false
+	}
+	return result, true
+}
+
 // CheckServerStatus checks if a server is reachable using netcat (nc) command
 func (api *API) CheckServerStatus(c *gin.Context) {
 	serverID := c.Param("id")
@@ -440,7 +612,7 @@ func (api *API) CheckServerStatus(c *gin.Context) {
 	}
 
 	// Use netcat to check if port is open
-	status := checkServerReachability(server.Hostname, server.Port)
+	status := api.serverReachability(&server)
 
 	api.logger.Info("Server status check completed",
 		slog.String("server_id", server.ID),
@@ -456,6 +628,7 @@ func (api *API) CheckServerStatus(c *gin.Context) {
 		"status":      status.Status,
 		"message":     status.Message,
 		"checked_at":  status.CheckedAt,
+		"via":         status.Via,
 	})
 }
 
@@ -473,6 +646,14 @@ func (api *API) CheckAllServersStatus(c *gin.Context) {
 		query = query.Where("is_active = ?", isActive)
 	}
 
+	// Filter by label selector and group
+	scope, err := serverSelectorFromQuery(c).Scope()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query = query.Scopes(scope)
+
 	if err := query.Order("created_at DESC").Find(&servers).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch servers"})
 		return
@@ -481,7 +662,7 @@ func (api *API) CheckAllServersStatus(c *gin.Context) {
 	// Check status for each server
 	var serverStatuses []gin.H
 	for _, server := range servers {
-		status := checkServerReachability(server.Hostname, server.Port)
+		status := api.serverReachability(&server)
 
 		serverStatuses = append(serverStatuses, gin.H{
 			"server_id":   server.ID,
@@ -491,6 +672,7 @@ func (api *API) CheckAllServersStatus(c *gin.Context) {
 			"status":      status.Status,
 			"message":     status.Message,
 			"checked_at":  status.CheckedAt,
+			"via":         status.Via,
 		})
 	}
 
@@ -581,3 +763,11 @@ func (api *API) GetSystemInfo(c *gin.Context) {
 		"timestamp":      time.Now().UTC().Format(time.RFC3339),
 	})
 }
+
+// serverSelectorFromQuery reads the selector and group query parameters
+func serverSelectorFromQuery(c *gin.Context) models.ServerSelector {
+	return models.ServerSelector{
+		Selector: c.Query("selector"),
+		Group:    c.Query("group"),
+	}
+}
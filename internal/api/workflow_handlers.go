@@ -0,0 +1,147 @@
+package api
+
+import (
+	"fmt"
+	"job-executor/internal/models"
+	"job-executor/internal/workflow"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func (api *API) CreateWorkflow(c *gin.Context) {
+	var req models.WorkflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := workflow.ValidateSteps(req.Steps); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	wf := &models.Workflow{Name: req.Name, Status: models.WorkflowRunning}
+	checkedServers := make(map[string]bool)
+	for _, step := range req.Steps {
+		if (step.Command == "") == (step.Script == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("step %q: exactly one of command or script is required", step.Name)})
+			return
+		}
+		if err := step.RetryPolicy.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("step %q: %v", step.Name, err)})
+			return
+		}
+
+		// Validate that the server exists and is active
+		if !checkedServers[step.ServerID] {
+			var server models.Server
+			if err := api.db.First(&server, "id = ? AND is_active = ?", step.ServerID, true).Error; err != nil {
+				if err == gorm.ErrRecordNotFound {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("step %q: server not found or inactive", step.Name)})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate server"})
+				return
+			}
+			checkedServers[step.ServerID] = true
+		}
+
+		// Set defaults
+		if step.Timeout <= 0 {
+			step.Timeout = 300
+		}
+		if step.Priority < 1 || step.Priority > 10 {
+			step.Priority = 5
+		}
+		for i := range step.DependsOn {
+			if step.DependsOn[i].Condition == "" {
+				step.DependsOn[i].Condition = models.OnSuccess
+			}
+		}
+
+		wf.Steps = append(wf.Steps, models.WorkflowStep{
+			Name:        step.Name,
+			Command:     step.Command,
+			Args:        step.Args,
+			Script:      step.Script,
+			Shell:       step.Shell,
+			ServerID:    step.ServerID,
+			Timeout:     step.Timeout,
+			Priority:    step.Priority,
+			DependsOn:   step.DependsOn,
+			Status:      models.StepPending,
+			RetryPolicy: step.RetryPolicy,
+		})
+	}
+
+	// Creates the steps along with the workflow
+	if err := api.db.Create(wf).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workflow"})
+		return
+	}
+
+	// Start the steps without dependencies right away rather than on the controller's next pass
+	if err := workflow.New(api.db, api.queue).Advance(wf.ID); err != nil {
+		slog.Error("Failed to start workflow", "workflow_id", wf.ID, "error", err)
+	}
+
+	api.respondWorkflow(c, http.StatusCreated, wf.ID)
+}
+
+func (api *API) GetWorkflow(c *gin.Context) {
+	api.respondWorkflow(c, http.StatusOK, c.Param("id"))
+}
+
+func (api *API) ListWorkflows(c *gin.Context) {
+	query := api.db.Model(&models.Workflow{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	workflows := []models.Workflow{}
+	if err := query.Order("created_at DESC").Find(&workflows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workflows"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"workflows": workflows})
+}
+
+func (api *API) CancelWorkflow(c *gin.Context) {
+	workflowID := c.Param("id")
+
+	if err := workflow.New(api.db, api.queue).Cancel(workflowID); err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+		case workflow.ErrNotRunning:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Workflow is not running"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel workflow"})
+		}
+		return
+	}
+
+	api.respondWorkflow(c, http.StatusOK, workflowID)
+}
+
+// respondWorkflow writes a workflow with its steps and a count of steps by status
+func (api *API) respondWorkflow(c *gin.Context, status int, workflowID string) {
+	var wf models.Workflow
+	if err := api.db.Preload("Steps").First(&wf, "id = ?", workflowID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workflow not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workflow"})
+		return
+	}
+
+	counts := make(map[models.StepStatus]int)
+	for _, step := range wf.Steps {
+		counts[step.Status]++
+	}
+	c.JSON(status, &models.WorkflowResponse{Workflow: wf, StepCounts: counts})
+}
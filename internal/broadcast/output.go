@@ -1,45 +1,96 @@
 package broadcast
 
 import (
+	"context"
+	"log/slog"
 	"sync"
+	"time"
 )
 
+// backlogSize is how many recent events are kept per job for clients that attach late
+// or resume after a disconnect
+const backlogSize = 500
+
+// clientBuffer is how many events a client can fall behind by before events are
+// dropped for it. It has room for a whole replay of the upstream, which arrives in one
+// burst when the first client starts it (netqueue retains 500 events per job).
+const clientBuffer = backlogSize
+
 // OutputBroadcaster manages real-time output broadcasting to SSE clients
 type OutputBroadcaster struct {
-	clients map[string]map[chan OutputEvent]bool // jobID -> clients
-	mutex   sync.RWMutex
+	clients   map[string]map[chan OutputEvent]bool // jobID -> clients
+	backlog   map[string][]OutputEvent             // jobID -> recent events, oldest first
+	upstreams map[string]context.CancelFunc        // jobID -> running source
+	source    Source
+	mutex     sync.RWMutex
 }
 
 // OutputEvent represents a real-time output event
 type OutputEvent struct {
-	JobID    string `json:"job_id"`
-	Content  string `json:"content"`
-	IsStderr bool   `json:"is_stderr"`
-	LineNum  int    `json:"line_num"`
+	JobID     string `json:"job_id"`
+	Content   string `json:"content"`
+	IsStderr  bool   `json:"is_stderr"`
+	LineNum   int    `json:"line_num"`
+	Timestamp string `json:"timestamp"`
 }
 
+// Source feeds a job's output into the broadcaster through publish until ctx is done.
+// It is started when the first client subscribes to a job and stopped after the last
+// one leaves, so any number of clients share a single upstream consumer.
+type Source func(ctx context.Context, jobID string, publish func(OutputEvent)) error
+
 // Global broadcaster instance
 var GlobalBroadcaster = NewOutputBroadcaster()
 
 func NewOutputBroadcaster() *OutputBroadcaster {
 	return &OutputBroadcaster{
-		clients: make(map[string]map[chan OutputEvent]bool),
+		clients:   make(map[string]map[chan OutputEvent]bool),
+		backlog:   make(map[string][]OutputEvent),
+		upstreams: make(map[string]context.CancelFunc),
 	}
 }
 
-// Subscribe creates a new SSE client for a job
-func (b *OutputBroadcaster) Subscribe(jobID string) chan OutputEvent {
+// SetSource sets where job output comes from. Without one, only Broadcast feeds clients.
+func (b *OutputBroadcaster) SetSource(source Source) {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
+	b.source = source
+}
+
+// Subscribe creates a new SSE client for a job. It also returns the recent events the
+// broadcaster already holds for the job, which the client hasn't been sent.
+func (b *OutputBroadcaster) Subscribe(jobID string) (chan OutputEvent, []OutputEvent) {
+	b.mutex.Lock()
 
-	client := make(chan OutputEvent, 100) // Buffer for 100 events
+	client := make(chan OutputEvent, clientBuffer)
 
 	if b.clients[jobID] == nil {
 		b.clients[jobID] = make(map[chan OutputEvent]bool)
 	}
 	b.clients[jobID][client] = true
+	backlog := append([]OutputEvent(nil), b.backlog[jobID]...)
+
+	// The first client for a job starts the upstream consumer
+	var ctx context.Context
+	source := b.source
+	if _, running := b.upstreams[jobID]; !running && source != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(context.Background())
+		b.upstreams[jobID] = cancel
+	}
+	b.mutex.Unlock()
+
+	if ctx != nil {
+		// Start outside the lock, the source may have to dial the queue
+		if err := source(ctx, jobID, b.publish); err != nil {
+			slog.Error("Failed to start output source", "job_id", jobID, "error", err)
+			b.mutex.Lock()
+			b.stopUpstreamLocked(jobID)
+			b.mutex.Unlock()
+		}
+	}
 
-	return client
+	return client, backlog
 }
 
 // Unsubscribe removes an SSE client
@@ -54,34 +105,62 @@ func (b *OutputBroadcaster) Unsubscribe(jobID string, client chan OutputEvent) {
 		// Clean up empty job entries
 		if len(b.clients[jobID]) == 0 {
 			delete(b.clients, jobID)
+			delete(b.backlog, jobID)
+			b.stopUpstreamLocked(jobID)
 		}
 	}
 }
 
 // Broadcast sends output to all subscribers of a job
 func (b *OutputBroadcaster) Broadcast(jobID string, content string, isStderr bool, lineNum int) {
-	b.mutex.RLock()
-	defer b.mutex.RUnlock()
+	b.publish(OutputEvent{
+		JobID:     jobID,
+		Content:   content,
+		IsStderr:  isStderr,
+		LineNum:   lineNum,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+	})
+}
 
-	if clients, exists := b.clients[jobID]; exists {
-		event := OutputEvent{
-			JobID:    jobID,
-			Content:  content,
-			IsStderr: isStderr,
-			LineNum:  lineNum,
-		}
+// publish records an event in the job's backlog and fans it out to its clients.
+// Events at or before the last line seen are repeats from an upstream replay and dropped.
+func (b *OutputBroadcaster) publish(event OutputEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	clients, exists := b.clients[event.JobID]
+	if !exists {
+		return
+	}
 
-		for client := range clients {
-			select {
-			case client <- event:
-				// Event sent successfully
-			default:
-				// Client buffer is full, skip this event
-			}
+	backlog := b.backlog[event.JobID]
+	if n := len(backlog); n > 0 && event.LineNum <= backlog[n-1].LineNum {
+		return
+	}
+	backlog = append(backlog, event)
+	if len(backlog) > backlogSize {
+		backlog = append([]OutputEvent(nil), backlog[len(backlog)-backlogSize:]...)
+	}
+	b.backlog[event.JobID] = backlog
+
+	for client := range clients {
+		select {
+		case client <- event:
+			// Event sent successfully
+		default:
+			// Client buffer is full, skip this event
 		}
 	}
 }
 
+// stopUpstreamLocked cancels the job's source if one is running. The caller must hold the mutex.
+func (b *OutputBroadcaster) stopUpstreamLocked(jobID string) {
+	if cancel, ok := b.upstreams[jobID]; ok {
+		cancel()
+		delete(b.upstreams, jobID)
+	}
+}
+
 // HasSubscribers checks if there are active subscribers for a job
 func (b *OutputBroadcaster) HasSubscribers(jobID string) bool {
 	b.mutex.RLock()
@@ -0,0 +1,76 @@
+package broadcast
+
+import (
+	"context"
+	"testing"
+)
+
+func TestOutputBroadcaster_SharedSource(t *testing.T) {
+	b := NewOutputBroadcaster()
+
+	starts := 0
+	var upstream context.Context
+	var emit func(OutputEvent)
+	b.SetSource(func(ctx context.Context, jobID string, publish func(OutputEvent)) error {
+		starts++
+		upstream, emit = ctx, publish
+		return nil
+	})
+
+	first, _ := b.Subscribe("job-1")
+	emit(OutputEvent{JobID: "job-1", Content: "one\n", LineNum: 1})
+	emit(OutputEvent{JobID: "job-1", Content: "two\n", LineNum: 2})
+
+	// A second tab shares the upstream and catches up from the backlog
+	second, backlog := b.Subscribe("job-1")
+	if starts != 1 {
+		t.Fatalf("Expected the source to start once, started %d times", starts)
+	}
+	if len(backlog) != 2 || backlog[1].LineNum != 2 {
+		t.Fatalf("Expected a backlog of lines 1-2, got %+v", backlog)
+	}
+
+	// Replayed lines from an upstream reconnect are dropped
+	emit(OutputEvent{JobID: "job-1", Content: "two\n", LineNum: 2})
+	emit(OutputEvent{JobID: "job-1", Content: "three\n", LineNum: 3})
+	if got := (<-second).LineNum; got != 3 {
+		t.Errorf("Expected the second tab to receive line 3, got %d", got)
+	}
+	if got := len(first); got != 3 {
+		t.Errorf("Expected the first tab to hold 3 events, got %d", got)
+	}
+
+	b.Unsubscribe("job-1", first)
+	if upstream.Err() != nil {
+		t.Fatal("Source stopped while a client was still attached")
+	}
+	b.Unsubscribe("job-1", second)
+	if upstream.Err() == nil {
+		t.Error("Expected the source to stop after the last client left")
+	}
+}
+
+func TestOutputBroadcaster_ReplayToFirstClient(t *testing.T) {
+	b := NewOutputBroadcaster()
+
+	// The upstream replays its retained events in one burst as soon as it starts
+	const replayed = 300
+	b.SetSource(func(ctx context.Context, jobID string, publish func(OutputEvent)) error {
+		for i := 1; i <= replayed; i++ {
+			publish(OutputEvent{JobID: jobID, Content: "line\n", LineNum: i})
+		}
+		return nil
+	})
+
+	client, backlog := b.Subscribe("job-1")
+	defer b.Unsubscribe("job-1", client)
+	if len(backlog)+len(client) != replayed {
+		t.Fatalf("Expected all %d replayed events, got %d in the backlog and %d buffered",
+			replayed, len(backlog), len(client))
+	}
+	for i := 1; i <= replayed-len(backlog); i++ {
+		if got := (<-client).LineNum; got != len(backlog)+i {
+			t.Fatalf("Expected line %d, got %d", len(backlog)+i, got)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"runtime"
 	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -11,7 +12,41 @@ type Config struct {
 	DatabaseURL    string
 	NetQueueAddr   string
 	WorkerPoolSize int
+	WorkerPrefetch int // jobs reserved ahead of processing, 0 picks a default from the pool size
 	SSH            SSHConfig
+	SSHPool        SSHPoolConfig
+	// SSHStrictHostKeys makes jobs fail on servers without a pinned host key instead
+	// of pinning the first key they see
+	SSHStrictHostKeys bool
+	// SSHCAKey is the private key, or the path of a file holding it, of a certificate
+	// authority that issues per-job certificates. Empty disables issuing them.
+	SSHCAKey string
+	// CredentialsMasterKey holds the master keys server credentials are encrypted with,
+	// as "version:base64 key" entries. CredentialsMasterKeyFile is read when it's empty.
+	CredentialsMasterKey     string
+	CredentialsMasterKeyFile string
+	Secrets                  SecretsConfig
+	// JobOutputInlineLimit is how many bytes of a job's stdout or stderr the database
+	// keeps whole, more is moved to storage in chunks of JobOutputChunkSize bytes.
+	// -1 means not set, 0 keeps all output in the database.
+	JobOutputInlineLimit int
+	JobOutputChunkSize   int
+}
+
+// SecretsConfig picks the backend secret:// credential references are resolved with
+type SecretsConfig struct {
+	Provider   string // "file", "encrypted-file", "vault" or empty for none
+	Dir        string // directory of the file providers
+	VaultAddr  string
+	VaultToken string
+	VaultMount string // mount path of the KV version 2 engine
+}
+
+// SSHPoolConfig tunes the worker's pool of SSH connections, zero values use the defaults
+type SSHPoolConfig struct {
+	MaxSessions       int           // sessions sharing one connection
+	IdleTimeout       time.Duration // how long an unused connection stays open
+	KeepaliveInterval time.Duration // how often idle connections are health-checked
 }
 
 type SSHConfig struct {
@@ -21,6 +56,17 @@ type SSHConfig struct {
 	Password   string
 	PrivateKey string
 	PemFileURL string
+
+	HostKeys        []string // SHA256 fingerprints the server's host key must match
+	TrustOnFirstUse bool     // accept any host key while none is pinned
+
+	UseAgent     bool // authenticate with the keys of the ssh-agent at SSH_AUTH_SOCK
+	ForwardAgent bool // forward that ssh-agent to the sessions
+
+	Certificate     string // OpenSSH user certificate for the private key
+	MintCertificate bool   // without a certificate, have the client's CA issue one
+
+	JumpHosts []SSHConfig // hosts to connect through, the first is dialed directly
 }
 
 func Load() *Config {
@@ -44,11 +90,42 @@ func Load() *Config {
 		}
 	}
 
+	workerPrefetch := 0
+	if prefetchStr := os.Getenv("WORKER_PREFETCH"); prefetchStr != "" {
+		if parsed, err := strconv.Atoi(prefetchStr); err == nil && parsed > 0 {
+			workerPrefetch = parsed
+		}
+	}
+
+	sshPool := SSHPoolConfig{
+		IdleTimeout:       getDuration("SSH_POOL_IDLE_TIMEOUT"),
+		KeepaliveInterval: getDuration("SSH_KEEPALIVE_INTERVAL"),
+	}
+	if maxSessionsStr := os.Getenv("SSH_POOL_MAX_SESSIONS"); maxSessionsStr != "" {
+		if parsed, err := strconv.Atoi(maxSessionsStr); err == nil && parsed > 0 {
+			sshPool.MaxSessions = parsed
+		}
+	}
+
+	jobOutputInlineLimit := -1
+	if limitStr := os.Getenv("JOB_OUTPUT_INLINE_LIMIT"); limitStr != "" {
+		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed >= 0 {
+			jobOutputInlineLimit = parsed
+		}
+	}
+	jobOutputChunkSize := 0
+	if chunkStr := os.Getenv("JOB_OUTPUT_CHUNK_SIZE"); chunkStr != "" {
+		if parsed, err := strconv.Atoi(chunkStr); err == nil && parsed > 0 {
+			jobOutputChunkSize = parsed
+		}
+	}
+
 	return &Config{
 		ServerAddr:     getEnv("SERVER_ADDR", ":8080"),
 		DatabaseURL:    getEnv("DATABASE_URL", "./jobs.db"),
 		NetQueueAddr:   getEnv("NETQUEUE_ADDR", "localhost:9000"),
 		WorkerPoolSize: workerPoolSize,
+		WorkerPrefetch: workerPrefetch,
 		SSH: SSHConfig{
 			Host:       getEnv("SSH_HOST", "localhost"),
 			Port:       getEnv("SSH_PORT", "22"),
@@ -56,6 +133,21 @@ func Load() *Config {
 			Password:   getEnv("SSH_PASSWORD", ""),
 			PrivateKey: getEnv("SSH_PRIVATE_KEY", ""),
 		},
+		SSHPool:           sshPool,
+		SSHStrictHostKeys: os.Getenv("SSH_STRICT_HOST_KEYS") == "true",
+		SSHCAKey:          os.Getenv("SSH_CA_KEY"),
+
+		CredentialsMasterKey:     os.Getenv("CREDENTIALS_MASTER_KEY"),
+		CredentialsMasterKeyFile: os.Getenv("CREDENTIALS_MASTER_KEY_FILE"),
+		Secrets: SecretsConfig{
+			Provider:   os.Getenv("SECRETS_PROVIDER"),
+			Dir:        getEnv("SECRETS_DIR", "/run/secrets"),
+			VaultAddr:  os.Getenv("VAULT_ADDR"),
+			VaultToken: os.Getenv("VAULT_TOKEN"),
+			VaultMount: getEnv("VAULT_MOUNT", "secret"),
+		},
+		JobOutputInlineLimit: jobOutputInlineLimit,
+		JobOutputChunkSize:   jobOutputChunkSize,
 	}
 }
 
@@ -65,3 +157,11 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getDuration parses a duration such as "30s" from the environment, 0 if unset or invalid
+func getDuration(key string) time.Duration {
+	if parsed, err := time.ParseDuration(os.Getenv(key)); err == nil && parsed > 0 {
+		return parsed
+	}
+	return 0
+}
@@ -0,0 +1,227 @@
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// prefix marks encrypted values, anything else is stored as plaintext
+const prefix = "enc:v"
+
+// ErrUnknownKeyVersion is returned for values encrypted with a master key the keyring
+// doesn't hold, e.g. one retired before every row was re-encrypted
+var ErrUnknownKeyVersion = errors.New("credentials encrypted with an unknown master key version")
+
+// Keyring holds the master keys by version. The newest version encrypts, the older
+// ones are kept to decrypt values written before a rotation.
+//
+// Values are encrypted with envelope encryption: each gets its own random data key,
+// and only that data key is encrypted with the master key. A nil keyring leaves
+// values as plaintext.
+type Keyring struct {
+	keys    map[int][]byte
+	current int
+}
+
+// ParseKeys parses master keys given as "version:key" entries separated by commas or
+// newlines, each key being 32 base64 encoded bytes. A key without a version is version 1.
+func ParseKeys(spec string) (*Keyring, error) {
+	keyring := &Keyring{keys: make(map[int][]byte)}
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		version := 1
+		encoded := entry
+		if i := strings.Index(entry, ":"); i >= 0 {
+			v, err := strconv.Atoi(entry[:i])
+			if err != nil || v < 1 {
+				return nil, fmt.Errorf("invalid master key version %q", entry[:i])
+			}
+			version, encoded = v, entry[i+1:]
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("master key version %d is not base64: %w", version, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("master key version %d must be 32 bytes, got %d", version, len(key))
+		}
+		if _, ok := keyring.keys[version]; ok {
+			return nil, fmt.Errorf("master key version %d is given twice", version)
+		}
+
+		keyring.keys[version] = key
+		if version > keyring.current {
+			keyring.current = version
+		}
+	}
+	if len(keyring.keys) == 0 {
+		return nil, errors.New("no master key given")
+	}
+	return keyring, nil
+}
+
+// LoadKeyring loads the master keys from spec, or from the file at path when spec is
+// empty. Both empty returns a nil keyring, which leaves credentials unencrypted.
+func LoadKeyring(spec, path string) (*Keyring, error) {
+	if spec == "" && path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key file: %w", err)
+		}
+		spec = string(content)
+	}
+	if spec == "" {
+		return nil, nil
+	}
+	return ParseKeys(spec)
+}
+
+// Current returns the version of the master key new values are encrypted with, 0 for
+// a nil keyring
+func (k *Keyring) Current() int {
+	if k == nil {
+		return 0
+	}
+	return k.current
+}
+
+// IsEncrypted reports whether value was encrypted by a keyring
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Version returns the master key version value is encrypted with, 0 for plaintext
+func Version(value string) int {
+	if !IsEncrypted(value) {
+		return 0
+	}
+	end := strings.Index(value[len(prefix):], ":")
+	if end < 0 {
+		return 0
+	}
+	version, _ := strconv.Atoi(value[len(prefix) : len(prefix)+end])
+	return version
+}
+
+// Encrypt encrypts value with a new data key under the current master key. Empty and
+// already encrypted values are returned as they are, as is everything with a nil keyring.
+func (k *Keyring) Encrypt(value string) (string, error) {
+	if k == nil || value == "" || IsEncrypted(value) {
+		return value, nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	sealedKey, err := seal(k.keys[k.current], dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealedValue, err := seal(dataKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%d:%s:%s", prefix, k.current,
+		base64.StdEncoding.EncodeToString(sealedKey),
+		base64.StdEncoding.EncodeToString(sealedValue)), nil
+}
+
+// Decrypt returns the plaintext of an encrypted value. Plaintext values, written
+// before encryption was enabled, are returned as they are.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if k == nil {
+		return "", errors.New("credentials are encrypted but no master key is configured")
+	}
+
+	parts := strings.Split(value[len(prefix):], ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted credentials")
+	}
+	masterKey, ok := k.keys[Version(value)]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKeyVersion, parts[0])
+	}
+	sealedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.New("malformed encrypted credentials")
+	}
+	sealedValue, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed encrypted credentials")
+	}
+
+	dataKey, err := open(masterKey, sealedKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt data key: %w", err)
+	}
+	plaintext, err := open(dataKey, sealedValue)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt credentials: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// Reencrypt encrypts value under the current master key unless it already is. It
+// reports whether the value changed.
+func (k *Keyring) Reencrypt(value string) (string, bool, error) {
+	if k == nil || value == "" || Version(value) == k.current {
+		return value, false, nil
+	}
+	plaintext, err := k.Decrypt(value)
+	if err != nil {
+		return "", false, err
+	}
+	encrypted, err := k.Encrypt(plaintext)
+	if err != nil {
+		return "", false, err
+	}
+	return encrypted, true, nil
+}
+
+// seal encrypts plaintext with AES-256-GCM, prepending the nonce
+func seal(key, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts what seal produced
+func open(key, sealed []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
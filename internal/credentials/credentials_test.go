@@ -0,0 +1,96 @@
+package credentials
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey, newKeyValue := newKey(t), newKey(t)
+	v1, err := ParseKeys(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := v1.Encrypt("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encrypted) || Version(encrypted) != 1 || strings.Contains(encrypted, "s3cret") {
+		t.Fatalf("unexpected encrypted value %q", encrypted)
+	}
+	if again, _ := v1.Encrypt("s3cret"); again == encrypted {
+		t.Error("each value should get its own data key and nonce")
+	}
+	if plaintext, err := v1.Decrypt(encrypted); err != nil || plaintext != "s3cret" {
+		t.Fatalf("Decrypt = %q, %v", plaintext, err)
+	}
+
+	// Plaintext from before encryption was enabled is read as it is
+	if plaintext, err := v1.Decrypt("legacy"); err != nil || plaintext != "legacy" {
+		t.Errorf("Decrypt of plaintext = %q, %v", plaintext, err)
+	}
+
+	// After rotating, version 2 encrypts and version 1 values still decrypt
+	v2, err := ParseKeys("1:" + oldKey + "\n2:" + newKeyValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v2.Current() != 2 {
+		t.Fatalf("expected version 2 to be current, got %d", v2.Current())
+	}
+	rotated, changed, err := v2.Reencrypt(encrypted)
+	if err != nil || !changed || Version(rotated) != 2 {
+		t.Fatalf("Reencrypt = %q, %v, %v", rotated, changed, err)
+	}
+	if _, changed, _ := v2.Reencrypt(rotated); changed {
+		t.Error("values under the current key should be left alone")
+	}
+	if plaintext, err := v2.Decrypt(rotated); err != nil || plaintext != "s3cret" {
+		t.Fatalf("Decrypt after rotation = %q, %v", plaintext, err)
+	}
+
+	// Once version 1 is retired, values nobody re-encrypted can't be read
+	retired, err := ParseKeys("2:" + newKeyValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := retired.Decrypt(encrypted); !errors.Is(err, ErrUnknownKeyVersion) {
+		t.Errorf("expected ErrUnknownKeyVersion, got %v", err)
+	}
+
+	// The wrong key with the right version fails authentication
+	wrong, _ := ParseKeys("2:" + oldKey)
+	if _, err := wrong.Decrypt(rotated); err == nil {
+		t.Error("expected decrypting with the wrong key to fail")
+	}
+
+	// Without a keyring values stay plaintext, and encrypted ones can't be read
+	var none *Keyring
+	if value, _ := none.Encrypt("s3cret"); value != "s3cret" {
+		t.Errorf("nil keyring should not encrypt, got %q", value)
+	}
+	if _, err := none.Decrypt(encrypted); err == nil {
+		t.Error("expected decrypting without a keyring to fail")
+	}
+}
+
+func TestParseKeysRejectsBadKeys(t *testing.T) {
+	for _, spec := range []string{"", "1:not-base64!", "1:" + base64.StdEncoding.EncodeToString([]byte("short")), "x:" + newKey(t), "1:" + newKey(t) + ",1:" + newKey(t)} {
+		if _, err := ParseKeys(spec); err == nil {
+			t.Errorf("ParseKeys(%q) should fail", spec)
+		}
+	}
+}
@@ -35,8 +35,24 @@ func Initialize(databaseURL string) (*gorm.DB, error) {
 	if err := db.AutoMigrate(&models.Server{}); err != nil {
 		return nil, err
 	}
+
+	if err := db.AutoMigrate(&models.ServerLabel{}, &models.ServerGroup{}); err != nil {
+		return nil, err
+	}
 	
-	if err := db.AutoMigrate(&models.Job{}); err != nil {
+	if err := db.AutoMigrate(&models.Job{}, &models.JobArtifact{}); err != nil {
+		return nil, err
+	}
+
+	if err := db.AutoMigrate(&models.Schedule{}, &models.SchedulerLease{}); err != nil {
+		return nil, err
+	}
+
+	if err := db.AutoMigrate(&models.Workflow{}, &models.WorkflowStep{}); err != nil {
+		return nil, err
+	}
+
+	if err := db.AutoMigrate(&models.Run{}); err != nil {
 		return nil, err
 	}
 
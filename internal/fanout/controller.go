@@ -0,0 +1,365 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"job-executor/internal/models"
+	"job-executor/internal/queue"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// defaultPollInterval is how often running runs are checked for finished jobs
+const defaultPollInterval = 2 * time.Second
+
+// ErrNotRunning is returned when changing a run that has already finished
+var ErrNotRunning = errors.New("run is not running")
+
+// ErrNotPaused is returned when resuming a run that isn't paused
+var ErrNotPaused = errors.New("run is not paused")
+
+// Controller releases the pending jobs of multi-target runs to the queue, keeping at
+// most MaxInFlight of them active or, for rolling runs, one batch at a time. It stops a
+// run once MaxFailures of its jobs failed, and aborts a rollout whose failure rate is too
+// high. Jobs are released with conditional updates, so several controllers can work side
+// by side.
+type Controller struct {
+	db       *gorm.DB
+	queue    jobQueue
+	interval time.Duration
+}
+
+// jobQueue is what the controller needs of the queue
+type jobQueue interface {
+	Push(job *models.Job) error
+	PublishCancelMessage(jobID string) error
+}
+
+func New(db *gorm.DB, queue queue.NetQueue) *Controller {
+	return &Controller{
+		db:       db,
+		queue:    &queue,
+		interval: defaultPollInterval,
+	}
+}
+
+// SetInterval configures how often running runs are checked
+func (c *Controller) SetInterval(interval time.Duration) {
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	c.interval = interval
+}
+
+// Start runs the controller until ctx is done
+func (c *Controller) Start(ctx context.Context) {
+	slog.Info("Starting multi-target run controller", "interval", c.interval)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Multi-target run controller stopped")
+			return
+		case <-ticker.C:
+		}
+
+		var ids []string
+		if err := c.db.Model(&models.Run{}).Where("status = ?", models.RunRunning).Pluck("id", &ids).Error; err != nil {
+			slog.Error("Failed to fetch running runs", "error", err)
+			continue
+		}
+		for _, id := range ids {
+			if err := c.Advance(id); err != nil {
+				slog.Error("Failed to advance run", "run_id", id, "error", err)
+			}
+		}
+	}
+}
+
+// Advance releases as many pending jobs as the run allows and finishes the run once
+// all of its jobs have
+func (c *Controller) Advance(runID string) error {
+	var run models.Run
+	if err := c.db.First(&run, "id = ?", runID).Error; err != nil {
+		return err
+	}
+	if run.Status != models.RunRunning {
+		return nil
+	}
+
+	var jobs []models.Job
+	if err := c.db.Where("run_id = ?", runID).Order("created_at").Find(&jobs).Error; err != nil {
+		return err
+	}
+
+	var pending []models.Job
+	inFlight, failed, finished := 0, 0, 0
+	var lastFinished time.Time
+	for _, job := range jobs {
+		switch job.Status {
+		case models.StatusPending:
+			pending = append(pending, job)
+		case models.StatusQueued, models.StatusScheduled, models.StatusRunning:
+			inFlight++
+		case models.StatusFailed:
+			failed++
+		}
+		if job.FinishedAt != nil {
+			finished++
+			if job.FinishedAt.After(lastFinished) {
+				lastFinished = *job.FinishedAt
+			}
+		}
+	}
+
+	// Fail fast: once too many jobs failed, the ones not started yet never will
+	if run.MaxFailures > 0 && failed >= run.MaxFailures && len(pending) > 0 {
+		now := time.Now().UTC()
+		err := c.db.Model(&models.Job{}).
+			Where("run_id = ? AND status = ?", runID, models.StatusPending).
+			Updates(map[string]interface{}{
+				"status":      models.StatusCanceled,
+				"error":       "Run stopped after too many failures",
+				"finished_at": now,
+			}).Error
+		if err != nil {
+			return err
+		}
+		c.db.Model(&run).Update("stopped", true)
+		slog.Info("Run stopped after too many failures", "run_id", runID, "failed", failed, "skipped", len(pending))
+		pending = nil
+	}
+
+	if run.Strategy.Type == models.StrategyRolling {
+		if len(pending) > 0 && inFlight == 0 {
+			return c.nextBatch(&run, pending, len(jobs), failed, finished, lastFinished)
+		}
+	} else {
+		for _, job := range pending {
+			if run.MaxInFlight > 0 && inFlight >= run.MaxInFlight {
+				break
+			}
+			released, err := c.release(&job, 0)
+			if err != nil {
+				return err
+			}
+			if released {
+				inFlight++
+			}
+		}
+	}
+
+	if len(pending) == 0 && inFlight == 0 {
+		return c.finish(&run)
+	}
+	return nil
+}
+
+// nextBatch starts the next batch of a rolling run once the previous one has finished,
+// unless too many jobs failed
+func (c *Controller) nextBatch(run *models.Run, pending []models.Job, total, failed, finished int, lastFinished time.Time) error {
+	if finished > 0 && failed*100 > run.Strategy.MaxFailureRate*finished {
+		slog.Info("Aborting rollout, failure rate too high",
+			"run_id", run.ID,
+			"failed", failed,
+			"finished", finished,
+			"max_failure_rate", run.Strategy.MaxFailureRate)
+		return c.abort(run.ID, models.RunRunning, "Rollout aborted, failure rate too high")
+	}
+
+	if run.Batch > 0 && run.Strategy.BatchPause > 0 {
+		if time.Since(lastFinished) < time.Duration(run.Strategy.BatchPause)*time.Second {
+			return nil
+		}
+	}
+
+	// Claim the batch number so no other controller releases the same batch
+	batch := run.Batch + 1
+	result := c.db.Model(&models.Run{}).
+		Where("id = ? AND batch = ? AND status = ?", run.ID, run.Batch, models.RunRunning).
+		Update("batch", batch)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	run.Batch = batch
+
+	size := run.Strategy.BatchSizeFor(total)
+	if size > len(pending) {
+		size = len(pending)
+	}
+	for i := range pending[:size] {
+		if _, err := c.release(&pending[i], batch); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Rollout batch started", "run_id", run.ID, "batch", batch, "hosts", size, "remaining", len(pending)-size)
+	return nil
+}
+
+// release moves a pending job to the queue as part of batch, 0 outside rolling runs.
+// It reports false if the job was no longer pending, e.g. because it was canceled or
+// another controller released it.
+func (c *Controller) release(job *models.Job, batch int) (bool, error) {
+	result := c.db.Model(&models.Job{}).
+		Where("id = ? AND status = ?", job.ID, models.StatusPending).
+		Updates(map[string]interface{}{"status": models.StatusQueued, "batch": batch})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	job.Status = models.StatusQueued
+	job.Batch = batch
+	if err := c.queue.Push(job); err != nil {
+		slog.Error("Failed to push run job to queue", "job_id", job.ID, "error", err)
+		c.db.Model(&models.Job{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+			"status": models.StatusFailed,
+			"error":  "Failed to queue job: " + err.Error(),
+		})
+	}
+	return true, nil
+}
+
+// finish sets the run's final status from its jobs
+func (c *Controller) finish(run *models.Run) error {
+	var unsuccessful int64
+	if err := c.db.Model(&models.Job{}).Where("run_id = ? AND status <> ?", run.ID, models.StatusCompleted).Count(&unsuccessful).Error; err != nil {
+		return err
+	}
+	status := models.RunCompleted
+	if unsuccessful > 0 {
+		status = models.RunFailed
+	}
+
+	now := time.Now().UTC()
+	err := c.db.Model(&models.Run{}).
+		Where("id = ? AND status = ?", run.ID, models.RunRunning).
+		Updates(map[string]interface{}{"status": status, "finished_at": now}).Error
+	if err != nil {
+		return err
+	}
+	run.Status = status
+	run.FinishedAt = &now
+
+	slog.Info("Run finished", "run_id", run.ID, "status", status)
+	return nil
+}
+
+// Cancel stops a running or paused run: jobs that haven't started won't, and active
+// ones are canceled
+func (c *Controller) Cancel(runID string) error {
+	var run models.Run
+	if err := c.db.First(&run, "id = ?", runID).Error; err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	result := c.db.Model(&models.Run{}).
+		Where("id = ? AND status IN ?", runID, []models.RunStatus{models.RunRunning, models.RunPaused}).
+		Updates(map[string]interface{}{"status": models.RunCanceled, "finished_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotRunning
+	}
+
+	var active []string
+	if err := c.db.Model(&models.Job{}).Where("run_id = ? AND status IN ?", runID, models.ActiveStatuses).Pluck("id", &active).Error; err != nil {
+		return err
+	}
+	err := c.db.Model(&models.Job{}).
+		Where("run_id = ? AND status IN ?", runID, models.ActiveStatuses).
+		Updates(map[string]interface{}{"status": models.StatusCanceled, "finished_at": now}).Error
+	if err != nil {
+		return err
+	}
+	for _, jobID := range active {
+		if err := c.queue.PublishCancelMessage(jobID); err != nil {
+			slog.Error("Failed to publish cancel message", "job_id", jobID, "error", err)
+		}
+	}
+
+	slog.Info("Run canceled", "run_id", runID, "canceled_jobs", len(active))
+	return nil
+}
+
+// Pause stops a run from releasing more jobs. Jobs already released carry on.
+func (c *Controller) Pause(runID string) error {
+	return c.setStatus(runID, models.RunRunning, models.RunPaused, ErrNotRunning)
+}
+
+// Resume lets a paused run release jobs again
+func (c *Controller) Resume(runID string) error {
+	if err := c.setStatus(runID, models.RunPaused, models.RunRunning, ErrNotPaused); err != nil {
+		return err
+	}
+	return c.Advance(runID)
+}
+
+// Abort stops a running or paused run: jobs not yet released are canceled, while jobs
+// already released carry on
+func (c *Controller) Abort(runID string) error {
+	var run models.Run
+	if err := c.db.First(&run, "id = ?", runID).Error; err != nil {
+		return err
+	}
+	if run.Status != models.RunRunning && run.Status != models.RunPaused {
+		return ErrNotRunning
+	}
+	return c.abort(runID, run.Status, "Rollout aborted")
+}
+
+// abort marks the run aborted if it still has status from, then cancels its pending jobs
+func (c *Controller) abort(runID string, from models.RunStatus, reason string) error {
+	now := time.Now().UTC()
+	result := c.db.Model(&models.Run{}).
+		Where("id = ? AND status = ?", runID, from).
+		Updates(map[string]interface{}{"status": models.RunAborted, "finished_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotRunning
+	}
+
+	result = c.db.Model(&models.Job{}).
+		Where("run_id = ? AND status = ?", runID, models.StatusPending).
+		Updates(map[string]interface{}{"status": models.StatusCanceled, "error": reason, "finished_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	slog.Info("Run aborted", "run_id", runID, "reason", reason, "canceled_jobs", result.RowsAffected)
+	return nil
+}
+
+// setStatus moves a run from one status to another, returning errWrong if it wasn't in from
+func (c *Controller) setStatus(runID string, from, to models.RunStatus, errWrong error) error {
+	var run models.Run
+	if err := c.db.First(&run, "id = ?", runID).Error; err != nil {
+		return err
+	}
+	result := c.db.Model(&models.Run{}).
+		Where("id = ? AND status = ?", runID, from).
+		Update("status", to)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errWrong
+	}
+
+	slog.Info("Run status changed", "run_id", runID, "status", to)
+	return nil
+}
@@ -0,0 +1,244 @@
+package fanout
+
+import (
+	"job-executor/internal/models"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// fakeQueue records the jobs the controller pushes and cancels
+type fakeQueue struct {
+	pushed   []string
+	canceled []string
+}
+
+func (q *fakeQueue) Push(job *models.Job) error {
+	q.pushed = append(q.pushed, job.ID)
+	return nil
+}
+
+func (q *fakeQueue) PublishCancelMessage(jobID string) error {
+	q.canceled = append(q.canceled, jobID)
+	return nil
+}
+
+func newTestController(t *testing.T) (*Controller, *fakeQueue) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, model := range []interface{}{&models.Job{}, &models.Run{}} {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+		dropUUIDDefaults(stmt.Schema, map[*schema.Schema]bool{})
+	}
+	if err := db.AutoMigrate(&models.Job{}, &models.Run{}); err != nil {
+		t.Fatal(err)
+	}
+	q := &fakeQueue{}
+	return &Controller{db: db, queue: q, interval: defaultPollInterval}, q
+}
+
+// dropUUIDDefaults removes the uuid_generate_v4() defaults SQLite can't take from a
+// model and the ones it relates to. BeforeCreate sets the IDs anyway.
+func dropUUIDDefaults(s *schema.Schema, seen map[*schema.Schema]bool) {
+	if s == nil || seen[s] {
+		return
+	}
+	seen[s] = true
+	for _, field := range s.Fields {
+		if field.DefaultValue == "uuid_generate_v4()" {
+			field.HasDefaultValue, field.DefaultValue, field.DefaultValueInterface = false, "", nil
+		}
+	}
+	for _, rel := range s.Relationships.Relations {
+		dropUUIDDefaults(rel.FieldSchema, seen)
+		if rel.JoinTable != nil {
+			dropUUIDDefaults(rel.JoinTable, seen)
+		}
+	}
+}
+
+// createRun stores run with n pending jobs, oldest first
+func createRun(t *testing.T, c *Controller, run *models.Run, n int) []string {
+	t.Helper()
+	if err := c.db.Create(run).Error; err != nil {
+		t.Fatal(err)
+	}
+	created := time.Now().UTC()
+	ids := make([]string, n)
+	for i := range ids {
+		job := models.Job{Command: "true", Status: models.StatusPending, RunID: &run.ID, CreatedAt: created.Add(time.Duration(i) * time.Millisecond)}
+		if err := c.db.Create(&job).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = job.ID
+	}
+	return ids
+}
+
+// finishJobs sets the final status a worker would
+func finishJobs(t *testing.T, c *Controller, status models.JobStatus, ids ...string) {
+	t.Helper()
+	err := c.db.Model(&models.Job{}).Where("id IN ?", ids).
+		Updates(map[string]interface{}{"status": status, "finished_at": time.Now().UTC()}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func advance(t *testing.T, c *Controller, runID string) models.Run {
+	t.Helper()
+	if err := c.Advance(runID); err != nil {
+		t.Fatalf("Advance: %v", err)
+	}
+	var run models.Run
+	if err := c.db.First(&run, "id = ?", runID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return run
+}
+
+func jobStatuses(t *testing.T, c *Controller, ids []string) []models.JobStatus {
+	t.Helper()
+	statuses := make([]models.JobStatus, len(ids))
+	for i, id := range ids {
+		var job models.Job
+		if err := c.db.First(&job, "id = ?", id).Error; err != nil {
+			t.Fatal(err)
+		}
+		statuses[i] = job.Status
+	}
+	return statuses
+}
+
+func TestAdvance_MaxInFlight(t *testing.T) {
+	c, q := newTestController(t)
+	run := &models.Run{Command: "true", Status: models.RunRunning, MaxInFlight: 2}
+	ids := createRun(t, c, run, 5)
+
+	advance(t, c, run.ID)
+	advance(t, c, run.ID)
+	if len(q.pushed) != 2 || q.pushed[0] != ids[0] || q.pushed[1] != ids[1] {
+		t.Fatalf("Expected the two oldest jobs released, got %v", q.pushed)
+	}
+
+	// A finished job frees a slot for the next one
+	finishJobs(t, c, models.StatusCompleted, ids[0])
+	advance(t, c, run.ID)
+	if len(q.pushed) != 3 || q.pushed[2] != ids[2] {
+		t.Fatalf("Expected the third job released, got %v", q.pushed)
+	}
+
+	finishJobs(t, c, models.StatusCompleted, ids[1], ids[2])
+	advance(t, c, run.ID)
+	if len(q.pushed) != 5 {
+		t.Fatalf("Expected all jobs released, got %v", q.pushed)
+	}
+	if got := advance(t, c, run.ID); got.Status != models.RunRunning {
+		t.Fatalf("Expected the run to keep running while jobs are active, got %s", got.Status)
+	}
+
+	finishJobs(t, c, models.StatusCompleted, ids[3], ids[4])
+	if got := advance(t, c, run.ID); got.Status != models.RunCompleted || got.FinishedAt == nil {
+		t.Errorf("Expected the run completed, got %s", got.Status)
+	}
+}
+
+func TestAdvance_MaxFailures(t *testing.T) {
+	c, q := newTestController(t)
+	run := &models.Run{Command: "false", Status: models.RunRunning, MaxInFlight: 1, MaxFailures: 1}
+	ids := createRun(t, c, run, 3)
+
+	advance(t, c, run.ID)
+	finishJobs(t, c, models.StatusFailed, ids[0])
+
+	// The jobs not started yet never will be
+	got := advance(t, c, run.ID)
+	if len(q.pushed) != 1 {
+		t.Errorf("Expected no more jobs released after the failure, got %v", q.pushed)
+	}
+	if !got.Stopped || got.Status != models.RunFailed {
+		t.Errorf("Expected the run stopped and failed, got stopped=%v status=%s", got.Stopped, got.Status)
+	}
+	statuses := jobStatuses(t, c, ids)
+	if statuses[1] != models.StatusCanceled || statuses[2] != models.StatusCanceled {
+		t.Errorf("Expected the pending jobs canceled, got %v", statuses)
+	}
+}
+
+func TestAdvance_RollingBatches(t *testing.T) {
+	c, q := newTestController(t)
+	run := &models.Run{Command: "true", Status: models.RunRunning, Strategy: models.RolloutStrategy{Type: models.StrategyRolling, BatchSize: 2}}
+	ids := createRun(t, c, run, 5)
+
+	if got := advance(t, c, run.ID); got.Batch != 1 || len(q.pushed) != 2 {
+		t.Fatalf("Expected batch 1 of 2 jobs, got batch %d with %v", got.Batch, q.pushed)
+	}
+	// The next batch waits for this one
+	advance(t, c, run.ID)
+	if len(q.pushed) != 2 {
+		t.Fatalf("Expected nothing released while batch 1 runs, got %v", q.pushed)
+	}
+
+	finishJobs(t, c, models.StatusCompleted, ids[0], ids[1])
+
+	// A controller that read the run before another one claimed batch 2 leaves it alone
+	var stale models.Run
+	c.db.First(&stale, "id = ?", run.ID)
+	other, _ := newTestController(t)
+	other.db = c.db
+	advance(t, other, run.ID)
+	var pending []models.Job
+	c.db.Where("run_id = ? AND status = ?", run.ID, models.StatusPending).Order("created_at").Find(&pending)
+	if err := c.nextBatch(&stale, pending, len(ids), 0, 2, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.pushed) != 2 {
+		t.Fatalf("Expected batch 2 released once, by the other controller, got %v here", q.pushed)
+	}
+
+	// With no failures allowed, a failed batch aborts the rollout
+	finishJobs(t, c, models.StatusCompleted, ids[2])
+	finishJobs(t, c, models.StatusFailed, ids[3])
+	if got := advance(t, c, run.ID); got.Status != models.RunAborted {
+		t.Fatalf("Expected the rollout aborted, got %s", got.Status)
+	}
+	if statuses := jobStatuses(t, c, ids); statuses[4] != models.StatusCanceled {
+		t.Errorf("Expected the last job canceled, got %s", statuses[4])
+	}
+}
+
+func TestCancel(t *testing.T) {
+	c, q := newTestController(t)
+	run := &models.Run{Command: "sleep 60", Status: models.RunRunning, MaxInFlight: 1}
+	ids := createRun(t, c, run, 3)
+	advance(t, c, run.ID)
+
+	if err := c.Cancel(run.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.canceled) != 3 {
+		t.Errorf("Expected a cancel message for every active job, got %v", q.canceled)
+	}
+	for i, status := range jobStatuses(t, c, ids) {
+		if status != models.StatusCanceled {
+			t.Errorf("Expected job %d canceled, got %s", i, status)
+		}
+	}
+	if got := advance(t, c, run.ID); got.Status != models.RunCanceled {
+		t.Errorf("Expected the run canceled, got %s", got.Status)
+	}
+	if err := c.Cancel(run.ID); err != ErrNotRunning {
+		t.Errorf("Expected ErrNotRunning canceling twice, got %v", err)
+	}
+}
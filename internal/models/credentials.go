@@ -0,0 +1,78 @@
+package models
+
+import (
+	"job-executor/internal/credentials"
+	"job-executor/internal/secrets"
+)
+
+// SealCredentials encrypts the server's password and keys that are still plaintext,
+// such as ones just set from a request. Encrypted values are kept as they are, so
+// nothing is decrypted. References to secrets aren't secret and stay readable.
+func (s *Server) SealCredentials(keys *credentials.Keyring) error {
+	for _, field := range s.credentialFields() {
+		encrypted, err := keys.Encrypt(*field)
+		if err != nil {
+			return err
+		}
+		*field = encrypted
+	}
+	s.CredentialsKeyVersion = s.credentialsKeyVersion()
+	return nil
+}
+
+// RotateCredentials re-encrypts the server's password and keys under the current
+// master key. It reports whether any of them changed.
+func (s *Server) RotateCredentials(keys *credentials.Keyring) (bool, error) {
+	changed := false
+	for _, field := range s.credentialFields() {
+		encrypted, rotated, err := keys.Reencrypt(*field)
+		if err != nil {
+			return false, err
+		}
+		*field = encrypted
+		changed = changed || rotated
+	}
+	s.CredentialsKeyVersion = s.credentialsKeyVersion()
+	return changed, nil
+}
+
+// ValidateReferences checks the env:// and file:// references among the server's
+// credentials, file:// ones must be inside dir
+func (s *Server) ValidateReferences(dir string) error {
+	for _, field := range []string{s.Password, s.PrivateKey, s.PemFile} {
+		if err := secrets.ValidateReference(field, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// credentialFields returns the credentials stored in the row, leaving out references
+func (s *Server) credentialFields() []*string {
+	var fields []*string
+	for _, field := range []*string{&s.Password, &s.PrivateKey, &s.PemFile} {
+		if !secrets.IsReference(*field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// credentialsKeyVersion is the oldest master key version the credentials are
+// encrypted with, 0 while any of them is plaintext or none are stored
+func (s *Server) credentialsKeyVersion() int {
+	version := 0
+	for _, field := range s.credentialFields() {
+		if *field == "" {
+			continue
+		}
+		v := credentials.Version(*field)
+		if v == 0 {
+			return 0
+		}
+		if version == 0 || v < version {
+			version = v
+		}
+	}
+	return version
+}
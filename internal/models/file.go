@@ -0,0 +1,93 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MaxJobArtifacts is how many files a job collects at most, over all its artifact paths
+const MaxJobArtifacts = 100
+
+// JobFiles are the files a job transfers over SFTP: inputs are copied to the target
+// before the command runs, and files at the artifact paths are collected afterwards
+type JobFiles struct {
+	Inputs        []JobInput `json:"inputs,omitempty" gorm:"type:text;serializer:json" binding:"omitempty,max=50,dive"`
+	ArtifactPaths []string   `json:"artifact_paths,omitempty" gorm:"type:text;serializer:json" binding:"omitempty,max=50"` // paths or glob patterns, e.g. "build/*.tar.gz"
+}
+
+// JobInput is a file in storage to put on the target
+type JobInput struct {
+	URL  string `json:"url" binding:"required"`  // as returned by POST /api/v1/files
+	Path string `json:"path" binding:"required"` // relative paths are in the login user's home directory
+	Mode string `json:"mode,omitempty"`          // octal permissions, "0644" if not set
+}
+
+// Validate checks the input modes and that no path is given twice
+func (f JobFiles) Validate() error {
+	seen := make(map[string]bool, len(f.Inputs))
+	for _, input := range f.Inputs {
+		if strings.TrimSpace(input.URL) == "" || strings.TrimSpace(input.Path) == "" {
+			return fmt.Errorf("inputs need a url and a path")
+		}
+		if seen[path.Clean(input.Path)] {
+			return fmt.Errorf("input path %q is given twice", input.Path)
+		}
+		seen[path.Clean(input.Path)] = true
+		if _, err := input.FileMode(); err != nil {
+			return err
+		}
+	}
+	for _, pattern := range f.ArtifactPaths {
+		if strings.TrimSpace(pattern) == "" {
+			return fmt.Errorf("artifact paths must not be empty")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid artifact path %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// FileMode returns the permissions to create the input with
+func (i JobInput) FileMode() (os.FileMode, error) {
+	if i.Mode == "" {
+		return 0644, nil
+	}
+	mode, err := strconv.ParseUint(i.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode %q for input %s, expected octal permissions such as 0600", i.Mode, i.Path)
+	}
+	return os.FileMode(mode), nil
+}
+
+// JobArtifact is a file collected from the target after a job's command ran
+type JobArtifact struct {
+	ID        string    `json:"id" gorm:"type:uuid;primaryKey;default:uuid_generate_v4()"`
+	JobID     string    `json:"job_id" gorm:"type:uuid;not null;index"`
+	Attempt   int       `json:"attempt"`
+	Path      string    `json:"path"`          // path on the target
+	URL       string    `json:"url,omitempty"` // where it's kept in storage, empty if it couldn't be collected
+	Size      int64     `json:"size"`
+	Error     string    `json:"error,omitempty"` // why it couldn't be collected
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime:milli"`
+}
+
+func (a *JobArtifact) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == "" {
+		a.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// ArtifactName is the name an artifact collected from remotePath is stored under.
+// Paths are kept inside the job's directory, whatever ".." they contain.
+func ArtifactName(jobID string, attempt int, remotePath string) string {
+	return fmt.Sprintf("artifacts/%s/%d/%s", jobID, attempt, strings.TrimPrefix(path.Clean("/"+remotePath), "/"))
+}
@@ -0,0 +1,37 @@
+package models
+
+import "testing"
+
+func TestJobFilesValidate(t *testing.T) {
+	valid := JobFiles{
+		Inputs:        []JobInput{{URL: "s3://bucket/files/a", Path: "app/config.yml", Mode: "0600"}},
+		ArtifactPaths: []string{"build/*.tar.gz", "/var/log/app.log"},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, files := range map[string]JobFiles{
+		"bad mode":      {Inputs: []JobInput{{URL: "u", Path: "p", Mode: "rw-r--r--"}}},
+		"mode too big":  {Inputs: []JobInput{{URL: "u", Path: "p", Mode: "4755"}}},
+		"path twice":    {Inputs: []JobInput{{URL: "u", Path: "app/a"}, {URL: "v", Path: "app/./a"}}},
+		"bad pattern":   {ArtifactPaths: []string{"build/[*.tar"}},
+		"empty pattern": {ArtifactPaths: []string{" "}},
+	} {
+		if err := files.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestArtifactName(t *testing.T) {
+	for remote, want := range map[string]string{
+		"build/app.tar.gz": "artifacts/job/2/build/app.tar.gz",
+		"/var/log/app.log": "artifacts/job/2/var/log/app.log",
+		"../../etc/passwd": "artifacts/job/2/etc/passwd",
+	} {
+		if got := ArtifactName("job", 2, remote); got != want {
+			t.Errorf("ArtifactName(%q) = %q, want %q", remote, got, want)
+		}
+	}
+}
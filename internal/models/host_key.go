@@ -0,0 +1,61 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKeysRequest replaces the host keys pinned for a server
+type HostKeysRequest struct {
+	// Each key is a SHA256 fingerprint ("SHA256:...") or a public key as found in
+	// known_hosts or ssh-keyscan output ("[host] ssh-ed25519 AAAA..."). [] unpins the
+	// server, so the next connection test pins whatever key it presents.
+	HostKeys []string `json:"host_keys" binding:"required"`
+}
+
+// HostKeysResponse lists the host key fingerprints pinned for a server
+type HostKeysResponse struct {
+	ServerID string   `json:"server_id"`
+	HostKeys []string `json:"host_keys"`
+}
+
+// NormalizeHostKeys turns fingerprints and public keys into a list of distinct
+// SHA256 fingerprints
+func NormalizeHostKeys(keys []string) ([]string, error) {
+	seen := make(map[string]bool, len(keys))
+	fingerprints := make([]string, 0, len(keys))
+	for _, key := range keys {
+		fingerprint, err := normalizeHostKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[fingerprint] {
+			seen[fingerprint] = true
+			fingerprints = append(fingerprints, fingerprint)
+		}
+	}
+	return fingerprints, nil
+}
+
+func normalizeHostKey(key string) (string, error) {
+	key = strings.TrimSpace(key)
+	if strings.HasPrefix(key, "SHA256:") {
+		hash, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(key, "SHA256:"))
+		if err != nil || len(hash) != 32 {
+			return "", fmt.Errorf("invalid host key fingerprint %q", key)
+		}
+		return key, nil
+	}
+
+	// A known_hosts line starts with the host names, an authorized key doesn't
+	if _, _, publicKey, _, _, err := ssh.ParseKnownHosts([]byte(key)); err == nil {
+		return ssh.FingerprintSHA256(publicKey), nil
+	}
+	if publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err == nil {
+		return ssh.FingerprintSHA256(publicKey), nil
+	}
+	return "", fmt.Errorf("invalid host key %q, expected a SHA256 fingerprint or a public key", key)
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeHostKeys(t *testing.T) {
+	const publicKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+	const fingerprint = "SHA256:+DiY3wvvV6TuJJhbpZisF/zLDA0zPMSvHdkr4UvCOqU"
+
+	got, err := NormalizeHostKeys([]string{fingerprint, publicKey, "example.com " + publicKey + " comment"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{fingerprint}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, key := range []string{"SHA256:tooshort", "MD5:aa:bb", "ssh-ed25519 notbase64"} {
+		if _, err := NormalizeHostKeys([]string{key}); err == nil {
+			t.Errorf("NormalizeHostKeys(%q) should fail", key)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package models
 
 import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,12 +15,17 @@ type JobStatus string
 
 const (
 	StatusQueued    JobStatus = "queued"
+	StatusPending   JobStatus = "pending"   // held back by its multi-target run until a slot is free, or by its schedule until the previous run finished
+	StatusScheduled JobStatus = "scheduled" // waiting in the queue for its run_at time
 	StatusRunning   JobStatus = "running"
 	StatusCompleted JobStatus = "completed"
 	StatusFailed    JobStatus = "failed"
 	StatusCanceled  JobStatus = "canceled"
 )
 
+// ActiveStatuses are the statuses of jobs that are still waiting to run or running
+var ActiveStatuses = []JobStatus{StatusPending, StatusQueued, StatusScheduled, StatusRunning}
+
 type Job struct {
 	ID             string     `json:"id" gorm:"type:uuid;primaryKey;default:uuid_generate_v4()"`
 	Command        string     `json:"command" gorm:"not null"`
@@ -24,7 +33,7 @@ type Job struct {
 	ServerID       string     `json:"server_id" gorm:"type:uuid"`
 	Status         JobStatus  `json:"status" gorm:"default:queued"`
 	Priority       int        `json:"priority" gorm:"default:5;check:priority >= 1 AND priority <= 10"` // priority 1-10 (10 is highest)
-	Output         string     `json:"output" gorm:"type:text"`                                          // stdout - using TEXT for large outputs
+	Output         string     `json:"output" gorm:"type:text"`                                          // no longer written, NewJobResponse fills it in from Stdout
 	Error          string     `json:"error" gorm:"type:text"`                                           // stderr - using TEXT for large outputs
 	Stdout         string     `json:"stdout" gorm:"type:text"`                                          // explicit stdout field
 	Stderr         string     `json:"stderr" gorm:"type:text"`                                          // explicit stderr field
@@ -36,11 +45,134 @@ type Job struct {
 	UpdatedAt      time.Time  `json:"updated_at" gorm:"autoUpdateTime:milli"`
 	StartedAt      *time.Time `json:"started_at"`
 	FinishedAt     *time.Time `json:"finished_at"`
+	RunAt          *time.Time `json:"run_at,omitempty"`                             // not before this time, nil means as soon as possible
+	ScheduleID     *string    `json:"schedule_id,omitempty" gorm:"type:uuid;index"` // the schedule that created this job, if any
+	RunID          *string    `json:"run_id,omitempty" gorm:"type:uuid;index"`      // the multi-target run this job belongs to, if any
+	Batch          int        `json:"batch,omitempty"`                              // the rollout batch of its run that released it
+	ForwardAgent   bool       `json:"forward_agent,omitempty"`                      // forward the worker's ssh-agent to the command
+
+	// Output beyond the worker's inline limit is kept in storage, Stdout and Stderr (and
+	// Error with them) then only hold its tail
+	StdoutLog *OutputLog `json:"stdout_log,omitempty" gorm:"type:text;serializer:json"`
+	StderrLog *OutputLog `json:"stderr_log,omitempty" gorm:"type:text;serializer:json"`
+
+	// Files copied to the target before the command runs and collected from it afterwards
+	JobFiles
+
+	// Retries
+	RetryPolicy
+	Attempt int `json:"attempt" gorm:"default:1"` // 1 for the first run, incremented on each retry
+
+	// Live output line numbers run on across attempts, so a viewer that already has
+	// lines of an earlier attempt doesn't take the next attempt's lines for them
+	OutputLines int `json:"output_lines" gorm:"default:0"` // lines published so far, over all attempts
 
 	// Relations
 	Server *Server `json:"server,omitempty" gorm:"foreignKey:ServerID;constraint:OnDelete:RESTRICT"`
 }
 
+// OutputLog points at an output stream of a job stored as chunks in object storage
+type OutputLog struct {
+	URL       string `json:"url"`
+	Size      int64  `json:"size"`           // bytes stored so far, all that could be once the job finished
+	ChunkSize int    `json:"chunk_size"`     // size of every chunk but the last
+	Lost      int64  `json:"lost,omitempty"` // bytes storage failed to take, the log is incomplete if any
+}
+
+// ScriptCommand wraps a shell script into a command and args that write it to a temporary
+// file on the target, run it with args and remove it again. The script is base64 encoded
+// to avoid issues with special characters and quotes.
+func ScriptCommand(script, args, shell string) (string, string) {
+	if shell == "" {
+		shell = "/bin/bash"
+	}
+	scriptFileName := fmt.Sprintf("/tmp/script_%s.sh", time.Now().Format("20060102_150405"))
+	scriptB64 := base64.StdEncoding.EncodeToString([]byte(script))
+	wrapped := fmt.Sprintf(`-c "echo '%s' | base64 -d > %s && chmod +x %s && %s %s; rm -f %s"`,
+		scriptB64, scriptFileName, scriptFileName, scriptFileName, args, scriptFileName)
+	return shell, wrapped
+}
+
+// maxScheduleAhead is how far in the future a job may be scheduled
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+// DelayedStart lets a job request defer execution, either until a point in time or by
+// a number of seconds from submission
+type DelayedStart struct {
+	RunAt *time.Time `json:"run_at,omitempty"` // e.g. "2024-12-10T02:00:00Z"
+	Delay int        `json:"delay,omitempty"`  // seconds from now
+}
+
+// ScheduledTime resolves when the job should run, or nil for straight away. A time that
+// has already passed means straight away too.
+func (d DelayedStart) ScheduledTime(now time.Time) (*time.Time, error) {
+	if d.RunAt != nil && d.Delay != 0 {
+		return nil, fmt.Errorf("run_at and delay are mutually exclusive")
+	}
+	if d.Delay < 0 {
+		return nil, fmt.Errorf("delay must not be negative")
+	}
+
+	var runAt time.Time
+	switch {
+	case d.RunAt != nil:
+		runAt = d.RunAt.UTC()
+	case d.Delay > 0:
+		runAt = now.Add(time.Duration(d.Delay) * time.Second).UTC()
+	default:
+		return nil, nil
+	}
+	if !runAt.After(now) {
+		return nil, nil
+	}
+	if runAt.Sub(now) > maxScheduleAhead {
+		return nil, fmt.Errorf("jobs can be scheduled at most %d days ahead", int(maxScheduleAhead.Hours()/24))
+	}
+	return &runAt, nil
+}
+
+// RetryPolicy controls whether and when a failed job is run again. Only failures that
+// may go away on their own are retried: the server couldn't be reached, the command
+// timed out, or it exited with one of RetryExitCodes.
+type RetryPolicy struct {
+	MaxRetries     int    `json:"max_retries" binding:"omitempty,min=0,max=20"`     // retries after the first attempt, 0 disables retrying
+	RetryBackoff   int    `json:"retry_backoff" binding:"omitempty,min=0,max=3600"` // seconds before the first retry, doubled for each one after
+	RetryExitCodes string `json:"retry_exit_codes"`                                 // comma separated exit codes worth retrying, e.g. "1,255"
+}
+
+// Validate checks that RetryExitCodes is a list of exit codes
+func (p RetryPolicy) Validate() error {
+	_, err := p.exitCodes()
+	return err
+}
+
+// RetriesExitCode reports whether a command exiting with code should be retried
+func (p RetryPolicy) RetriesExitCode(code int) bool {
+	codes, _ := p.exitCodes()
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (p RetryPolicy) exitCodes() ([]int, error) {
+	var codes []int
+	for _, field := range strings.Split(p.RetryExitCodes, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		code, err := strconv.Atoi(field)
+		if err != nil || code < 1 || code > 255 {
+			return nil, fmt.Errorf("invalid retry exit code %q", field)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
 func (j *Job) BeforeCreate(tx *gorm.DB) error {
 	if j.ID == "" {
 		j.ID = uuid.New().String()
@@ -51,19 +183,44 @@ func (j *Job) BeforeCreate(tx *gorm.DB) error {
 type JobRequest struct {
 	Command  string `json:"command" binding:"required"`
 	Args     string `json:"args"`
-	ServerID string `json:"server_id" binding:"required"`
+	ServerID string `json:"server_id"` // required unless servers are picked with a selector
 	Timeout  int    `json:"timeout,omitempty"`
 	Priority int    `json:"priority,omitempty"` // priority 1-10 (10 is highest), defaults to 5
+
+	// ForwardAgent forwards the worker's ssh-agent, so the command can use its keys,
+	// e.g. to git clone private repositories on the target
+	ForwardAgent bool `json:"forward_agent,omitempty"`
+
+	JobFiles
+	ServerSelector // fans the job out as a multi-target run instead of targeting ServerID
+	DelayedStart
+	RetryPolicy
+
+	Strategy *RolloutStrategy `json:"strategy,omitempty"` // how the run releases its jobs, only with a selector
+}
+
+// BatchJobRequest submits several jobs at once
+type BatchJobRequest struct {
+	Jobs []JobRequest `json:"jobs" binding:"required,min=1,max=500,dive"`
 }
 
 // ScriptJobRequest handles shell script execution
 type ScriptJobRequest struct {
-	Script   string `json:"script" binding:"required"`    // The shell script content
-	Args     string `json:"args"`                         // Arguments to pass to the script
-	ServerID string `json:"server_id" binding:"required"` // Target server
-	Timeout  int    `json:"timeout,omitempty"`            // Execution timeout
-	Shell    string `json:"shell,omitempty"`              // Shell to use (default: /bin/bash)
-	Priority int    `json:"priority,omitempty"`           // priority 1-10 (10 is highest), defaults to 5
+	Script   string `json:"script" binding:"required"` // The shell script content
+	Args     string `json:"args"`                      // Arguments to pass to the script
+	ServerID string `json:"server_id"`                 // Target server, required unless a selector is given
+	Timeout  int    `json:"timeout,omitempty"`         // Execution timeout
+	Shell    string `json:"shell,omitempty"`           // Shell to use (default: /bin/bash)
+	Priority int    `json:"priority,omitempty"`        // priority 1-10 (10 is highest), defaults to 5
+
+	ForwardAgent bool `json:"forward_agent,omitempty"` // forward the worker's ssh-agent to the script
+
+	JobFiles
+	ServerSelector // fans the job out as a multi-target run instead of targeting ServerID
+	DelayedStart
+	RetryPolicy
+
+	Strategy *RolloutStrategy `json:"strategy,omitempty"` // how the run releases its jobs, only with a selector
 }
 
 // DuplicateJobRequest handles job duplication
@@ -78,6 +235,13 @@ type JobResponse struct {
 	Duration *time.Duration `json:"duration,omitempty"`
 }
 
+// NewJobResponse returns the API representation of job. Output is the same as
+// Stdout, workers only store it once.
+func NewJobResponse(job Job) *JobResponse {
+	job.Output = job.Stdout
+	return &JobResponse{Job: job}
+}
+
 func (jr *JobResponse) CalculateDuration() {
 	if jr.StartedAt != nil && jr.FinishedAt != nil {
 		duration := jr.FinishedAt.Sub(*jr.StartedAt)
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"job-executor/internal/config"
+
+	"gorm.io/gorm"
+)
+
+// MaxJumpHops is how many jump hosts a connection may pass through
+const MaxJumpHops = 5
+
+// ErrJumpHostLoop is returned when a server's jump hosts lead back to itself
+var ErrJumpHostLoop = errors.New("jump hosts form a loop")
+
+// SSHConfig returns the settings to connect to the server itself, leaving out any jump hosts
+func (s *Server) SSHConfig() *config.SSHConfig {
+	cfg := &config.SSHConfig{
+		Host:       s.Hostname,
+		Port:       fmt.Sprintf("%d", s.Port),
+		User:       s.User,
+		Password:   s.Password,
+		PrivateKey: s.PrivateKey,
+		PemFileURL: s.PemFileURL,
+		HostKeys:   s.HostKeys,
+		UseAgent:   s.AuthType == "agent",
+
+		Certificate:     s.Certificate,
+		MintCertificate: s.AuthType == "certificate" && s.Certificate == "",
+	}
+
+	// Use PEM file if provided (legacy support)
+	if s.PemFile != "" {
+		cfg.PrivateKey = s.PemFile
+	}
+	return cfg
+}
+
+// JumpChain loads the jump hosts to pass through to reach server, starting with the
+// one to dial directly. It's empty for servers reached directly.
+func JumpChain(db *gorm.DB, server *Server) ([]Server, error) {
+	var chain []Server
+	seen := map[string]bool{server.ID: true}
+	next := server.JumpHostID
+	for next != nil {
+		if seen[*next] {
+			return nil, ErrJumpHostLoop
+		}
+		if len(chain) == MaxJumpHops {
+			return nil, fmt.Errorf("more than %d jump hosts", MaxJumpHops)
+		}
+		seen[*next] = true
+
+		var hop Server
+		if err := db.First(&hop, "id = ?", *next).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, fmt.Errorf("jump host not found: %s", *next)
+			}
+			return nil, err
+		}
+		chain = append([]Server{hop}, chain...)
+		next = hop.JumpHostID
+	}
+	return chain, nil
+}
+
+// JumpSSHConfigs returns the connection settings of each server in a jump chain
+func JumpSSHConfigs(chain []Server) []config.SSHConfig {
+	configs := make([]config.SSHConfig, 0, len(chain))
+	for i := range chain {
+		configs = append(configs, *chain[i].SSHConfig())
+	}
+	return configs
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ServerLabel is a key/value label on a server, such as env=prod or role=db
+type ServerLabel struct {
+	ID       uint   `json:"-" gorm:"primaryKey"`
+	ServerID string `json:"-" gorm:"type:uuid;not null;uniqueIndex:idx_server_labels_server_key"`
+	Key      string `json:"key" gorm:"not null;uniqueIndex:idx_server_labels_server_key;index:idx_server_labels_key_value"`
+	Value    string `json:"value" gorm:"not null;default:'';index:idx_server_labels_key_value"`
+}
+
+// ServerGroup is a named set of servers
+type ServerGroup struct {
+	ID          string    `json:"id" gorm:"type:uuid;primaryKey;default:uuid_generate_v4()"`
+	Name        string    `json:"name" gorm:"not null;unique"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Relations
+	Servers []Server `json:"servers,omitempty" gorm:"many2many:server_group_members;constraint:OnDelete:CASCADE"`
+}
+
+func (g *ServerGroup) BeforeCreate(tx *gorm.DB) error {
+	if g.ID == "" {
+		g.ID = uuid.New().String()
+	}
+	return nil
+}
+
+type ServerGroupRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description,omitempty"`
+	ServerIDs   []string `json:"server_ids,omitempty"`
+}
+
+type ServerGroupUpdateRequest struct {
+	Name        string   `json:"name,omitempty"`
+	Description *string  `json:"description,omitempty"`
+	ServerIDs   []string `json:"server_ids,omitempty"` // replaces the members when given, [] removes them all
+}
+
+// ServerGroupResponse lists a group's members without their credentials
+type ServerGroupResponse struct {
+	ServerGroup
+	Servers []ServerResponse `json:"servers"`
+}
+
+func NewServerGroupResponse(group ServerGroup) *ServerGroupResponse {
+	response := &ServerGroupResponse{ServerGroup: group, Servers: make([]ServerResponse, 0, len(group.Servers))}
+	for _, server := range group.Servers {
+		response.Servers = append(response.Servers, *NewServerResponse(server))
+	}
+	return response
+}
+
+// labelPattern is what label keys and values may look like
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]{0,61}[A-Za-z0-9])?$`)
+
+// ValidateLabels checks label keys and values. Values may be empty.
+func ValidateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if !labelPattern.MatchString(key) {
+			return fmt.Errorf("invalid label key %q", key)
+		}
+		if value != "" && !labelPattern.MatchString(value) {
+			return fmt.Errorf("invalid value for label %q: %q", key, value)
+		}
+	}
+	return nil
+}
+
+// LabelsFromMap turns a key/value map into labels, sorted by key
+func LabelsFromMap(labels map[string]string) []ServerLabel {
+	result := make([]ServerLabel, 0, len(labels))
+	for key, value := range labels {
+		result = append(result, ServerLabel{Key: key, Value: value})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// labelRequirement is one comma separated term of a selector
+type labelRequirement struct {
+	key, op, value string // op is "=", "!=", "exists" or "!exists"
+}
+
+// ServerSelector picks servers by label selector and group instead of by ID
+type ServerSelector struct {
+	// Selector is a comma separated list of label requirements, all of which must hold:
+	// key=value, key!=value (also matches servers without the key), key (has the label)
+	// and !key (doesn't have it)
+	Selector string `json:"selector,omitempty"`
+	Group    string `json:"group,omitempty"` // name of a server group
+}
+
+// IsSet reports whether any selection was given
+func (s ServerSelector) IsSet() bool {
+	return strings.TrimSpace(s.Selector) != "" || s.Group != ""
+}
+
+// Scope parses the selector into a query scope on the servers table
+func (s ServerSelector) Scope() (func(*gorm.DB) *gorm.DB, error) {
+	requirements, err := parseSelector(s.Selector)
+	if err != nil {
+		return nil, err
+	}
+	group := s.Group
+
+	return func(db *gorm.DB) *gorm.DB {
+		const hasLabel = "EXISTS (SELECT 1 FROM server_labels WHERE server_labels.server_id = servers.id AND server_labels.key = ?"
+		for _, r := range requirements {
+			switch r.op {
+			case "=":
+				db = db.Where(hasLabel+" AND server_labels.value = ?)", r.key, r.value)
+			case "!=":
+				db = db.Where("NOT "+hasLabel+" AND server_labels.value = ?)", r.key, r.value)
+			case "exists":
+				db = db.Where(hasLabel+")", r.key)
+			case "!exists":
+				db = db.Where("NOT "+hasLabel+")", r.key)
+			}
+		}
+		if group != "" {
+			db = db.Where(`EXISTS (SELECT 1 FROM server_group_members
+				JOIN server_groups ON server_groups.id = server_group_members.server_group_id
+				WHERE server_group_members.server_id = servers.id AND server_groups.name = ?)`, group)
+		}
+		return db
+	}, nil
+}
+
+// parseSelector parses a selector such as "env=prod,role!=bastion,!deprecated"
+func parseSelector(selector string) ([]labelRequirement, error) {
+	var requirements []labelRequirement
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var r labelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			parts := strings.SplitN(term, "!=", 2)
+			r = labelRequirement{key: parts[0], op: "!=", value: parts[1]}
+		case strings.Contains(term, "=="):
+			parts := strings.SplitN(term, "==", 2)
+			r = labelRequirement{key: parts[0], op: "=", value: parts[1]}
+		case strings.Contains(term, "="):
+			parts := strings.SplitN(term, "=", 2)
+			r = labelRequirement{key: parts[0], op: "=", value: parts[1]}
+		case strings.HasPrefix(term, "!"):
+			r = labelRequirement{key: term[1:], op: "!exists"}
+		default:
+			r = labelRequirement{key: term, op: "exists"}
+		}
+
+		r.key, r.value = strings.TrimSpace(r.key), strings.TrimSpace(r.value)
+		if !labelPattern.MatchString(r.key) {
+			return nil, fmt.Errorf("invalid label key in selector: %q", term)
+		}
+		if r.value != "" && !labelPattern.MatchString(r.value) {
+			return nil, fmt.Errorf("invalid label value in selector: %q", term)
+		}
+		requirements = append(requirements, r)
+	}
+	return requirements, nil
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	got, err := parseSelector("env=prod, role!=bastion,tier==web,gpu,!deprecated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []labelRequirement{
+		{key: "env", op: "=", value: "prod"},
+		{key: "role", op: "!=", value: "bastion"},
+		{key: "tier", op: "=", value: "web"},
+		{key: "gpu", op: "exists"},
+		{key: "deprecated", op: "!exists"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for _, selector := range []string{"=prod", "env=pr od", "!", "env=prod;drop"} {
+		if _, err := parseSelector(selector); err == nil {
+			t.Errorf("parseSelector(%q) should fail", selector)
+		}
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	if err := ValidateLabels(map[string]string{"env": "prod", "team.example.com/owner": "ops", "canary": ""}); err != nil {
+		t.Errorf("valid labels rejected: %v", err)
+	}
+	if err := ValidateLabels(map[string]string{"bad key": "x"}); err == nil {
+		t.Error("expected an error for a key with a space")
+	}
+	if err := ValidateLabels(map[string]string{"env": "-prod"}); err == nil {
+		t.Error("expected an error for a value starting with a dash")
+	}
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type RunStatus string
+
+const (
+	RunRunning   RunStatus = "running"
+	RunCompleted RunStatus = "completed" // every job completed
+	RunFailed    RunStatus = "failed"    // at least one job failed, or the run stopped after too many failures
+	RunCanceled  RunStatus = "canceled"
+	RunPaused    RunStatus = "paused"  // no more jobs are released until it is resumed
+	RunAborted   RunStatus = "aborted" // the rollout was stopped, jobs not yet released were canceled
+)
+
+const (
+	StrategyParallel = "parallel" // release jobs as MaxInFlight allows
+	StrategyRolling  = "rolling"  // release jobs in batches, each once the one before it succeeded
+)
+
+// RolloutStrategy decides how a run releases its jobs
+type RolloutStrategy struct {
+	Type         string `json:"type,omitempty" binding:"omitempty,oneof=parallel rolling"` // defaults to parallel
+	BatchSize    int    `json:"batch_size,omitempty" binding:"min=0"`                      // hosts per batch
+	BatchPercent int    `json:"batch_percent,omitempty" binding:"min=0,max=100"`           // or the share of hosts per batch
+	BatchPause   int    `json:"batch_pause,omitempty" binding:"min=0"`                     // seconds to wait between batches
+
+	// MaxFailureRate is the percentage of finished jobs allowed to fail. Before each
+	// batch, a higher rate aborts the rollout; 0 aborts on any failure.
+	MaxFailureRate int `json:"max_failure_rate,omitempty" binding:"min=0,max=100"`
+}
+
+// Validate checks a rolling strategy says how big its batches are
+func (s RolloutStrategy) Validate() error {
+	if s.Type == StrategyRolling && s.BatchSize == 0 && s.BatchPercent == 0 {
+		return errors.New("a rolling strategy needs batch_size or batch_percent")
+	}
+	if s.BatchSize > 0 && s.BatchPercent > 0 {
+		return errors.New("give either batch_size or batch_percent, not both")
+	}
+	return nil
+}
+
+// BatchSizeFor is the number of hosts in each batch of a run on total hosts
+func (s RolloutStrategy) BatchSizeFor(total int) int {
+	if s.BatchSize > 0 {
+		return s.BatchSize
+	}
+	size := (total*s.BatchPercent + 99) / 100
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+// Run is one command fanned out across several servers, as one child job per server
+type Run struct {
+	ID          string     `json:"id" gorm:"type:uuid;primaryKey;default:uuid_generate_v4()"`
+	Command     string     `json:"command"`
+	Args        string     `json:"args"`
+	Script      string     `json:"script,omitempty" gorm:"type:text"` // run instead of Command when set
+	Shell       string     `json:"shell,omitempty"`
+	Status      RunStatus  `json:"status" gorm:"default:running;index"`
+	MaxInFlight int        `json:"max_in_flight"` // jobs allowed to be queued or running at once, 0 for no limit
+	MaxFailures int        `json:"max_failures"`  // stop starting jobs after this many failed, 0 to never stop
+	Stopped     bool       `json:"stopped"`       // MaxFailures was reached and the remaining jobs were canceled
+	Batch       int        `json:"batch"`         // the last batch released by a rolling run
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+
+	Strategy RolloutStrategy `json:"strategy" gorm:"embedded;embeddedPrefix:strategy_"`
+
+	// Relations
+	Jobs []Job `json:"jobs,omitempty" gorm:"foreignKey:RunID"`
+}
+
+func (r *Run) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	return nil
+}
+
+type RunRequest struct {
+	Command     string   `json:"command,omitempty"`
+	Args        string   `json:"args,omitempty"`
+	Script      string   `json:"script,omitempty"` // either command or script is required
+	Shell       string   `json:"shell,omitempty"`
+	ServerIDs   []string `json:"server_ids,omitempty"` // servers to run on, or pick them with the selector
+	Timeout     int      `json:"timeout,omitempty"`
+	Priority    int      `json:"priority,omitempty"`
+	MaxInFlight int      `json:"max_in_flight,omitempty" binding:"min=0"`
+	MaxFailures int      `json:"max_failures,omitempty" binding:"min=0"`
+
+	ForwardAgent bool `json:"forward_agent,omitempty"` // forward the worker's ssh-agent to each job
+
+	Strategy *RolloutStrategy `json:"strategy,omitempty"`
+
+	JobFiles
+	ServerSelector
+	RetryPolicy
+}
+
+// HostResult is the outcome of a run on one server
+type HostResult struct {
+	ServerID   string     `json:"server_id"`
+	ServerName string     `json:"server_name"`
+	Hostname   string     `json:"hostname"`
+	JobID      string     `json:"job_id"`
+	Batch      int        `json:"batch,omitempty"`
+	Status     JobStatus  `json:"status"`
+	ExitCode   *int       `json:"exit_code"`
+	Stdout     string     `json:"stdout"`
+	Stderr     string     `json:"stderr"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// RunResponse is a run with its results aggregated per host
+type RunResponse struct {
+	Run
+	StatusCounts   map[JobStatus]int `json:"status_counts"`
+	ExitCodeCounts map[string]int    `json:"exit_code_counts"` // exit code -> number of hosts
+	Hosts          []HostResult      `json:"hosts"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "testing"
+
+func TestRolloutStrategy_BatchSize(t *testing.T) {
+	cases := []struct {
+		strategy RolloutStrategy
+		total    int
+		want     int
+	}{
+		{RolloutStrategy{Type: StrategyRolling, BatchSize: 3}, 10, 3},
+		{RolloutStrategy{Type: StrategyRolling, BatchPercent: 25}, 10, 3}, // rounded up
+		{RolloutStrategy{Type: StrategyRolling, BatchPercent: 1}, 10, 1},
+		{RolloutStrategy{Type: StrategyRolling, BatchPercent: 100}, 7, 7},
+	}
+	for _, tc := range cases {
+		if err := tc.strategy.Validate(); err != nil {
+			t.Errorf("%+v: %v", tc.strategy, err)
+		}
+		if got := tc.strategy.BatchSizeFor(tc.total); got != tc.want {
+			t.Errorf("%+v on %d hosts: got batches of %d, want %d", tc.strategy, tc.total, got, tc.want)
+		}
+	}
+
+	if err := (RolloutStrategy{Type: StrategyRolling}).Validate(); err == nil {
+		t.Error("expected an error for a rolling strategy without a batch size")
+	}
+	if err := (RolloutStrategy{Type: StrategyRolling, BatchSize: 2, BatchPercent: 10}).Validate(); err == nil {
+		t.Error("expected an error for both batch_size and batch_percent")
+	}
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OverlapPolicy decides what happens when a schedule fires while its previous job is still active
+type OverlapPolicy string
+
+const (
+	OverlapSkip           OverlapPolicy = "skip"            // don't start a new job
+	OverlapQueue          OverlapPolicy = "queue"           // start a new job once the active one has finished
+	OverlapCancelPrevious OverlapPolicy = "cancel-previous" // cancel the active job, then start a new one
+)
+
+// Schedule runs a command or script on a server according to a cron expression
+type Schedule struct {
+	ID            string        `json:"id" gorm:"type:uuid;primaryKey;default:uuid_generate_v4()"`
+	Name          string        `json:"name" gorm:"not null"`
+	CronExpr      string        `json:"cron_expr" gorm:"not null"`   // five-field cron expression or a descriptor such as @daily
+	Timezone      string        `json:"timezone" gorm:"default:UTC"` // IANA zone the expression is evaluated in
+	Command       string        `json:"command"`
+	Args          string        `json:"args"`
+	Script        string        `json:"script,omitempty" gorm:"type:text"` // run instead of Command when set
+	Shell         string        `json:"shell,omitempty"`
+	ServerID      string        `json:"server_id" gorm:"type:uuid"`
+	Timeout       int           `json:"timeout" gorm:"default:300"`
+	Priority      int           `json:"priority" gorm:"default:5"`
+	OverlapPolicy OverlapPolicy `json:"overlap_policy" gorm:"default:skip"`
+	Enabled       bool          `json:"enabled"`
+	NextRunAt     *time.Time    `json:"next_run_at" gorm:"index"`
+	LastRunAt     *time.Time    `json:"last_run_at"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+
+	RetryPolicy
+
+	// Relations
+	Server *Server `json:"server,omitempty" gorm:"foreignKey:ServerID;constraint:OnDelete:RESTRICT"`
+}
+
+func (s *Schedule) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == "" {
+		s.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// NewJob materialises the job for one run of the schedule
+func (s *Schedule) NewJob() *Job {
+	command, args := s.Command, s.Args
+	if s.Script != "" {
+		command, args = ScriptCommand(s.Script, s.Args, s.Shell)
+	}
+	scheduleID := s.ID
+	return &Job{
+		Command:        command,
+		Args:           args,
+		ServerID:       s.ServerID,
+		Timeout:        s.Timeout,
+		Priority:       s.Priority,
+		Status:         StatusQueued,
+		OriginalScript: s.Script,
+		ScheduleID:     &scheduleID,
+		RetryPolicy:    s.RetryPolicy,
+	}
+}
+
+type ScheduleRequest struct {
+	Name          string        `json:"name" binding:"required"`
+	CronExpr      string        `json:"cron_expr" binding:"required"`
+	Timezone      string        `json:"timezone,omitempty"` // defaults to UTC
+	Command       string        `json:"command,omitempty"`
+	Args          string        `json:"args,omitempty"`
+	Script        string        `json:"script,omitempty"` // either command or script is required
+	Shell         string        `json:"shell,omitempty"`
+	ServerID      string        `json:"server_id" binding:"required"`
+	Timeout       int           `json:"timeout,omitempty"`
+	Priority      int           `json:"priority,omitempty"`
+	OverlapPolicy OverlapPolicy `json:"overlap_policy,omitempty" binding:"omitempty,oneof=skip queue cancel-previous"`
+	Enabled       *bool         `json:"enabled,omitempty"`
+
+	RetryPolicy
+}
+
+type ScheduleUpdateRequest struct {
+	Name          string        `json:"name,omitempty"`
+	CronExpr      string        `json:"cron_expr,omitempty"`
+	Timezone      string        `json:"timezone,omitempty"`
+	Command       *string       `json:"command,omitempty"`
+	Args          *string       `json:"args,omitempty"`
+	Script        *string       `json:"script,omitempty"`
+	Shell         string        `json:"shell,omitempty"`
+	ServerID      string        `json:"server_id,omitempty"`
+	Timeout       *int          `json:"timeout,omitempty"`
+	Priority      *int          `json:"priority,omitempty"`
+	OverlapPolicy OverlapPolicy `json:"overlap_policy,omitempty" binding:"omitempty,oneof=skip queue cancel-previous"`
+	Enabled       *bool         `json:"enabled,omitempty"`
+
+	*RetryPolicy // replaces the whole retry policy when any of its fields is given
+}
+
+// SchedulerLease records which scheduler instance is the leader, so only one fires schedules
+type SchedulerLease struct {
+	Name      string    `json:"name" gorm:"primaryKey"`
+	Holder    string    `json:"holder" gorm:"not null"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null"`
+}
@@ -13,14 +13,28 @@ type Server struct {
 	Hostname   string    `json:"hostname" gorm:"not null"`
 	Port       int       `json:"port" gorm:"default:22"`
 	User       string    `json:"user" gorm:"not null"`
-	AuthType   string    `json:"auth_type" gorm:"not null"` // "password" or "key"
+	AuthType   string    `json:"auth_type" gorm:"not null"` // "password", "key", "agent" (the worker's ssh-agent) or "certificate"
 	Password   string    `json:"password,omitempty"`
 	PrivateKey string    `json:"private_key,omitempty"`
-	PemFile    string    `json:"pem_file,omitempty"`           // Direct PEM content (deprecated)
-	PemFileURL string    `json:"pem_file_url,omitempty"`       // URL to PEM file in object storage
+	PemFile    string    `json:"pem_file,omitempty"`                            // Direct PEM content (deprecated)
+	PemFileURL string    `json:"pem_file_url,omitempty"`                        // URL to PEM file in object storage
+	HostKeys   []string  `json:"host_keys" gorm:"serializer:json"`              // pinned SHA256 fingerprints, empty until first use
+	JumpHostID *string   `json:"jump_host_id,omitempty" gorm:"type:uuid;index"` // server to connect through, nil to dial directly
 	IsActive   bool      `json:"is_active" gorm:"default:true"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
+
+	// Certificate is the OpenSSH user certificate for the key. Without one, servers with
+	// certificate authentication get a certificate per job from the worker's CA.
+	Certificate string `json:"certificate,omitempty" gorm:"type:text"`
+
+	// CredentialsKeyVersion is the oldest master key version the password and keys are
+	// encrypted with, 0 while any of them is stored as plaintext or none are stored
+	CredentialsKeyVersion int `json:"credentials_key_version" gorm:"default:0;index"`
+
+	// Relations
+	Labels []ServerLabel `json:"labels,omitempty" gorm:"foreignKey:ServerID;constraint:OnDelete:CASCADE"`
+	Groups []ServerGroup `json:"groups,omitempty" gorm:"many2many:server_group_members;constraint:OnDelete:CASCADE"`
 }
 
 func (s *Server) BeforeCreate(tx *gorm.DB) error {
@@ -35,12 +49,18 @@ type ServerRequest struct {
 	Hostname   string `json:"hostname" binding:"required"`
 	Port       int    `json:"port"`
 	User       string `json:"user" binding:"required"`
-	AuthType   string `json:"auth_type" binding:"required,oneof=password key"`
+	AuthType   string `json:"auth_type" binding:"required,oneof=password key agent certificate"`
 	Password   string `json:"password,omitempty"`
 	PrivateKey string `json:"private_key,omitempty"`
 	PemFile    string `json:"pem_file,omitempty"`     // Direct PEM content (deprecated)
 	PemFileURL string `json:"pem_file_url,omitempty"` // URL to uploaded PEM file
 	IsActive   *bool  `json:"is_active,omitempty"`
+
+	Labels     map[string]string `json:"labels,omitempty"`
+	HostKeys   []string          `json:"host_keys,omitempty"` // pins the host keys up front instead of on first use
+	JumpHostID *string           `json:"jump_host_id,omitempty"`
+
+	Certificate string `json:"certificate,omitempty"` // OpenSSH user certificate, as in id_ed25519-cert.pub
 }
 
 type ServerUpdateRequest struct {
@@ -48,12 +68,17 @@ type ServerUpdateRequest struct {
 	Hostname   string `json:"hostname,omitempty"`
 	Port       *int   `json:"port,omitempty"`
 	User       string `json:"user,omitempty"`
-	AuthType   string `json:"auth_type,omitempty"`
+	AuthType   string `json:"auth_type,omitempty" binding:"omitempty,oneof=password key agent certificate"`
 	Password   string `json:"password,omitempty"`
 	PrivateKey string `json:"private_key,omitempty"`
 	PemFile    string `json:"pem_file,omitempty"`     // Direct PEM content (deprecated)
 	PemFileURL string `json:"pem_file_url,omitempty"` // URL to uploaded PEM file
 	IsActive   *bool  `json:"is_active,omitempty"`
+
+	Labels     map[string]string `json:"labels,omitempty"`       // replaces all labels when given, {} removes them
+	JumpHostID *string           `json:"jump_host_id,omitempty"` // "" connects directly again
+
+	Certificate *string `json:"certificate,omitempty"` // "" drops the certificate, so the worker's CA issues them
 }
 
 type ServerResponse struct {
@@ -62,4 +87,24 @@ type ServerResponse struct {
 	Password   string `json:"-"`
 	PrivateKey string `json:"-"`
 	PemFile    string `json:"-"` // Hide direct PEM content
+
+	Labels map[string]string `json:"labels"`
+	Groups []string          `json:"groups"` // group names
+}
+
+// NewServerResponse builds the response for a server, with its labels and groups
+// flattened if they were loaded
+func NewServerResponse(server Server) *ServerResponse {
+	response := &ServerResponse{
+		Server: server,
+		Labels: make(map[string]string, len(server.Labels)),
+		Groups: make([]string, 0, len(server.Groups)),
+	}
+	for _, label := range server.Labels {
+		response.Labels[label.Key] = label.Value
+	}
+	for _, group := range server.Groups {
+		response.Groups = append(response.Groups, group.Name)
+	}
+	return response
 }
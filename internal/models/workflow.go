@@ -0,0 +1,154 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type WorkflowStatus string
+
+const (
+	WorkflowRunning   WorkflowStatus = "running"
+	WorkflowCompleted WorkflowStatus = "completed" // every step that ran succeeded
+	WorkflowFailed    WorkflowStatus = "failed"    // at least one step failed
+	WorkflowCanceled  WorkflowStatus = "canceled"
+)
+
+type StepStatus string
+
+const (
+	StepPending   StepStatus = "pending" // waiting for the steps it depends on
+	StepQueued    StepStatus = "queued"  // its job has been created
+	StepRunning   StepStatus = "running"
+	StepCompleted StepStatus = "completed"
+	StepFailed    StepStatus = "failed"
+	StepCanceled  StepStatus = "canceled"
+	StepSkipped   StepStatus = "skipped" // the conditions on its dependencies weren't met
+)
+
+// Finished reports whether the step won't change status any more
+func (s StepStatus) Finished() bool {
+	switch s {
+	case StepCompleted, StepFailed, StepCanceled, StepSkipped:
+		return true
+	}
+	return false
+}
+
+// StepCondition decides which outcomes of an upstream step let a dependent step run
+type StepCondition string
+
+const (
+	OnSuccess StepCondition = "on_success" // the upstream step completed
+	OnFailure StepCondition = "on_failure" // the upstream step failed or was canceled
+	Always    StepCondition = "always"     // the upstream step finished in any way, even skipped
+)
+
+// Satisfied reports whether an upstream step that finished with status lets the dependent step run
+func (c StepCondition) Satisfied(status StepStatus) bool {
+	switch c {
+	case OnFailure:
+		return status == StepFailed || status == StepCanceled
+	case Always:
+		return status.Finished()
+	default:
+		return status == StepCompleted
+	}
+}
+
+// StepDependency is an edge from the named upstream step
+type StepDependency struct {
+	Step      string        `json:"step" binding:"required"`
+	Condition StepCondition `json:"condition,omitempty" binding:"omitempty,oneof=on_success on_failure always"` // defaults to on_success
+}
+
+// Workflow is a set of steps run as jobs in the order given by their dependencies
+type Workflow struct {
+	ID         string         `json:"id" gorm:"type:uuid;primaryKey;default:uuid_generate_v4()"`
+	Name       string         `json:"name" gorm:"not null"`
+	Status     WorkflowStatus `json:"status" gorm:"default:running;index"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	FinishedAt *time.Time     `json:"finished_at"`
+
+	// Relations
+	Steps []WorkflowStep `json:"steps,omitempty" gorm:"foreignKey:WorkflowID;constraint:OnDelete:CASCADE"`
+}
+
+func (w *Workflow) BeforeCreate(tx *gorm.DB) error {
+	if w.ID == "" {
+		w.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// WorkflowStep is one job in a workflow
+type WorkflowStep struct {
+	ID         string           `json:"id" gorm:"type:uuid;primaryKey;default:uuid_generate_v4()"`
+	WorkflowID string           `json:"workflow_id" gorm:"type:uuid;index;not null"`
+	Name       string           `json:"name" gorm:"not null"` // unique within the workflow
+	Command    string           `json:"command"`
+	Args       string           `json:"args"`
+	Script     string           `json:"script,omitempty" gorm:"type:text"` // run instead of Command when set
+	Shell      string           `json:"shell,omitempty"`
+	ServerID   string           `json:"server_id" gorm:"type:uuid"`
+	Timeout    int              `json:"timeout" gorm:"default:300"`
+	Priority   int              `json:"priority" gorm:"default:5"`
+	DependsOn  []StepDependency `json:"depends_on" gorm:"serializer:json"`
+	Status     StepStatus       `json:"status" gorm:"default:pending"`
+	JobID      *string          `json:"job_id" gorm:"type:uuid"`
+
+	RetryPolicy
+}
+
+func (s *WorkflowStep) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == "" {
+		s.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// NewJob materialises the job that runs the step
+func (s *WorkflowStep) NewJob() *Job {
+	command, args := s.Command, s.Args
+	if s.Script != "" {
+		command, args = ScriptCommand(s.Script, s.Args, s.Shell)
+	}
+	return &Job{
+		Command:        command,
+		Args:           args,
+		ServerID:       s.ServerID,
+		Timeout:        s.Timeout,
+		Priority:       s.Priority,
+		Status:         StatusQueued,
+		OriginalScript: s.Script,
+		RetryPolicy:    s.RetryPolicy,
+	}
+}
+
+type WorkflowRequest struct {
+	Name  string                `json:"name" binding:"required"`
+	Steps []WorkflowStepRequest `json:"steps" binding:"required,min=1,dive"`
+}
+
+type WorkflowStepRequest struct {
+	Name      string           `json:"name" binding:"required"`
+	Command   string           `json:"command,omitempty"`
+	Args      string           `json:"args,omitempty"`
+	Script    string           `json:"script,omitempty"` // either command or script is required
+	Shell     string           `json:"shell,omitempty"`
+	ServerID  string           `json:"server_id" binding:"required"`
+	Timeout   int              `json:"timeout,omitempty"`
+	Priority  int              `json:"priority,omitempty"`
+	DependsOn []StepDependency `json:"depends_on,omitempty" binding:"dive"`
+
+	RetryPolicy
+}
+
+// WorkflowResponse is a workflow with a count of its steps in each status
+type WorkflowResponse struct {
+	Workflow
+	StepCounts map[StepStatus]int `json:"step_counts"`
+}
@@ -0,0 +1,76 @@
+package netqueue
+
+import (
+	"context"
+	"job-executor/internal/models"
+	"testing"
+	"time"
+)
+
+func TestNetQueue_BatchPushAndPrefetch(t *testing.T) {
+	addr := ":9101"
+	server := NewNetQueueServer()
+	go server.Start(addr)
+	time.Sleep(200 * time.Millisecond)
+
+	client, err := NewNetQueueClient(addr)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	jobs := []*models.Job{
+		{ID: "batch-low", Command: "echo", Priority: 1},
+		{ID: "batch-high", Command: "echo", Priority: 10},
+		{ID: "batch-mid", Command: "echo", Priority: 5},
+	}
+	if err := client.PushBatch(jobs); err != nil {
+		t.Fatalf("Failed to push batch: %v", err)
+	}
+
+	consumer, err := NewNetQueueClient(addr)
+	if err != nil {
+		t.Fatalf("Failed to create consumer: %v", err)
+	}
+	defer consumer.Close()
+	consumer.SetAutoAck(false)
+	consumer.SetPrefetch(len(jobs))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	received := make(chan string, len(jobs))
+	if err := consumer.StartConsumer(ctx, func(j *models.Job) { received <- j.ID }); err != nil {
+		t.Fatalf("Failed to start consumer: %v", err)
+	}
+
+	// The whole batch is reserved by one POP, in priority order
+	expected := []string{"batch-high", "batch-mid", "batch-low"}
+	for _, want := range expected {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("Expected %s, got %s", want, got)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for %s", want)
+		}
+	}
+
+	server.mu.Lock()
+	reserved := len(server.reserved)
+	server.mu.Unlock()
+	if reserved != len(jobs) {
+		t.Errorf("Expected %d unacked reservations, got %d", len(jobs), reserved)
+	}
+
+	for _, id := range expected {
+		if err := consumer.Ack(id); err != nil {
+			t.Errorf("Failed to ack %s: %v", id, err)
+		}
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.reserved) != 0 {
+		t.Errorf("Expected no reservations after ack, got %d", len(server.reserved))
+	}
+}
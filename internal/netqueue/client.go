@@ -3,6 +3,7 @@ package netqueue
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"job-executor/internal/models"
 	"log/slog"
@@ -11,10 +12,24 @@ import (
 	"time"
 )
 
+// DefaultLease is the reservation lease requested for popped jobs. In-flight jobs are
+// touched well before it runs out, so it only matters when the consumer dies.
+const DefaultLease = 60 * time.Second
+
+// PopWait is how long a consumer's POP blocks on the server while the queue is empty
+const PopWait = 20 * time.Second
+
 type NetQueueClient struct {
 	addr string
 	mu   sync.Mutex
 	conn net.Conn
+
+	lease    time.Duration
+	autoAck  bool
+	prefetch int                 // how many unacked jobs a consumer may hold at once
+	inflight map[string]struct{} // popped jobs whose leases we keep alive until acked
+	flightMu sync.Mutex
+	freed    chan struct{} // signalled when an in-flight job is settled
 }
 
 func NewNetQueueClient(addr string) (*NetQueueClient, error) {
@@ -22,7 +37,49 @@ func NewNetQueueClient(addr string) (*NetQueueClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &NetQueueClient{addr: addr, conn: conn}, nil
+	return &NetQueueClient{
+		addr:     addr,
+		conn:     conn,
+		lease:    DefaultLease,
+		autoAck:  true,
+		prefetch: 1,
+		inflight: make(map[string]struct{}),
+		freed:    make(chan struct{}, 1),
+	}, nil
+}
+
+// SetAutoAck controls whether StartConsumer acks a job as soon as the handler returns.
+// Consumers that hand jobs off asynchronously disable it and call Ack themselves.
+func (c *NetQueueClient) SetAutoAck(enabled bool) {
+	c.autoAck = enabled
+}
+
+// SetLease sets the reservation lease requested when popping jobs
+func (c *NetQueueClient) SetLease(lease time.Duration) {
+	if lease < time.Second {
+		lease = time.Second
+	}
+	c.lease = lease
+}
+
+// SetPrefetch sets how many unacked jobs a consumer keeps reserved at once. With more
+// than one, jobs are popped in batches and the consumer tops up as they are acked.
+func (c *NetQueueClient) SetPrefetch(n int) {
+	if n < 1 {
+		n = 1
+	}
+	c.prefetch = n
+}
+
+// roundTrip sends a single command and decodes the reply into resp
+func (c *NetQueueClient) roundTrip(cmd string, data interface{}, resp interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	req := map[string]interface{}{"cmd": cmd, "data": data}
+	if err := json.NewEncoder(c.conn).Encode(req); err != nil {
+		return err
+	}
+	return json.NewDecoder(c.conn).Decode(resp)
 }
 
 func (c *NetQueueClient) Push(job *models.Job) error {
@@ -46,48 +103,108 @@ func (c *NetQueueClient) Push(job *models.Job) error {
 	return nil
 }
 
+// PushBatch queues several jobs in a single round trip. The server accepts or rejects
+// the batch as a whole.
+func (c *NetQueueClient) PushBatch(jobs []*models.Job) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(jobs)
+	if err != nil {
+		return err
+	}
+	var resp map[string]interface{}
+	if err := c.roundTrip("PUSH_BATCH", json.RawMessage(b), &resp); err != nil {
+		return err
+	}
+	if resp["status"] != "ok" {
+		return fmt.Errorf("push batch failed: %v", resp["error"])
+	}
+	return nil
+}
+
+// StartConsumer pops jobs on a dedicated connection and hands each one to handler.
+// POPs block on the server while the queue is empty, so new jobs are picked up as
+// soon as they are pushed. Up to the prefetch count of jobs is reserved per POP.
 func (c *NetQueueClient) StartConsumer(ctx context.Context, handler func(*models.Job)) error {
+	sc, err := dialStream(c.addr)
+	if err != nil {
+		return fmt.Errorf("failed to open consumer connection: %w", err)
+	}
+	sc.closeOnDone(ctx)
+
+	go c.heartbeatLoop(ctx)
 	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				c.mu.Lock()
-				req := map[string]interface{}{"cmd": "POP", "data": json.RawMessage([]byte("{}"))}
-				if err := json.NewEncoder(c.conn).Encode(req); err != nil {
-					slog.Error("POP encode error", "error", err)
-					c.mu.Unlock()
-					time.Sleep(time.Second)
-					continue
+		defer sc.Close()
+		for ctx.Err() == nil {
+			// Only ask for as many jobs as we have room for
+			want := c.prefetch - c.inflightCount()
+			if want <= 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-c.freed:
+				}
+				continue
+			}
+
+			var resp struct {
+				Status string `json:"status"`
+				Data   struct {
+					Jobs []struct {
+						Payload  json.RawMessage `json:"payload"`
+						ID       string          `json:"id"`
+						Attempts int             `json:"attempts"`
+					} `json:"jobs"`
+				} `json:"data"`
+				Error string `json:"error"`
+			}
+			err := sc.send("POP", map[string]int{
+				"lease": int(c.lease / time.Second),
+				"wait":  int(PopWait / time.Second),
+				"max":   want,
+			})
+			if err == nil {
+				err = sc.receive(&resp)
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return
 				}
-				var resp struct {
-					Status string `json:"status"`
-					Data   struct {
-						Payload json.RawMessage `json:"payload"`
-						ID      string          `json:"id"`
-					} `json:"data"`
-					Error string `json:"error"`
+				slog.Error("POP failed, reconnecting", "error", err)
+				if sc.reconnect(ctx) != nil {
+					return
 				}
-				if err := json.NewDecoder(c.conn).Decode(&resp); err != nil {
-					slog.Error("POP decode error", "error", err)
-					c.mu.Unlock()
-					time.Sleep(time.Second)
-					continue
+				continue
+			}
+
+			switch resp.Status {
+			case "ok":
+				// Track the whole batch first so its leases are kept alive while we work through it
+				for _, d := range resp.Data.Jobs {
+					c.trackInflight(d.ID)
 				}
-				c.mu.Unlock()
-				if resp.Status == "ok" {
+				for _, d := range resp.Data.Jobs {
 					var job models.Job
-					if err := json.Unmarshal(resp.Data.Payload, &job); err != nil {
-						slog.Error("Failed to unmarshal job payload", "error", err)
+					if err := json.Unmarshal(d.Payload, &job); err != nil {
+						slog.Error("Failed to unmarshal job payload", "job_id", d.ID, "error", err)
+						c.Ack(d.ID) // it will never decode, don't let it come back
 						continue
 					}
+					if d.Attempts > 0 {
+						slog.Warn("Job redelivered after lease expiry", "job_id", job.ID, "attempts", d.Attempts)
+					}
 					handler(&job)
-					// ACK after processing
-					c.Ack(job.ID)
-				} else if resp.Status == "empty" {
-					time.Sleep(time.Second)
+					if c.autoAck {
+						// ACK after processing
+						c.Ack(job.ID)
+					}
 				}
+			case "empty":
+				// The long poll timed out with nothing to do, just ask again
+			default:
+				slog.Error("POP rejected", "error", resp.Error)
+				time.Sleep(time.Second)
 			}
 		}
 	}()
@@ -102,22 +219,164 @@ type netqueueJob struct {
 }
 
 func (c *NetQueueClient) Ack(jobID string) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	ack := map[string]interface{}{"cmd": "ACK", "data": map[string]string{"id": jobID}}
-	if err := json.NewEncoder(c.conn).Encode(ack); err != nil {
+	c.untrackInflight(jobID)
+	var resp map[string]interface{}
+	if err := c.roundTrip("ACK", map[string]string{"id": jobID}, &resp); err != nil {
 		return err
 	}
+	if resp["status"] != "ok" {
+		return fmt.Errorf("ack failed: %v", resp["error"])
+	}
+	return nil
+}
+
+// Nack hands a reserved job back to the queue without counting it as an attempt
+func (c *NetQueueClient) Nack(jobID string) error {
+	c.untrackInflight(jobID)
 	var resp map[string]interface{}
-	if err := json.NewDecoder(c.conn).Decode(&resp); err != nil {
+	if err := c.roundTrip("NACK", map[string]string{"id": jobID}, &resp); err != nil {
 		return err
 	}
 	if resp["status"] != "ok" {
-		return fmt.Errorf("ack failed: %v", resp["error"])
+		return fmt.Errorf("nack failed: %v", resp["error"])
 	}
 	return nil
 }
 
+// Retry hands a reserved job back to the queue once delay has passed
+func (c *NetQueueClient) Retry(jobID string, delay time.Duration) error {
+	c.untrackInflight(jobID)
+	seconds := int(delay / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	var resp map[string]interface{}
+	if err := c.roundTrip("NACK", map[string]interface{}{"id": jobID, "delay": seconds}, &resp); err != nil {
+		return err
+	}
+	if resp["status"] != "ok" {
+		return fmt.Errorf("retry failed: %v", resp["error"])
+	}
+	return nil
+}
+
+// DeadLetter moves a reserved job to the server's dead-letter list
+func (c *NetQueueClient) DeadLetter(jobID, reason string) error {
+	c.untrackInflight(jobID)
+	var resp map[string]interface{}
+	if err := c.roundTrip("DEAD_LETTER", map[string]string{"id": jobID, "reason": reason}, &resp); err != nil {
+		return err
+	}
+	if resp["status"] != "ok" {
+		return fmt.Errorf("dead letter failed: %v", resp["error"])
+	}
+	return nil
+}
+
+// DeadLetters lists the dead-lettered jobs, oldest first
+func (c *NetQueueClient) DeadLetters() ([]DeadLetter, error) {
+	var resp struct {
+		Status string `json:"status"`
+		Data   struct {
+			DeadLetters []DeadLetter `json:"dead_letters"`
+		} `json:"data"`
+		Error string `json:"error"`
+	}
+	if err := c.roundTrip("DLQ_LIST", nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != "ok" {
+		return nil, fmt.Errorf("list dead letters failed: %s", resp.Error)
+	}
+	return resp.Data.DeadLetters, nil
+}
+
+// ReplayDeadLetter puts a dead-lettered job back into the queue
+func (c *NetQueueClient) ReplayDeadLetter(jobID string) error {
+	var resp map[string]interface{}
+	if err := c.roundTrip("DLQ_REPLAY", map[string]string{"id": jobID}, &resp); err != nil {
+		return err
+	}
+	if resp["status"] != "ok" {
+		return fmt.Errorf("replay failed: %v", resp["error"])
+	}
+	return nil
+}
+
+// DeleteDeadLetter drops a dead-lettered job
+func (c *NetQueueClient) DeleteDeadLetter(jobID string) error {
+	var resp map[string]interface{}
+	if err := c.roundTrip("DLQ_DELETE", map[string]string{"id": jobID}, &resp); err != nil {
+		return err
+	}
+	if resp["status"] != "ok" {
+		return fmt.Errorf("delete dead letter failed: %v", resp["error"])
+	}
+	return nil
+}
+
+// Touch extends the lease on a reserved job
+func (c *NetQueueClient) Touch(jobID string) error {
+	var resp map[string]interface{}
+	data := map[string]interface{}{"id": jobID, "lease": int(c.lease / time.Second)}
+	if err := c.roundTrip("TOUCH", data, &resp); err != nil {
+		return err
+	}
+	if resp["status"] != "ok" {
+		return fmt.Errorf("touch failed: %v", resp["error"])
+	}
+	return nil
+}
+
+func (c *NetQueueClient) trackInflight(jobID string) {
+	c.flightMu.Lock()
+	c.inflight[jobID] = struct{}{}
+	c.flightMu.Unlock()
+}
+
+func (c *NetQueueClient) untrackInflight(jobID string) {
+	c.flightMu.Lock()
+	delete(c.inflight, jobID)
+	c.flightMu.Unlock()
+	select {
+	case c.freed <- struct{}{}:
+	default:
+	}
+}
+
+func (c *NetQueueClient) inflightCount() int {
+	c.flightMu.Lock()
+	defer c.flightMu.Unlock()
+	return len(c.inflight)
+}
+
+// heartbeatLoop keeps the leases of unacked jobs alive while this consumer is running
+func (c *NetQueueClient) heartbeatLoop(ctx context.Context) {
+	ticker := time.NewTicker(c.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.flightMu.Lock()
+			ids := make([]string, 0, len(c.inflight))
+			for id := range c.inflight {
+				ids = append(ids, id)
+			}
+			c.flightMu.Unlock()
+
+			for _, id := range ids {
+				if err := c.Touch(id); err != nil {
+					// The reservation is gone (canceled, or the lease already lapsed)
+					slog.Warn("Failed to extend job lease", "job_id", id, "error", err)
+					c.untrackInflight(id)
+				}
+			}
+		}
+	}
+}
+
 func (c *NetQueueClient) PublishCancelMessage(jobID string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -135,18 +394,160 @@ func (c *NetQueueClient) PublishCancelMessage(jobID string) error {
 	return nil
 }
 
+// StartCancelConsumer subscribes to cancel broadcasts and calls handler with each job ID
 func (c *NetQueueClient) StartCancelConsumer(ctx context.Context, handler func(string)) error {
-	// Not implemented for simple TCP client
+	return c.subscribe(ctx, TopicCancel, func(payload json.RawMessage) {
+		var msg struct {
+			JobID string `json:"job_id"`
+		}
+		if err := json.Unmarshal(payload, &msg); err != nil || msg.JobID == "" {
+			slog.Error("Invalid cancel event", "error", err)
+			return
+		}
+		handler(msg.JobID)
+	})
+}
+
+// subscribe opens a dedicated connection subscribed to topic and passes each event
+// payload to handler until ctx is done, resubscribing if the connection drops
+func (c *NetQueueClient) subscribe(ctx context.Context, topic string, handler func(json.RawMessage)) error {
+	sc, err := dialStream(c.addr)
+	if err != nil {
+		return fmt.Errorf("failed to open subscription connection: %w", err)
+	}
+	if err := sc.subscribe(topic); err != nil {
+		sc.Close()
+		return fmt.Errorf("failed to subscribe to %s: %w", topic, err)
+	}
+	sc.closeOnDone(ctx)
+
+	go func() {
+		defer sc.Close()
+		for ctx.Err() == nil {
+			var msg struct {
+				Status string `json:"status"`
+				Data   struct {
+					Topic   string          `json:"topic"`
+					Payload json.RawMessage `json:"payload"`
+				} `json:"data"`
+			}
+			if err := sc.receive(&msg); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Error("Subscription dropped, reconnecting", "topic", topic, "error", err)
+				for {
+					if sc.reconnect(ctx) != nil {
+						return
+					}
+					if err := sc.subscribe(topic); err == nil {
+						break
+					} else {
+						slog.Error("Resubscribe failed", "topic", topic, "error", err)
+					}
+				}
+				continue
+			}
+			if msg.Status == "event" {
+				handler(msg.Data.Payload)
+			}
+		}
+	}()
 	return nil
 }
 
+// PublishOutputEvent publishes a chunk of a job's live output to the job's output topic.
+// The server retains recent chunks so viewers that attach late still see them.
 func (c *NetQueueClient) PublishOutputEvent(jobID, output string, isStderr bool, lineCount int) error {
-	// Not implemented for simple TCP client
+	data := map[string]interface{}{
+		"topic": OutputTopic(jobID),
+		"payload": map[string]interface{}{
+			"job_id":     jobID,
+			"output":     output,
+			"is_stderr":  isStderr,
+			"line_count": lineCount,
+			"timestamp":  time.Now().UTC().Format(time.RFC3339Nano),
+		},
+		"retain": true,
+	}
+	var resp map[string]interface{}
+	if err := c.roundTrip("PUBLISH", data, &resp); err != nil {
+		return err
+	}
+	if resp["status"] != "ok" {
+		return fmt.Errorf("publish failed: %v", resp["error"])
+	}
 	return nil
 }
 
-func (c *NetQueueClient) StartOutputConsumer(ctx context.Context, jobID string, handler func(interface{})) error {
-	// Not implemented for simple TCP client
+// StartOutputConsumer passes each output event published for jobID to handler until ctx
+// is done. Retained events are replayed first, and again after a reconnect, so handlers
+// should skip line counts they have already seen.
+func (c *NetQueueClient) StartOutputConsumer(ctx context.Context, jobID string, handler func(json.RawMessage)) error {
+	return c.subscribe(ctx, OutputTopic(jobID), handler)
+}
+
+// ErrNoSubscriber is returned when a request finds nobody subscribed to handle it
+var ErrNoSubscriber = errors.New("no subscriber to handle the request")
+
+// RequestServerCheck hands a server check to one of the subscribed workers and waits
+// for the result it publishes. The result topic is subscribed to before the check is
+// sent, so the result can't be missed.
+func (c *NetQueueClient) RequestServerCheck(ctx context.Context, checkID string, check interface{}) (json.RawMessage, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan json.RawMessage, 1)
+	err := c.subscribe(ctx, CheckResultTopic(checkID), func(payload json.RawMessage) {
+		select {
+		case results <- payload:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Status string `json:"status"`
+		Data   struct {
+			Delivered int `json:"delivered"`
+		} `json:"data"`
+		Error string `json:"error"`
+	}
+	data := map[string]interface{}{"topic": TopicServerChecks, "payload": check, "one": true}
+	if err := c.roundTrip("PUBLISH", data, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != "ok" {
+		return nil, fmt.Errorf("publish failed: %s", resp.Error)
+	}
+	if resp.Data.Delivered == 0 {
+		return nil, ErrNoSubscriber
+	}
+
+	select {
+	case result := <-results:
+		return result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// StartServerCheckConsumer passes each server check handed to this client to handler
+// until ctx is done
+func (c *NetQueueClient) StartServerCheckConsumer(ctx context.Context, handler func(json.RawMessage)) error {
+	return c.subscribe(ctx, TopicServerChecks, handler)
+}
+
+// PublishServerCheckResult publishes the result of a server check to whoever requested it
+func (c *NetQueueClient) PublishServerCheckResult(checkID string, result interface{}) error {
+	var resp map[string]interface{}
+	if err := c.roundTrip("PUBLISH", map[string]interface{}{"topic": CheckResultTopic(checkID), "payload": result}, &resp); err != nil {
+		return err
+	}
+	if resp["status"] != "ok" {
+		return fmt.Errorf("publish failed: %v", resp["error"])
+	}
 	return nil
 }
 
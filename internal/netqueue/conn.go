@@ -0,0 +1,111 @@
+package netqueue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// reconnectDelay is how long a dedicated connection waits before redialing
+const reconnectDelay = time.Second
+
+// streamConn is a dedicated connection for long-running commands such as blocking POPs,
+// so they don't hold up the request/response traffic on the client's main connection.
+type streamConn struct {
+	addr string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	enc    *json.Encoder
+	dec    *json.Decoder
+	closed bool
+}
+
+func dialStream(addr string) (*streamConn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &streamConn{addr: addr, conn: conn, enc: json.NewEncoder(conn), dec: json.NewDecoder(conn)}, nil
+}
+
+// closeOnDone closes the connection once ctx is done, unblocking any pending read
+func (sc *streamConn) closeOnDone(ctx context.Context) {
+	context.AfterFunc(ctx, func() { sc.Close() })
+}
+
+// send writes a command to the connection
+func (sc *streamConn) send(cmd string, data interface{}) error {
+	sc.mu.Lock()
+	enc := sc.enc
+	sc.mu.Unlock()
+	return enc.Encode(map[string]interface{}{"cmd": cmd, "data": data})
+}
+
+// receive reads the next message from the connection
+func (sc *streamConn) receive(v interface{}) error {
+	sc.mu.Lock()
+	dec := sc.dec
+	sc.mu.Unlock()
+	return dec.Decode(v)
+}
+
+// subscribe turns the connection into an event stream for topic
+func (sc *streamConn) subscribe(topic string) error {
+	if err := sc.send("SUBSCRIBE", map[string]string{"topic": topic}); err != nil {
+		return err
+	}
+	var resp struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	if err := sc.receive(&resp); err != nil {
+		return err
+	}
+	if resp.Status != "ok" {
+		return fmt.Errorf("subscribe rejected: %s", resp.Error)
+	}
+	return nil
+}
+
+// reconnect redials the server until it succeeds or ctx is done
+func (sc *streamConn) reconnect(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(reconnectDelay):
+		}
+
+		conn, err := net.Dial("tcp", sc.addr)
+		if err != nil {
+			slog.Warn("NetQueue reconnect failed", "addr", sc.addr, "error", err)
+			continue
+		}
+
+		sc.mu.Lock()
+		if sc.closed {
+			sc.mu.Unlock()
+			conn.Close()
+			return ctx.Err()
+		}
+		sc.conn.Close()
+		sc.conn = conn
+		sc.enc = json.NewEncoder(conn)
+		sc.dec = json.NewDecoder(conn)
+		sc.mu.Unlock()
+		slog.Info("NetQueue connection re-established", "addr", sc.addr)
+		return nil
+	}
+}
+
+func (sc *streamConn) Close() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.closed = true
+	return sc.conn.Close()
+}
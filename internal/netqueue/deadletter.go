@@ -0,0 +1,91 @@
+package netqueue
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DeadLetter describes a job that was given up on and parked for inspection
+type DeadLetter struct {
+	ID       string    `json:"id"`
+	Command  string    `json:"command"`
+	Reason   string    `json:"reason"`
+	Attempts int       `json:"attempts"`
+	DeadAt   time.Time `json:"dead_at"`
+}
+
+// deadLetter moves a reserved job to the dead-letter list
+func (s *NetQueueServer) deadLetter(id, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.reserved[id]
+	if !ok {
+		return fmt.Errorf("job %s is not reserved", id)
+	}
+	now := time.Now().UTC()
+	if err := s.journalLocked(journalEntry{Op: opDeadLetter, ID: id, Reason: reason, At: now}); err != nil {
+		return err
+	}
+	delete(s.reserved, id)
+	delete(s.leases, id)
+	job.Reason = reason
+	job.DeadAt = now
+	s.dead[id] = job
+	return nil
+}
+
+// deadLetters lists the dead-lettered jobs, oldest first
+func (s *NetQueueServer) deadLetters() []DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	letters := make([]DeadLetter, 0, len(s.dead))
+	for _, job := range s.dead {
+		letters = append(letters, DeadLetter{
+			ID:       job.ID,
+			Command:  job.Command,
+			Reason:   job.Reason,
+			Attempts: job.Attempts,
+			DeadAt:   job.DeadAt,
+		})
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].DeadAt.Before(letters[j].DeadAt) })
+	return letters
+}
+
+// replayDeadLetter puts a dead-lettered job back into the queue with a clean slate
+func (s *NetQueueServer) replayDeadLetter(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.dead[id]
+	if !ok {
+		return fmt.Errorf("job %s is not dead-lettered", id)
+	}
+	if err := s.journalLocked(journalEntry{Op: opReplay, ID: id}); err != nil {
+		return err
+	}
+	delete(s.dead, id)
+	job.Attempts = 0
+	job.Reason = ""
+	job.DeadAt = time.Time{}
+	heap.Push(&s.jobs, job)
+	s.pubsub.clearRetained(OutputTopic(id))
+	s.notifyLocked()
+	return nil
+}
+
+// deleteDeadLetter drops a dead-lettered job for good
+func (s *NetQueueServer) deleteDeadLetter(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.dead[id]; !ok {
+		return fmt.Errorf("job %s is not dead-lettered", id)
+	}
+	// Journaled as a cancel, which removes the job wherever it is
+	if err := s.journalLocked(journalEntry{Op: opCancel, ID: id}); err != nil {
+		return err
+	}
+	delete(s.dead, id)
+	return nil
+}
@@ -0,0 +1,115 @@
+package netqueue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNetQueue_DelayAndDeadLetter(t *testing.T) {
+	dir := t.TempDir()
+
+	server, err := NewPersistentNetQueueServer(dir)
+	if err != nil {
+		t.Fatalf("Failed to create persistent server: %v", err)
+	}
+
+	now := time.Now().UTC()
+	for i, id := range []string{"job-retry", "job-dead"} {
+		if err := server.push(&Job{ID: id, Command: "false", Priority: 5, Created: now.Add(time.Duration(i) * time.Millisecond)}); err != nil {
+			t.Fatalf("Failed to push %s: %v", id, err)
+		}
+	}
+	jobs, err := server.popBatch(defaultLeaseDuration, 2)
+	if err != nil || len(jobs) != 2 {
+		t.Fatalf("Failed to pop both jobs: %v", err)
+	}
+
+	server.pubsub.publishRetained(OutputTopic("job-retry"), "attempt 1 output")
+	if _, err := server.nackDelayed("job-retry", time.Minute); err != nil {
+		t.Fatalf("Failed to delay job: %v", err)
+	}
+	// The failed attempt's output isn't replayed to viewers of the next one
+	if _, replay := server.pubsub.subscribe(OutputTopic("job-retry")); len(replay) != 0 {
+		t.Fatalf("Expected no output replayed after a retry, got %d events", len(replay))
+	}
+	if err := server.deadLetter("job-dead", "failed after 3 attempts"); err != nil {
+		t.Fatalf("Failed to dead-letter job: %v", err)
+	}
+
+	// Nothing is ready yet
+	if job, _ := server.pop(defaultLeaseDuration); job != nil {
+		t.Fatalf("Expected an empty queue, popped %s", job.ID)
+	}
+
+	// Both sets survive a crash
+	server.journal.close()
+	restored, err := NewPersistentNetQueueServer(dir)
+	if err != nil {
+		t.Fatalf("Failed to restore server: %v", err)
+	}
+	defer restored.Close()
+
+	letters := restored.deadLetters()
+	if len(letters) != 1 || letters[0].ID != "job-dead" || letters[0].Reason != "failed after 3 attempts" {
+		t.Fatalf("Expected job-dead on the dead-letter list, got %+v", letters)
+	}
+	if _, ok := restored.delayed["job-retry"]; !ok {
+		t.Fatalf("Expected job-retry to still be delayed")
+	}
+
+	if n := restored.promoteDelayed(time.Now().Add(2 * time.Minute)); n != 1 {
+		t.Fatalf("Expected 1 delayed job promoted, got %d", n)
+	}
+	if job, _ := restored.pop(defaultLeaseDuration); job == nil || job.ID != "job-retry" {
+		t.Fatalf("Expected job-retry after its delay, got %v", job)
+	}
+
+	if err := restored.replayDeadLetter("job-dead"); err != nil {
+		t.Fatalf("Failed to replay dead letter: %v", err)
+	}
+	if len(restored.deadLetters()) != 0 {
+		t.Errorf("Expected an empty dead-letter list after replay")
+	}
+	if job, _ := restored.pop(defaultLeaseDuration); job == nil || job.ID != "job-dead" {
+		t.Fatalf("Expected the replayed job back in the queue, got %v", job)
+	}
+}
+
+func TestNetQueue_CancelDeadLetter(t *testing.T) {
+	dir := t.TempDir()
+
+	server, err := NewPersistentNetQueueServer(dir)
+	if err != nil {
+		t.Fatalf("Failed to create persistent server: %v", err)
+	}
+	if err := server.push(&Job{ID: "job-dead", Command: "false", Priority: 5, Created: time.Now().UTC()}); err != nil {
+		t.Fatalf("Failed to push job: %v", err)
+	}
+	if job, err := server.pop(defaultLeaseDuration); err != nil || job == nil {
+		t.Fatalf("Failed to pop job: %v", err)
+	}
+	if err := server.deadLetter("job-dead", "failed after 3 attempts"); err != nil {
+		t.Fatalf("Failed to dead-letter job: %v", err)
+	}
+
+	// Canceling takes it off the list right away, the same as after a restart
+	if _, err := server.cancel("job-dead"); err != nil {
+		t.Fatalf("Failed to cancel job: %v", err)
+	}
+	if letters := server.deadLetters(); len(letters) != 0 {
+		t.Fatalf("Expected the canceled job off the dead-letter list, got %+v", letters)
+	}
+	if err := server.replayDeadLetter("job-dead"); err == nil {
+		t.Errorf("Expected a canceled dead letter not to be replayable")
+	}
+
+	server.journal.close()
+	restored, err := NewPersistentNetQueueServer(dir)
+	if err != nil {
+		t.Fatalf("Failed to restore server: %v", err)
+	}
+	defer restored.Close()
+	if letters := restored.deadLetters(); len(letters) != 0 {
+		t.Errorf("Expected no dead letters after a restart, got %+v", letters)
+	}
+}
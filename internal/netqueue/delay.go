@@ -0,0 +1,60 @@
+package netqueue
+
+import (
+	"container/heap"
+	"fmt"
+	"time"
+)
+
+// maxDelay caps how long a NACK may hold a job back
+const maxDelay = 24 * time.Hour
+
+// delayFromSeconds converts a delay requested over the wire
+func delayFromSeconds(seconds int) time.Duration {
+	delay := time.Duration(seconds) * time.Second
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// nackDelayed takes a reserved job back and holds it out of the queue until the delay
+// has passed, e.g. while a failed job backs off before its next attempt
+func (s *NetQueueServer) nackDelayed(id string, delay time.Duration) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.reserved[id]
+	if !ok {
+		return time.Time{}, fmt.Errorf("job %s is not reserved", id)
+	}
+	readyAt := time.Now().UTC().Add(delay)
+	if err := s.journalLocked(journalEntry{Op: opDelay, ID: id, ReadyAt: &readyAt}); err != nil {
+		return time.Time{}, err
+	}
+	delete(s.reserved, id)
+	delete(s.leases, id)
+	job.ReadyAt = readyAt
+	s.delayed[id] = job
+	// Late viewers of the next attempt shouldn't get this one's output replayed
+	s.pubsub.clearRetained(OutputTopic(id))
+	return readyAt, nil
+}
+
+// promoteDelayed moves delayed jobs whose time has come into the queue, both scheduled
+// jobs and failed ones backing off before a retry. Promotion isn't
+// journaled; after a restart an overdue delayed job is simply promoted on the next sweep.
+func (s *NetQueueServer) promoteDelayed(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	promoted := 0
+	for id, job := range s.delayed {
+		if now.Before(job.ReadyAt) {
+			continue
+		}
+		delete(s.delayed, id)
+		heap.Push(&s.jobs, job)
+		s.notifyLocked()
+		promoted++
+	}
+	return promoted
+}
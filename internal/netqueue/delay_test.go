@@ -0,0 +1,48 @@
+package netqueue
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNetQueue_ScheduledPush(t *testing.T) {
+	dir := t.TempDir()
+
+	server, err := NewPersistentNetQueueServer(dir)
+	if err != nil {
+		t.Fatalf("Failed to create persistent server: %v", err)
+	}
+
+	runAt := time.Now().Add(10 * time.Minute).UTC()
+	payload, _ := json.Marshal(map[string]interface{}{"id": "job-nightly", "command": "backup.sh", "run_at": runAt})
+	job, err := decodeJob(payload)
+	if err != nil {
+		t.Fatalf("Failed to decode job: %v", err)
+	}
+	if err := server.push(job); err != nil {
+		t.Fatalf("Failed to push job: %v", err)
+	}
+
+	if job, _ := server.pop(defaultLeaseDuration); job != nil {
+		t.Fatalf("Scheduled job popped before it was due")
+	}
+
+	// The schedule survives a crash
+	server.journal.close()
+	restored, err := NewPersistentNetQueueServer(dir)
+	if err != nil {
+		t.Fatalf("Failed to restore server: %v", err)
+	}
+	defer restored.Close()
+
+	if n := restored.promoteDelayed(time.Now()); n != 0 {
+		t.Fatalf("Expected nothing due yet, promoted %d", n)
+	}
+	if n := restored.promoteDelayed(runAt); n != 1 {
+		t.Fatalf("Expected the job to be promoted at its run time, promoted %d", n)
+	}
+	if job, _ := restored.pop(defaultLeaseDuration); job == nil || job.ID != "job-nightly" {
+		t.Fatalf("Expected job-nightly once due, got %v", job)
+	}
+}
@@ -0,0 +1,275 @@
+package netqueue
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	journalFileName  = "netqueue.wal"
+	snapshotFileName = "netqueue.snapshot"
+
+	// snapshotInterval is how often the server compacts the journal into a snapshot
+	snapshotInterval = 30 * time.Second
+	// snapshotThreshold forces a compaction once this many entries have been appended
+	snapshotThreshold = 1000
+)
+
+// Journal operations, mirroring the protocol commands that mutate queue state
+const (
+	opPush   = "PUSH"
+	opPop    = "POP"
+	opAck    = "ACK"
+	opCancel = "CANCEL"
+	// opRequeue moves a reserved job back into the queue (lease expiry or NACK)
+	opRequeue = "REQUEUE"
+	// opDelay parks a reserved job until its ready time (NACK with a delay)
+	opDelay = "DELAY"
+	// opDeadLetter moves a reserved job to the dead-letter list
+	opDeadLetter = "DEAD_LETTER"
+	// opReplay moves a dead-lettered job back into the queue
+	opReplay = "REPLAY"
+)
+
+// journalEntry is a single line of the write-ahead log
+type journalEntry struct {
+	Op  string    `json:"op"`
+	ID  string    `json:"id"`
+	Job *Job      `json:"job,omitempty"` // only set for PUSH
+	At  time.Time `json:"at"`
+
+	Attempts int        `json:"attempts,omitempty"` // attempt counter after a REQUEUE
+	ReadyAt  *time.Time `json:"ready_at,omitempty"` // only set for DELAY
+	Reason   string     `json:"reason,omitempty"`   // only set for DEAD_LETTER
+}
+
+// queueSnapshot is the compacted state of the queue at a point in time
+type queueSnapshot struct {
+	Jobs     []*Job    `json:"jobs"`
+	Reserved []*Job    `json:"reserved"`
+	Delayed  []*Job    `json:"delayed"`
+	Dead     []*Job    `json:"dead"`
+	TakenAt  time.Time `json:"taken_at"`
+}
+
+// queueState is the queue as reconstructed from the snapshot and log, keyed by job ID
+type queueState struct {
+	pending  map[string]*Job
+	reserved map[string]*Job
+	delayed  map[string]*Job
+	dead     map[string]*Job
+}
+
+// journal is an append-only on-disk log of queue operations plus a periodic snapshot.
+// Replaying the snapshot followed by the log reconstructs the queue after a restart.
+// It is not safe for concurrent use; the server only touches it while holding its mutex.
+type journal struct {
+	dir     string
+	file    *os.File
+	entries int // entries appended since the last snapshot
+}
+
+func openJournal(dir string) (*journal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, journalFileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal file: %w", err)
+	}
+
+	return &journal{dir: dir, file: file}, nil
+}
+
+// append writes entries to the log and syncs them to disk with a single fsync
+func (j *journal) append(entries ...journalEntry) error {
+	var buf []byte
+	now := time.Now().UTC()
+	for _, entry := range entries {
+		if entry.At.IsZero() {
+			entry.At = now
+		}
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode journal entry: %w", err)
+		}
+		buf = append(buf, b...)
+		buf = append(buf, '\n')
+	}
+
+	if _, err := j.file.Write(buf); err != nil {
+		return fmt.Errorf("failed to write journal entry: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	j.entries += len(entries)
+	return nil
+}
+
+// load restores the queue state from the snapshot and replays the log on top of it.
+// Jobs that were reserved but never acknowledged come back in the reserved set.
+func (j *journal) load() (*queueState, error) {
+	state := &queueState{
+		pending:  make(map[string]*Job),
+		reserved: make(map[string]*Job),
+		delayed:  make(map[string]*Job),
+		dead:     make(map[string]*Job),
+	}
+
+	snapPath := filepath.Join(j.dir, snapshotFileName)
+	if data, err := os.ReadFile(snapPath); err == nil {
+		var snap queueSnapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+		}
+		for _, job := range snap.Jobs {
+			state.pending[job.ID] = job
+		}
+		for _, job := range snap.Reserved {
+			state.reserved[job.ID] = job
+		}
+		for _, job := range snap.Delayed {
+			state.delayed[job.ID] = job
+		}
+		for _, job := range snap.Dead {
+			state.dead[job.ID] = job
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	file, err := os.Open(filepath.Join(j.dir, journalFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal for replay: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024) // payloads may carry large scripts
+	replayed := 0
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A torn write at the tail of the log means the server died mid-append;
+			// everything before it is intact, so stop here.
+			slog.Warn("Stopping journal replay at corrupt entry", "entry", replayed+1, "error", err)
+			break
+		}
+
+		switch entry.Op {
+		case opPush:
+			// Scheduled jobs start out delayed; overdue ones are promoted on the first sweep
+			if entry.Job != nil && !entry.Job.ReadyAt.IsZero() {
+				state.delayed[entry.Job.ID] = entry.Job
+			} else if entry.Job != nil {
+				state.pending[entry.Job.ID] = entry.Job
+			}
+		case opPop:
+			// Delayed jobs are promoted into the queue without a log entry, so a POP
+			// may take a job that is still delayed as far as the log is concerned
+			if job, ok := state.pending[entry.ID]; ok {
+				delete(state.pending, entry.ID)
+				state.reserved[entry.ID] = job
+			} else if job, ok := state.delayed[entry.ID]; ok {
+				delete(state.delayed, entry.ID)
+				state.reserved[entry.ID] = job
+			}
+		case opAck:
+			delete(state.reserved, entry.ID)
+		case opCancel:
+			delete(state.pending, entry.ID)
+			delete(state.reserved, entry.ID)
+			delete(state.delayed, entry.ID)
+			delete(state.dead, entry.ID)
+		case opRequeue:
+			if job, ok := state.reserved[entry.ID]; ok {
+				delete(state.reserved, entry.ID)
+				job.Attempts = entry.Attempts
+				state.pending[entry.ID] = job
+			}
+		case opDelay:
+			if job, ok := state.reserved[entry.ID]; ok && entry.ReadyAt != nil {
+				delete(state.reserved, entry.ID)
+				job.ReadyAt = *entry.ReadyAt
+				state.delayed[entry.ID] = job
+			}
+		case opDeadLetter:
+			if job, ok := state.reserved[entry.ID]; ok {
+				delete(state.reserved, entry.ID)
+				job.Reason = entry.Reason
+				job.DeadAt = entry.At
+				state.dead[entry.ID] = job
+			}
+		case opReplay:
+			if job, ok := state.dead[entry.ID]; ok {
+				delete(state.dead, entry.ID)
+				job.Attempts = 0
+				job.Reason = ""
+				state.pending[entry.ID] = job
+			}
+		}
+		replayed++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to replay journal: %w", err)
+	}
+
+	slog.Info("Journal replayed",
+		"dir", j.dir,
+		"entries", replayed,
+		"queued", len(state.pending),
+		"reserved", len(state.reserved),
+		"delayed", len(state.delayed),
+		"dead", len(state.dead))
+	return state, nil
+}
+
+// compact atomically writes a snapshot of the given state and truncates the log
+func (j *journal) compact(snap queueSnapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	snapPath := filepath.Join(j.dir, snapshotFileName)
+	tmpPath := snapPath + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close snapshot: %w", err)
+	}
+	if err := os.Rename(tmpPath, snapPath); err != nil {
+		return fmt.Errorf("failed to install snapshot: %w", err)
+	}
+
+	// The snapshot now covers every logged entry, so the log can start over
+	if err := j.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	j.entries = 0
+	return nil
+}
+
+func (j *journal) close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,84 @@
+package netqueue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNetQueue_JournalReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	server, err := NewPersistentNetQueueServer(dir)
+	if err != nil {
+		t.Fatalf("Failed to create persistent server: %v", err)
+	}
+
+	now := time.Now().UTC()
+	jobs := []*Job{
+		{ID: "job-acked", Command: "echo", Priority: 5, Created: now},
+		{ID: "job-reserved", Command: "echo", Priority: 9, Created: now.Add(time.Millisecond)},
+		{ID: "job-canceled", Command: "echo", Priority: 5, Created: now.Add(2 * time.Millisecond)},
+		{ID: "job-queued", Command: "echo", Priority: 1, Created: now.Add(3 * time.Millisecond), Payload: []byte(`{"id":"job-queued"}`)},
+	}
+	for _, job := range jobs {
+		if err := server.push(job); err != nil {
+			t.Fatalf("Failed to push job %s: %v", job.ID, err)
+		}
+	}
+
+	// job-reserved has the highest priority, job-acked is next in FIFO order
+	for _, want := range []string{"job-reserved", "job-acked"} {
+		job, err := server.pop(defaultLeaseDuration)
+		if err != nil || job == nil {
+			t.Fatalf("Failed to pop %s: %v", want, err)
+		}
+		if job.ID != want {
+			t.Fatalf("Expected to pop %s, got %s", want, job.ID)
+		}
+	}
+	if err := server.ack("job-acked"); err != nil {
+		t.Fatalf("Failed to ack: %v", err)
+	}
+	if _, err := server.cancel("job-canceled"); err != nil {
+		t.Fatalf("Failed to cancel: %v", err)
+	}
+
+	// Simulate a crash: drop the server without a final snapshot
+	server.journal.close()
+
+	restored, err := NewPersistentNetQueueServer(dir)
+	if err != nil {
+		t.Fatalf("Failed to restore server: %v", err)
+	}
+	defer restored.Close()
+
+	if len(restored.reserved) != 0 {
+		t.Errorf("Expected reserved jobs to be requeued, got %d reserved", len(restored.reserved))
+	}
+
+	var order []string
+	for {
+		job, err := restored.pop(defaultLeaseDuration)
+		if err != nil {
+			t.Fatalf("Failed to pop restored job: %v", err)
+		}
+		if job == nil {
+			break
+		}
+		order = append(order, job.ID)
+		if job.ID == "job-queued" && string(job.Payload) != `{"id":"job-queued"}` {
+			t.Errorf("Payload not restored, got %q", job.Payload)
+		}
+	}
+
+	expected := []string{"job-reserved", "job-queued"}
+	if len(order) != len(expected) {
+		t.Fatalf("Expected restored jobs %v, got %v", expected, order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Errorf("Expected restored jobs %v, got %v", expected, order)
+			break
+		}
+	}
+}
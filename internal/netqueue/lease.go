@@ -0,0 +1,102 @@
+package netqueue
+
+import (
+	"container/heap"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// defaultLeaseDuration is how long a popped job stays reserved without a TOUCH
+	defaultLeaseDuration = 60 * time.Second
+	// maxLeaseDuration caps the lease a consumer may ask for
+	maxLeaseDuration = 30 * time.Minute
+	// leaseCheckInterval is how often expired reservations are swept back into the queue
+	leaseCheckInterval = time.Second
+)
+
+// leaseFromSeconds converts a lease requested over the wire, falling back to the default
+func leaseFromSeconds(seconds int) time.Duration {
+	if seconds <= 0 {
+		return defaultLeaseDuration
+	}
+	lease := time.Duration(seconds) * time.Second
+	if lease > maxLeaseDuration {
+		return maxLeaseDuration
+	}
+	return lease
+}
+
+// touch extends the lease of a reserved job and returns the new deadline
+func (s *NetQueueServer) touch(id string, lease time.Duration) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.reserved[id]; !ok {
+		return time.Time{}, fmt.Errorf("job %s is not reserved", id)
+	}
+	deadline := time.Now().Add(lease)
+	s.leases[id] = deadline
+	return deadline, nil
+}
+
+// nack hands a reserved job back to the queue straight away, e.g. when a consumer shuts
+// down with jobs it has not started. It does not count as a failed attempt.
+func (s *NetQueueServer) nack(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.reserved[id]
+	if !ok {
+		return fmt.Errorf("job %s is not reserved", id)
+	}
+	return s.requeueLocked(job)
+}
+
+// requeueLocked moves a reserved job back into the heap. The caller must hold s.mu.
+func (s *NetQueueServer) requeueLocked(job *Job) error {
+	if err := s.journalLocked(journalEntry{Op: opRequeue, ID: job.ID, Attempts: job.Attempts}); err != nil {
+		return err
+	}
+	delete(s.reserved, job.ID)
+	delete(s.leases, job.ID)
+	heap.Push(&s.jobs, job)
+	s.notifyLocked()
+	return nil
+}
+
+// expireLeases requeues every reservation whose lease has run out
+func (s *NetQueueServer) expireLeases(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired := 0
+	for id, deadline := range s.leases {
+		if now.Before(deadline) {
+			continue
+		}
+		job, ok := s.reserved[id]
+		if !ok {
+			delete(s.leases, id)
+			continue
+		}
+		job.Attempts++
+		if err := s.requeueLocked(job); err != nil {
+			// Leave the reservation in place and try again on the next sweep
+			job.Attempts--
+			slog.Error("Failed to requeue expired job", "job_id", id, "error", err)
+			continue
+		}
+		slog.Warn("Job lease expired, redelivering", "job_id", id, "attempts", job.Attempts)
+		expired++
+	}
+	return expired
+}
+
+// leaseLoop periodically sweeps expired reservations and due delayed jobs back into the queue
+func (s *NetQueueServer) leaseLoop() {
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.expireLeases(now)
+		s.promoteDelayed(now)
+	}
+}
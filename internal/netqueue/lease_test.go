@@ -0,0 +1,50 @@
+package netqueue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNetQueue_LeaseExpiryRedelivers(t *testing.T) {
+	server := NewNetQueueServer()
+	if err := server.push(&Job{ID: "job-1", Priority: 5, Created: time.Now().UTC()}); err != nil {
+		t.Fatalf("Failed to push job: %v", err)
+	}
+
+	job, err := server.pop(time.Minute)
+	if err != nil || job == nil {
+		t.Fatalf("Failed to pop job: %v", err)
+	}
+
+	// A touched lease survives past its original deadline
+	if _, err := server.touch("job-1", 2*time.Minute); err != nil {
+		t.Fatalf("Failed to touch job: %v", err)
+	}
+	if n := server.expireLeases(time.Now().Add(90 * time.Second)); n != 0 {
+		t.Fatalf("Expected touched lease to be kept, %d expired", n)
+	}
+
+	if n := server.expireLeases(time.Now().Add(3 * time.Minute)); n != 1 {
+		t.Fatalf("Expected 1 expired lease, got %d", n)
+	}
+	if _, err := server.touch("job-1", time.Minute); err == nil {
+		t.Errorf("Expected touch to fail once the lease expired")
+	}
+
+	redelivered, err := server.pop(time.Minute)
+	if err != nil || redelivered == nil {
+		t.Fatalf("Expected job to be redelivered: %v", err)
+	}
+	if redelivered.Attempts != 1 {
+		t.Errorf("Expected attempts to be 1, got %d", redelivered.Attempts)
+	}
+
+	// NACK puts the job back without counting an attempt
+	if err := server.nack("job-1"); err != nil {
+		t.Fatalf("Failed to nack job: %v", err)
+	}
+	again, _ := server.pop(time.Minute)
+	if again == nil || again.Attempts != 1 {
+		t.Errorf("Expected nacked job to keep 1 attempt, got %+v", again)
+	}
+}
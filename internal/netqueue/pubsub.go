@@ -0,0 +1,233 @@
+package netqueue
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// TopicCancel carries cancellation requests to every subscribed worker
+	TopicCancel = "cancel"
+
+	// subscriberBuffer is how many events may queue up for a slow subscriber before
+	// new ones are dropped
+	subscriberBuffer = 256
+
+	// TopicOutputPrefix prefixes the per-job topics that carry live command output
+	TopicOutputPrefix = "output:"
+
+	// TopicServerChecks carries server connection checks, each to one subscribed worker
+	TopicServerChecks = "server-checks"
+	// TopicCheckResultPrefix prefixes the per-check topics the results are published on
+	TopicCheckResultPrefix = "check-result:"
+
+	// retainLimit is how many recent events are kept per retained topic and replayed to
+	// late subscribers
+	retainLimit = 500
+	// retainIdleTTL is how long a retained topic's history is kept after its last publish
+	retainIdleTTL = 10 * time.Minute
+	// retainPruneInterval is how often idle retained topics are dropped
+	retainPruneInterval = time.Minute
+)
+
+// OutputTopic is the topic live output for a job is published on
+func OutputTopic(jobID string) string {
+	return TopicOutputPrefix + jobID
+}
+
+// CheckResultTopic is the topic the result of a server check is published on
+func CheckResultTopic(checkID string) string {
+	return TopicCheckResultPrefix + checkID
+}
+
+// event is a message pushed to a subscribed connection
+type event struct {
+	Topic   string      `json:"topic"`
+	Payload interface{} `json:"payload"`
+}
+
+// subscriber is a connection that receives events for one topic
+type subscriber struct {
+	events chan event
+}
+
+// history is the bounded backlog of a retained topic
+type history struct {
+	events   []event
+	lastSeen time.Time
+}
+
+// pubsub fans events out to the connections subscribed to each topic
+type pubsub struct {
+	mu       sync.RWMutex
+	topics   map[string]map[*subscriber]struct{}
+	retained map[string]*history
+}
+
+func newPubSub() *pubsub {
+	return &pubsub{
+		topics:   make(map[string]map[*subscriber]struct{}),
+		retained: make(map[string]*history),
+	}
+}
+
+// subscribe registers a subscriber for topic and returns the retained events published
+// before it joined. Both happen under the lock, so nothing falls between the replay and
+// the live events.
+func (p *pubsub) subscribe(topic string) (*subscriber, []event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sub := &subscriber{events: make(chan event, subscriberBuffer)}
+	if p.topics[topic] == nil {
+		p.topics[topic] = make(map[*subscriber]struct{})
+	}
+	p.topics[topic][sub] = struct{}{}
+
+	var replay []event
+	if h, ok := p.retained[topic]; ok {
+		replay = append(replay, h.events...)
+	}
+	return sub, replay
+}
+
+func (p *pubsub) unsubscribe(topic string, sub *subscriber) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if subs, ok := p.topics[topic]; ok {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(p.topics, topic)
+		}
+	}
+}
+
+// publish delivers an event to every subscriber of the topic and returns how many
+// received it. Subscribers that have fallen behind miss the event rather than
+// stalling the publisher.
+func (p *pubsub) publish(topic string, payload interface{}) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	delivered := 0
+	for sub := range p.topics[topic] {
+		select {
+		case sub.events <- event{Topic: topic, Payload: payload}:
+			delivered++
+		default:
+			slog.Warn("Subscriber buffer full, dropping event", "topic", topic)
+		}
+	}
+	return delivered
+}
+
+// publishOne delivers an event to just one subscriber of the topic, for work only one
+// of them should do. Map order is random, so the work is spread between them. It
+// reports whether a subscriber took the event.
+func (p *pubsub) publishOne(topic string, payload interface{}) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for sub := range p.topics[topic] {
+		select {
+		case sub.events <- event{Topic: topic, Payload: payload}:
+			return true
+		default:
+		}
+	}
+	return false
+}
+
+// publishRetained publishes an event and also keeps it in the topic's history, so
+// subscribers that join later still see it. Only the most recent events are kept.
+func (p *pubsub) publishRetained(topic string, payload interface{}) int {
+	p.mu.Lock()
+	h, ok := p.retained[topic]
+	if !ok {
+		h = &history{}
+		p.retained[topic] = h
+	}
+	h.events = append(h.events, event{Topic: topic, Payload: payload})
+	if len(h.events) > retainLimit {
+		// Copy down rather than reslice so the dropped events can be collected
+		n := copy(h.events, h.events[len(h.events)-retainLimit:])
+		h.events = h.events[:n]
+	}
+	h.lastSeen = time.Now()
+	p.mu.Unlock()
+	return p.publish(topic, payload)
+}
+
+// clearRetained drops the history of a topic, so late subscribers don't get it replayed
+func (p *pubsub) clearRetained(topic string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.retained, topic)
+}
+
+// pruneRetained drops the history of topics nobody has published to for a while
+func (p *pubsub) pruneRetained(now time.Time) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pruned := 0
+	for topic, h := range p.retained {
+		if now.Sub(h.lastSeen) > retainIdleTTL {
+			delete(p.retained, topic)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// retainLoop periodically prunes idle retained topics
+func (s *NetQueueServer) retainLoop() {
+	ticker := time.NewTicker(retainPruneInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if n := s.pubsub.pruneRetained(now); n > 0 {
+			slog.Debug("Pruned idle retained topics", "count", n)
+		}
+	}
+}
+
+// publishable reports whether clients may publish to topic directly. Cancellations
+// have to go through CANCEL so the job is also removed from the queue.
+func publishable(topic string) bool {
+	return topic != "" && topic != TopicCancel
+}
+
+// serveSubscription turns a connection into an event stream for topic until the
+// client disconnects. The client isn't expected to send anything else.
+func (s *NetQueueServer) serveSubscription(conn net.Conn, dec *json.Decoder, enc *json.Encoder, topic string) {
+	sub, replay := s.pubsub.subscribe(topic)
+	defer s.pubsub.unsubscribe(topic, sub)
+
+	// Any read error (normally EOF) means the subscriber went away
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		var ignored json.RawMessage
+		for dec.Decode(&ignored) == nil {
+		}
+	}()
+
+	slog.Info("Subscriber attached", "topic", topic, "remote", conn.RemoteAddr().String(), "replayed", len(replay))
+	for _, ev := range replay {
+		if err := enc.Encode(response{Status: "event", Data: ev}); err != nil {
+			slog.Warn("Failed to replay event, dropping subscriber", "topic", topic, "error", err)
+			return
+		}
+	}
+	for {
+		select {
+		case <-gone:
+			slog.Info("Subscriber detached", "topic", topic, "remote", conn.RemoteAddr().String())
+			return
+		case ev := <-sub.events:
+			if err := enc.Encode(response{Status: "event", Data: ev}); err != nil {
+				slog.Warn("Failed to deliver event, dropping subscriber", "topic", topic, "error", err)
+				return
+			}
+		}
+	}
+}
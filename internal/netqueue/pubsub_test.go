@@ -0,0 +1,132 @@
+package netqueue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNetQueue_CancelBroadcast(t *testing.T) {
+	addr := ":9102"
+	server := NewNetQueueServer()
+	go server.Start(addr)
+	time.Sleep(200 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Two workers subscribe, both must hear about the cancel
+	received := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		worker, err := NewNetQueueClient(addr)
+		if err != nil {
+			t.Fatalf("Failed to create worker client: %v", err)
+		}
+		defer worker.Close()
+		if err := worker.StartCancelConsumer(ctx, func(jobID string) { received <- jobID }); err != nil {
+			t.Fatalf("Failed to start cancel consumer: %v", err)
+		}
+	}
+
+	api, err := NewNetQueueClient(addr)
+	if err != nil {
+		t.Fatalf("Failed to create api client: %v", err)
+	}
+	defer api.Close()
+	if err := api.PublishCancelMessage("job-42"); err != nil {
+		t.Fatalf("Failed to publish cancel: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case jobID := <-received:
+			if jobID != "job-42" {
+				t.Errorf("Expected cancel for job-42, got %s", jobID)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Subscriber %d did not receive the cancel", i+1)
+		}
+	}
+}
+
+func TestPubSub_RetainedReplay(t *testing.T) {
+	p := newPubSub()
+
+	for i := 1; i <= retainLimit+10; i++ {
+		p.publishRetained("output:job-1", i)
+	}
+
+	// A late subscriber gets the most recent events, oldest first
+	_, replay := p.subscribe("output:job-1")
+	if len(replay) != retainLimit {
+		t.Fatalf("Expected %d replayed events, got %d", retainLimit, len(replay))
+	}
+	if first := replay[0].Payload.(int); first != 11 {
+		t.Errorf("Expected replay to start at event 11, got %d", first)
+	}
+	if last := replay[len(replay)-1].Payload.(int); last != retainLimit+10 {
+		t.Errorf("Expected replay to end at event %d, got %d", retainLimit+10, last)
+	}
+
+	if n := p.pruneRetained(time.Now().Add(retainIdleTTL + time.Second)); n != 1 {
+		t.Errorf("Expected 1 idle topic pruned, got %d", n)
+	}
+	if _, replay := p.subscribe("output:job-1"); len(replay) != 0 {
+		t.Errorf("Expected no replay after pruning, got %d events", len(replay))
+	}
+}
+
+func TestNetQueue_ServerCheckRequest(t *testing.T) {
+	addr := ":9103"
+	server := NewNetQueueServer()
+	go server.Start(addr)
+	time.Sleep(200 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	api, err := NewNetQueueClient(addr)
+	if err != nil {
+		t.Fatalf("Failed to create api client: %v", err)
+	}
+	defer api.Close()
+	if _, err := api.RequestServerCheck(ctx, "check-0", map[string]string{"id": "check-0"}); err != ErrNoSubscriber {
+		t.Fatalf("Expected ErrNoSubscriber without workers, got %v", err)
+	}
+
+	// Two workers subscribe, each check must be run by just one of them
+	handled := make(chan string, 10)
+	for i := 0; i < 2; i++ {
+		worker, err := NewNetQueueClient(addr)
+		if err != nil {
+			t.Fatalf("Failed to create worker client: %v", err)
+		}
+		defer worker.Close()
+		err = worker.StartServerCheckConsumer(ctx, func(payload json.RawMessage) {
+			var check struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(payload, &check)
+			handled <- check.ID
+			worker.PublishServerCheckResult(check.ID, map[string]string{"checked": check.ID})
+		})
+		if err != nil {
+			t.Fatalf("Failed to start server check consumer: %v", err)
+		}
+	}
+
+	for _, id := range []string{"check-1", "check-2", "check-3"} {
+		result, err := api.RequestServerCheck(ctx, id, map[string]string{"id": id})
+		if err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		var got map[string]string
+		if err := json.Unmarshal(result, &got); err != nil || got["checked"] != id {
+			t.Errorf("%s: expected its own result, got %s", id, result)
+		}
+	}
+	if len(handled) != 3 {
+		t.Errorf("Expected each check handled once, got %d runs", len(handled))
+	}
+}
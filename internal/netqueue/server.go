@@ -3,6 +3,7 @@ package netqueue
 import (
 	"container/heap"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net"
 	"sync"
@@ -16,7 +17,11 @@ type Job struct {
 	Args     string    `json:"args"`
 	Priority int       `json:"priority"`
 	Created  time.Time `json:"created"`
-	Payload  []byte    `json:"payload"` // for full job struct
+	Payload  []byte    `json:"payload"`          // for full job struct
+	Attempts int       `json:"attempts"`         // times the job was redelivered after a lease expired
+	ReadyAt  time.Time `json:"ready_at"`         // when a delayed job becomes available again
+	Reason   string    `json:"reason,omitempty"` // why the job was dead-lettered
+	DeadAt   time.Time `json:"dead_at"`
 }
 
 // PriorityQueue implements heap.Interface and holds Jobs
@@ -46,18 +51,87 @@ func (pq *PriorityQueue) Pop() interface{} {
 type NetQueueServer struct {
 	mu        sync.Mutex
 	jobs      PriorityQueue
-	reserved  map[string]*Job // jobs handed out but not acked
+	reserved  map[string]*Job      // jobs handed out but not acked
+	leases    map[string]time.Time // reservation deadlines, keyed by job ID
+	delayed   map[string]*Job      // jobs held back until their ReadyAt
+	dead      map[string]*Job      // dead-lettered jobs, kept until replayed or deleted
+	waiters   []chan struct{}      // blocked POPs, woken in FIFO order as jobs arrive
 	listeners map[net.Conn]struct{}
+	journal   *journal // nil when the queue is kept in memory only
+	pubsub    *pubsub
 }
 
 func NewNetQueueServer() *NetQueueServer {
 	return &NetQueueServer{
 		jobs:      make(PriorityQueue, 0),
 		reserved:  make(map[string]*Job),
+		leases:    make(map[string]time.Time),
+		delayed:   make(map[string]*Job),
+		dead:      make(map[string]*Job),
 		listeners: make(map[net.Conn]struct{}),
+		pubsub:    newPubSub(),
 	}
 }
 
+// NewPersistentNetQueueServer creates a server that journals every queue operation to
+// dataDir and restores its state from there. Jobs that were reserved but never acked
+// before the restart are put back into the queue.
+func NewPersistentNetQueueServer(dataDir string) (*NetQueueServer, error) {
+	j, err := openJournal(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := j.load()
+	if err != nil {
+		j.close()
+		return nil, err
+	}
+
+	s := NewNetQueueServer()
+	for _, job := range state.pending {
+		heap.Push(&s.jobs, job)
+	}
+	for _, job := range state.reserved {
+		heap.Push(&s.jobs, job)
+	}
+	s.delayed = state.delayed
+	s.dead = state.dead
+	s.journal = j
+
+	// Start from a fresh snapshot so the replayed log doesn't have to be read again
+	s.mu.Lock()
+	err = s.compactLocked()
+	s.mu.Unlock()
+	if err != nil {
+		j.close()
+		return nil, err
+	}
+
+	slog.Info("NetQueue state restored",
+		"data_dir", dataDir,
+		"queued", len(state.pending),
+		"requeued_reserved", len(state.reserved),
+		"delayed", len(state.delayed),
+		"dead", len(state.dead))
+	return s, nil
+}
+
+// Close flushes a final snapshot and releases the journal
+func (s *NetQueueServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.journal == nil {
+		return nil
+	}
+	if err := s.compactLocked(); err != nil {
+		slog.Error("Final snapshot failed", "error", err)
+	}
+	err := s.journal.close()
+	s.journal = nil
+	return err
+}
+
 // Start launches the server on the given address (e.g. ":9000")
 func (s *NetQueueServer) Start(addr string) error {
 	ln, err := net.Listen("tcp", addr)
@@ -65,17 +139,53 @@ func (s *NetQueueServer) Start(addr string) error {
 		return err
 	}
 	slog.Info("NetQueue server listening", "addr", addr)
+	if s.journal != nil {
+		go s.snapshotLoop()
+	}
+	go s.leaseLoop()
+	go s.retainLoop()
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
 			slog.Error("Accept error", "error", err)
 			continue
 		}
+		s.mu.Lock()
 		s.listeners[conn] = struct{}{}
+		s.mu.Unlock()
 		go s.handleConn(conn)
 	}
 }
 
+// decodeJob builds a queue entry from a full job struct sent by a client
+func decodeJob(data json.RawMessage) (*Job, error) {
+	var fields struct {
+		ID       string     `json:"id"`
+		Command  string     `json:"command"`
+		Args     string     `json:"args"`
+		Priority int        `json:"priority"`
+		RunAt    *time.Time `json:"run_at"` // optional, hold the job back until then
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if fields.ID == "" {
+		return nil, fmt.Errorf("job id is required")
+	}
+	job := &Job{
+		ID:       fields.ID,
+		Command:  fields.Command,
+		Args:     fields.Args,
+		Priority: fields.Priority,
+		Created:  time.Now().UTC(),
+		Payload:  []byte(data), // keep the full job struct for consumers
+	}
+	if fields.RunAt != nil {
+		job.ReadyAt = fields.RunAt.UTC()
+	}
+	return job, nil
+}
+
 type request struct {
 	Cmd  string          `json:"cmd"`
 	Data json.RawMessage `json:"data"`
@@ -90,7 +200,9 @@ type response struct {
 func (s *NetQueueServer) handleConn(conn net.Conn) {
 	defer func() {
 		conn.Close()
+		s.mu.Lock()
 		delete(s.listeners, conn)
+		s.mu.Unlock()
 	}()
 	dec := json.NewDecoder(conn)
 	enc := json.NewEncoder(conn)
@@ -103,42 +215,93 @@ func (s *NetQueueServer) handleConn(conn net.Conn) {
 		switch req.Cmd {
 		case "PUSH":
 			// Accept full job struct as payload
-			var fullJob map[string]interface{}
-			if err := json.Unmarshal(req.Data, &fullJob); err != nil {
+			job, err := decodeJob(req.Data)
+			if err != nil {
 				enc.Encode(response{Status: "error", Error: "invalid job"})
 				continue
 			}
-			// Use req.Data directly as the payload (it's already json.RawMessage)
-			payload := []byte(req.Data)
-			job := &Job{
-				ID:       fullJob["id"].(string),
-				Command:  fullJob["command"].(string),
-				Args:     fullJob["args"].(string),
-				Priority: int(fullJob["priority"].(float64)),
-				Created:  time.Now().UTC(),
-				Payload:  payload,
+			if err := s.push(job); err != nil {
+				slog.Error("Failed to push job", "job_id", job.ID, "error", err)
+				enc.Encode(response{Status: "error", Error: err.Error()})
+				continue
+			}
+			if job.ReadyAt.IsZero() {
+				slog.Info("Job pushed", "job_id", job.ID, "priority", job.Priority)
+			} else {
+				slog.Info("Job pushed", "job_id", job.ID, "priority", job.Priority, "run_at", job.ReadyAt)
 			}
-			s.mu.Lock()
-			heap.Push(&s.jobs, job)
-			s.mu.Unlock()
-			slog.Info("Job pushed", "job_id", job.ID, "priority", job.Priority)
 			enc.Encode(response{Status: "ok"})
+		case "PUSH_BATCH":
+			var raw []json.RawMessage
+			if err := json.Unmarshal(req.Data, &raw); err != nil {
+				enc.Encode(response{Status: "error", Error: "invalid job batch"})
+				continue
+			}
+			jobs := make([]*Job, 0, len(raw))
+			var decodeErr error
+			for i, r := range raw {
+				job, err := decodeJob(r)
+				if err != nil {
+					decodeErr = fmt.Errorf("invalid job at index %d", i)
+					break
+				}
+				jobs = append(jobs, job)
+			}
+			if decodeErr != nil {
+				enc.Encode(response{Status: "error", Error: decodeErr.Error()})
+				continue
+			}
+			if err := s.push(jobs...); err != nil {
+				slog.Error("Failed to push job batch", "count", len(jobs), "error", err)
+				enc.Encode(response{Status: "error", Error: err.Error()})
+				continue
+			}
+			slog.Info("Job batch pushed", "count", len(jobs))
+			enc.Encode(response{Status: "ok", Data: map[string]interface{}{"count": len(jobs)}})
 		case "POP":
-			s.mu.Lock()
-			if s.jobs.Len() == 0 {
-				s.mu.Unlock()
+			var pop struct {
+				Lease int `json:"lease"` // seconds, optional
+				Wait  int `json:"wait"`  // seconds to block while the queue is empty, optional
+				Max   int `json:"max"`   // reserve up to this many jobs at once, optional
+			}
+			if len(req.Data) > 0 {
+				if err := json.Unmarshal(req.Data, &pop); err != nil {
+					enc.Encode(response{Status: "error", Error: "invalid pop"})
+					continue
+				}
+			}
+			jobs, err := s.popWait(leaseFromSeconds(pop.Lease), waitFromSeconds(pop.Wait), batchFromMax(pop.Max))
+			if err != nil {
+				slog.Error("Failed to pop job", "error", err)
+				enc.Encode(response{Status: "error", Error: err.Error()})
+				continue
+			}
+			if len(jobs) == 0 {
 				enc.Encode(response{Status: "empty"})
 				continue
 			}
-			job := heap.Pop(&s.jobs).(*Job)
-			s.reserved[job.ID] = job
-			s.mu.Unlock()
-			slog.Info("Job popped", "job_id", job.ID)
-			// Send the original payload as the job, not the server's Job struct
-			enc.Encode(response{Status: "ok", Data: map[string]interface{}{
-				"payload": json.RawMessage(job.Payload),
-				"id":      job.ID,
-			}})
+			deliveries := make([]map[string]interface{}, 0, len(jobs))
+			for _, job := range jobs {
+				slog.Info("Job popped", "job_id", job.ID, "attempts", job.Attempts)
+				// Send the original payload as the job, not the server's Job struct
+				deliveries = append(deliveries, map[string]interface{}{
+					"payload":  json.RawMessage(job.Payload),
+					"id":       job.ID,
+					"attempts": job.Attempts,
+				})
+			}
+			var data interface{} = deliveries[0]
+			if pop.Max > 0 {
+				// Batch-aware consumers always get a list back
+				data = map[string]interface{}{"jobs": deliveries}
+			}
+			if err := enc.Encode(response{Status: "ok", Data: data}); err != nil {
+				// The consumer went away while it was waiting, give the jobs to someone else
+				slog.Warn("Failed to deliver popped jobs, releasing them", "count", len(jobs), "error", err)
+				for _, job := range jobs {
+					s.nack(job.ID)
+				}
+			}
 		case "ACK":
 			var ack struct {
 				ID string `json:"id"`
@@ -147,9 +310,11 @@ func (s *NetQueueServer) handleConn(conn net.Conn) {
 				enc.Encode(response{Status: "error", Error: "invalid ack"})
 				continue
 			}
-			s.mu.Lock()
-			delete(s.reserved, ack.ID)
-			s.mu.Unlock()
+			if err := s.ack(ack.ID); err != nil {
+				slog.Error("Failed to ack job", "job_id", ack.ID, "error", err)
+				enc.Encode(response{Status: "error", Error: err.Error()})
+				continue
+			}
 			slog.Info("Job acked", "job_id", ack.ID)
 			enc.Encode(response{Status: "ok"})
 		case "CANCEL":
@@ -160,27 +325,311 @@ func (s *NetQueueServer) handleConn(conn net.Conn) {
 				enc.Encode(response{Status: "error", Error: "invalid cancel"})
 				continue
 			}
-			s.mu.Lock()
-			// Remove from reserved or queue
-			if _, ok := s.reserved[cancel.ID]; ok {
-				delete(s.reserved, cancel.ID)
-				s.mu.Unlock()
+			wasReserved, err := s.cancel(cancel.ID)
+			if err != nil {
+				slog.Error("Failed to cancel job", "job_id", cancel.ID, "error", err)
+				enc.Encode(response{Status: "error", Error: err.Error()})
+				continue
+			}
+			if wasReserved {
 				slog.Info("Job canceled (reserved)", "job_id", cancel.ID)
-				enc.Encode(response{Status: "ok"})
+			} else {
+				slog.Info("Job canceled (queue)", "job_id", cancel.ID)
+			}
+			// Tell every worker, whichever one holds the job will stop it
+			notified := s.pubsub.publish(TopicCancel, map[string]string{"job_id": cancel.ID})
+			slog.Info("Cancel broadcast", "job_id", cancel.ID, "subscribers", notified)
+			enc.Encode(response{Status: "ok"})
+		case "SUBSCRIBE":
+			var sub struct {
+				Topic string `json:"topic"`
+			}
+			if err := json.Unmarshal(req.Data, &sub); err != nil || sub.Topic == "" {
+				enc.Encode(response{Status: "error", Error: "invalid subscribe"})
 				continue
 			}
-			// Remove from queue
-			for i, job := range s.jobs {
-				if job.ID == cancel.ID {
-					heap.Remove(&s.jobs, i)
-					break
+			enc.Encode(response{Status: "ok"})
+			// The connection only carries events from here on
+			s.serveSubscription(conn, dec, enc, sub.Topic)
+			return
+		case "PUBLISH":
+			var pub struct {
+				Topic   string          `json:"topic"`
+				Payload json.RawMessage `json:"payload"`
+				Retain  bool            `json:"retain"` // keep it for late subscribers
+				One     bool            `json:"one"`    // deliver it to one subscriber only
+			}
+			if err := json.Unmarshal(req.Data, &pub); err != nil || !publishable(pub.Topic) {
+				enc.Encode(response{Status: "error", Error: "invalid publish"})
+				continue
+			}
+			var delivered int
+			switch {
+			case pub.One:
+				if s.pubsub.publishOne(pub.Topic, pub.Payload) {
+					delivered = 1
 				}
+			case pub.Retain:
+				delivered = s.pubsub.publishRetained(pub.Topic, pub.Payload)
+			default:
+				delivered = s.pubsub.publish(pub.Topic, pub.Payload)
 			}
-			s.mu.Unlock()
-			slog.Info("Job canceled (queue)", "job_id", cancel.ID)
+			enc.Encode(response{Status: "ok", Data: map[string]interface{}{"delivered": delivered}})
+		case "TOUCH":
+			var touch struct {
+				ID    string `json:"id"`
+				Lease int    `json:"lease"` // seconds, optional
+			}
+			if err := json.Unmarshal(req.Data, &touch); err != nil {
+				enc.Encode(response{Status: "error", Error: "invalid touch"})
+				continue
+			}
+			deadline, err := s.touch(touch.ID, leaseFromSeconds(touch.Lease))
+			if err != nil {
+				enc.Encode(response{Status: "error", Error: err.Error()})
+				continue
+			}
+			slog.Debug("Job lease extended", "job_id", touch.ID, "deadline", deadline)
+			enc.Encode(response{Status: "ok", Data: map[string]interface{}{"deadline": deadline}})
+		case "NACK":
+			var nack struct {
+				ID    string `json:"id"`
+				Delay int    `json:"delay"` // seconds to hold the job back, optional
+			}
+			if err := json.Unmarshal(req.Data, &nack); err != nil {
+				enc.Encode(response{Status: "error", Error: "invalid nack"})
+				continue
+			}
+			if nack.Delay > 0 {
+				readyAt, err := s.nackDelayed(nack.ID, delayFromSeconds(nack.Delay))
+				if err != nil {
+					enc.Encode(response{Status: "error", Error: err.Error()})
+					continue
+				}
+				slog.Info("Job delayed", "job_id", nack.ID, "ready_at", readyAt)
+				enc.Encode(response{Status: "ok", Data: map[string]interface{}{"ready_at": readyAt}})
+				continue
+			}
+			if err := s.nack(nack.ID); err != nil {
+				enc.Encode(response{Status: "error", Error: err.Error()})
+				continue
+			}
+			slog.Info("Job released back to queue", "job_id", nack.ID)
+			enc.Encode(response{Status: "ok"})
+		case "DEAD_LETTER":
+			var dl struct {
+				ID     string `json:"id"`
+				Reason string `json:"reason"`
+			}
+			if err := json.Unmarshal(req.Data, &dl); err != nil {
+				enc.Encode(response{Status: "error", Error: "invalid dead letter"})
+				continue
+			}
+			if err := s.deadLetter(dl.ID, dl.Reason); err != nil {
+				enc.Encode(response{Status: "error", Error: err.Error()})
+				continue
+			}
+			slog.Warn("Job dead-lettered", "job_id", dl.ID, "reason", dl.Reason)
+			enc.Encode(response{Status: "ok"})
+		case "DLQ_LIST":
+			enc.Encode(response{Status: "ok", Data: map[string]interface{}{"dead_letters": s.deadLetters()}})
+		case "DLQ_REPLAY", "DLQ_DELETE":
+			var dl struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal(req.Data, &dl); err != nil {
+				enc.Encode(response{Status: "error", Error: "invalid dead letter"})
+				continue
+			}
+			var err error
+			if req.Cmd == "DLQ_REPLAY" {
+				err = s.replayDeadLetter(dl.ID)
+			} else {
+				err = s.deleteDeadLetter(dl.ID)
+			}
+			if err != nil {
+				enc.Encode(response{Status: "error", Error: err.Error()})
+				continue
+			}
+			slog.Info("Dead letter settled", "job_id", dl.ID, "cmd", req.Cmd)
 			enc.Encode(response{Status: "ok"})
 		default:
 			enc.Encode(response{Status: "error", Error: "unknown command"})
 		}
 	}
 }
+
+// push adds jobs to the queue, journaling them first when persistence is enabled.
+// Jobs with a ReadyAt in the future go to the delayed set until they are due.
+// A batch is journaled and queued atomically.
+func (s *NetQueueServer) push(jobs ...*Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]journalEntry, 0, len(jobs))
+	for _, job := range jobs {
+		entries = append(entries, journalEntry{Op: opPush, ID: job.ID, Job: job})
+	}
+	if err := s.journalLocked(entries...); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, job := range jobs {
+		if job.ReadyAt.After(now) {
+			s.delayed[job.ID] = job
+			continue
+		}
+		heap.Push(&s.jobs, job)
+		s.notifyLocked()
+	}
+	return nil
+}
+
+// pop reserves the highest priority job for the given lease, returning nil when the queue is empty
+func (s *NetQueueServer) pop(lease time.Duration) (*Job, error) {
+	jobs, err := s.popBatch(lease, 1)
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return jobs[0], nil
+}
+
+// popBatch atomically reserves up to max jobs in priority order
+func (s *NetQueueServer) popBatch(lease time.Duration, max int) ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := max
+	if n > s.jobs.Len() {
+		n = s.jobs.Len()
+	}
+	if n == 0 {
+		return nil, nil
+	}
+
+	// The heap only orders its root, so pop first and journal what we took
+	jobs := make([]*Job, 0, n)
+	entries := make([]journalEntry, 0, n)
+	for i := 0; i < n; i++ {
+		job := heap.Pop(&s.jobs).(*Job)
+		jobs = append(jobs, job)
+		entries = append(entries, journalEntry{Op: opPop, ID: job.ID})
+	}
+	if err := s.journalLocked(entries...); err != nil {
+		for _, job := range jobs {
+			heap.Push(&s.jobs, job)
+		}
+		return nil, err
+	}
+
+	deadline := time.Now().Add(lease)
+	for _, job := range jobs {
+		s.reserved[job.ID] = job
+		s.leases[job.ID] = deadline
+	}
+	return jobs, nil
+}
+
+// ack marks a reserved job as done
+func (s *NetQueueServer) ack(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.journalLocked(journalEntry{Op: opAck, ID: id}); err != nil {
+		return err
+	}
+	delete(s.reserved, id)
+	delete(s.leases, id)
+	return nil
+}
+
+// cancel removes a job from the reserved set, the delayed set, the dead letters or the
+// queue and reports whether it was reserved
+func (s *NetQueueServer) cancel(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.journalLocked(journalEntry{Op: opCancel, ID: id}); err != nil {
+		return false, err
+	}
+	// A canceled dead letter can't be replayed any more, as after a restart
+	if _, ok := s.dead[id]; ok {
+		delete(s.dead, id)
+		return false, nil
+	}
+	// Remove from reserved or queue
+	if _, ok := s.reserved[id]; ok {
+		delete(s.reserved, id)
+		delete(s.leases, id)
+		return true, nil
+	}
+	if _, ok := s.delayed[id]; ok {
+		delete(s.delayed, id)
+		return false, nil
+	}
+	for i, job := range s.jobs {
+		if job.ID == id {
+			heap.Remove(&s.jobs, i)
+			break
+		}
+	}
+	return false, nil
+}
+
+// journalLocked appends entries to the journal and compacts it once it grows too long.
+// The caller must hold s.mu.
+func (s *NetQueueServer) journalLocked(entries ...journalEntry) error {
+	if s.journal == nil {
+		return nil
+	}
+	if err := s.journal.append(entries...); err != nil {
+		return fmt.Errorf("journal write failed: %w", err)
+	}
+	if s.journal.entries >= snapshotThreshold {
+		if err := s.compactLocked(); err != nil {
+			// The entry is already durable in the log, so only report the failure
+			slog.Error("Journal compaction failed", "error", err)
+		}
+	}
+	return nil
+}
+
+// compactLocked snapshots the current queue state and truncates the journal.
+// The caller must hold s.mu.
+func (s *NetQueueServer) compactLocked() error {
+	snap := queueSnapshot{
+		Jobs:     make([]*Job, 0, len(s.jobs)),
+		Reserved: make([]*Job, 0, len(s.reserved)),
+		Delayed:  make([]*Job, 0, len(s.delayed)),
+		Dead:     make([]*Job, 0, len(s.dead)),
+		TakenAt:  time.Now().UTC(),
+	}
+	snap.Jobs = append(snap.Jobs, s.jobs...)
+	for _, job := range s.reserved {
+		snap.Reserved = append(snap.Reserved, job)
+	}
+	for _, job := range s.delayed {
+		snap.Delayed = append(snap.Delayed, job)
+	}
+	for _, job := range s.dead {
+		snap.Dead = append(snap.Dead, job)
+	}
+	return s.journal.compact(snap)
+}
+
+// snapshotLoop periodically compacts the journal while there is something to compact
+func (s *NetQueueServer) snapshotLoop() {
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.mu.Lock()
+		if s.journal == nil {
+			s.mu.Unlock()
+			return
+		}
+		if s.journal.entries > 0 {
+			if err := s.compactLocked(); err != nil {
+				slog.Error("Periodic snapshot failed", "error", err)
+			} else {
+				slog.Debug("Journal snapshot written", "queued", s.jobs.Len(), "reserved", len(s.reserved))
+			}
+		}
+		s.mu.Unlock()
+	}
+}
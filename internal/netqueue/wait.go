@@ -0,0 +1,99 @@
+package netqueue
+
+import "time"
+
+const (
+	// maxPopWait caps how long a single POP may block waiting for a job
+	maxPopWait = 30 * time.Second
+	// maxPopBatch caps how many jobs a single POP may reserve
+	maxPopBatch = 100
+)
+
+// batchFromMax converts a POP max requested over the wire; anything below one means one
+func batchFromMax(max int) int {
+	if max < 1 {
+		return 1
+	}
+	if max > maxPopBatch {
+		return maxPopBatch
+	}
+	return max
+}
+
+// waitFromSeconds converts a POP wait requested over the wire; zero means don't block
+func waitFromSeconds(seconds int) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	wait := time.Duration(seconds) * time.Second
+	if wait > maxPopWait {
+		return maxPopWait
+	}
+	return wait
+}
+
+// popWait pops up to max jobs, blocking for up to wait while the queue is empty.
+// It returns no jobs when nothing arrived in time.
+func (s *NetQueueServer) popWait(lease, wait time.Duration, max int) ([]*Job, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		jobs, err := s.popBatch(lease, max)
+		if err != nil || len(jobs) > 0 {
+			return jobs, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, nil
+		}
+
+		s.mu.Lock()
+		// A job may have been pushed between pop and taking the lock
+		if s.jobs.Len() > 0 {
+			s.mu.Unlock()
+			continue
+		}
+		waiter := make(chan struct{}, 1)
+		s.waiters = append(s.waiters, waiter)
+		s.mu.Unlock()
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-waiter:
+			timer.Stop()
+			// Woken by a push; loop around and race the other consumers for it
+		case <-timer.C:
+			s.mu.Lock()
+			s.removeWaiterLocked(waiter)
+			select {
+			case <-waiter:
+				// We were woken just as we timed out; pass the wake-up on so the job
+				// doesn't sit in the queue while someone else is still waiting
+				s.notifyLocked()
+			default:
+			}
+			s.mu.Unlock()
+			return nil, nil
+		}
+	}
+}
+
+// notifyLocked wakes the longest waiting POP, if any. The caller must hold s.mu.
+func (s *NetQueueServer) notifyLocked() {
+	if len(s.waiters) == 0 {
+		return
+	}
+	waiter := s.waiters[0]
+	s.waiters = s.waiters[1:]
+	waiter <- struct{}{} // buffered, never blocks
+}
+
+// removeWaiterLocked drops a waiter that gave up. The caller must hold s.mu.
+func (s *NetQueueServer) removeWaiterLocked(waiter chan struct{}) {
+	for i, w := range s.waiters {
+		if w == waiter {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package netqueue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNetQueue_BlockingPopWakesOnPush(t *testing.T) {
+	server := NewNetQueueServer()
+
+	start := time.Now()
+	if jobs, err := server.popWait(time.Minute, 100*time.Millisecond, 1); err != nil || len(jobs) != 0 {
+		t.Fatalf("Expected empty pop after wait, got %v, %v", jobs, err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected pop to block for the wait period, returned after %v", elapsed)
+	}
+
+	result := make(chan *Job, 1)
+	go func() {
+		jobs, _ := server.popWait(time.Minute, 5*time.Second, 1)
+		if len(jobs) == 1 {
+			result <- jobs[0]
+		} else {
+			result <- nil
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	pushed := time.Now()
+	if err := server.push(&Job{ID: "job-1", Priority: 5, Created: time.Now().UTC()}); err != nil {
+		t.Fatalf("Failed to push job: %v", err)
+	}
+
+	select {
+	case job := <-result:
+		if job == nil || job.ID != "job-1" {
+			t.Fatalf("Expected waiter to receive job-1, got %v", job)
+		}
+		if latency := time.Since(pushed); latency > time.Second {
+			t.Errorf("Waiter woke up too late: %v", latency)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Waiter was not woken by push")
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.waiters) != 0 {
+		t.Errorf("Expected no waiters left, got %d", len(server.waiters))
+	}
+}
@@ -2,8 +2,11 @@ package queue
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"job-executor/internal/models"
 	netqueue "job-executor/internal/netqueue"
+	"log/slog"
 	"time"
 )
 
@@ -18,6 +21,29 @@ type OutputEvent struct {
 	LineCount int    `json:"line_count"`
 	Timestamp string `json:"timestamp"`
 }
+
+// DeadLetter is a job the queue gave up on
+type DeadLetter = netqueue.DeadLetter
+
+// ServerCheck asks a worker to connect to a server. Only workers can decrypt server
+// credentials, so connection tests and re-pinning go through them.
+type ServerCheck struct {
+	ID       string `json:"id"`
+	ServerID string `json:"server_id"`
+	Repin    bool   `json:"repin"` // pin the host key presented now in place of the pinned ones
+}
+
+// ServerCheckResult is what the worker found
+type ServerCheckResult struct {
+	Status        string `json:"status"` // connection_successful, connection_failed or host_key_mismatch
+	Error         string `json:"error,omitempty"`
+	HostKey       string `json:"host_key,omitempty"`
+	HostKeyPinned bool   `json:"host_key_pinned"`
+}
+
+// ErrNoWorker is returned for a server check when no worker is running to take it
+var ErrNoWorker = netqueue.ErrNoSubscriber
+
 type NetQueue struct {
 	client *netqueue.NetQueueClient
 }
@@ -34,43 +60,115 @@ func (q *NetQueue) Push(job *models.Job) error {
 	return q.client.Push(job)
 }
 
+// PushBatch queues several jobs in one round trip
+func (q *NetQueue) PushBatch(jobs []*models.Job) error {
+	return q.client.PushBatch(jobs)
+}
+
 func (q *NetQueue) StartConsumer(ctx context.Context, handler func(*models.Job)) error {
 	return q.client.StartConsumer(ctx, handler)
 }
 
+// Ack confirms a consumed job so the queue forgets its reservation
+func (q *NetQueue) Ack(jobID string) error {
+	return q.client.Ack(jobID)
+}
+
+// Nack hands a consumed job back to the queue for another consumer
+func (q *NetQueue) Nack(jobID string) error {
+	return q.client.Nack(jobID)
+}
+
+// Retry hands a consumed job back to the queue once delay has passed
+func (q *NetQueue) Retry(jobID string, delay time.Duration) error {
+	return q.client.Retry(jobID, delay)
+}
+
+// DeadLetter parks a consumed job on the queue's dead-letter list
+func (q *NetQueue) DeadLetter(jobID, reason string) error {
+	return q.client.DeadLetter(jobID, reason)
+}
+
+// DeadLetters lists the jobs on the dead-letter list, oldest first
+func (q *NetQueue) DeadLetters() ([]DeadLetter, error) {
+	return q.client.DeadLetters()
+}
+
+// ReplayDeadLetter puts a dead-lettered job back into the queue
+func (q *NetQueue) ReplayDeadLetter(jobID string) error {
+	return q.client.ReplayDeadLetter(jobID)
+}
+
+// DeleteDeadLetter removes a job from the dead-letter list
+func (q *NetQueue) DeleteDeadLetter(jobID string) error {
+	return q.client.DeleteDeadLetter(jobID)
+}
+
+// SetAutoAck controls whether consumed jobs are acked as soon as the handler returns
+func (q *NetQueue) SetAutoAck(enabled bool) {
+	q.client.SetAutoAck(enabled)
+}
+
+// SetPrefetch sets how many unacked jobs a consumer reserves ahead of processing
+func (q *NetQueue) SetPrefetch(n int) {
+	q.client.SetPrefetch(n)
+}
+
 func (q *NetQueue) PublishCancelMessage(jobID string) error {
 	return q.client.PublishCancelMessage(jobID)
 }
 
+// StartCancelConsumer calls handler with the ID of every job canceled through the queue
 func (q *NetQueue) StartCancelConsumer(ctx context.Context, handler func(string)) error {
-	// For NetQueue, we'll implement a simple polling mechanism
-	// This is not ideal but works with the current TCP-based queue
-	go func() {
-		ticker := time.NewTicker(2 * time.Second) // Poll every 2 seconds
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				// For now, we'll just log that cancel consumer is running
-				// In a real implementation, you'd poll for cancel messages
-				// This is a simplified version for the TCP-based queue
-			}
-		}
-	}()
-	return nil
+	return q.client.StartCancelConsumer(ctx, handler)
 }
 
+// PublishOutputEvent publishes a chunk of live job output for stream viewers
 func (q *NetQueue) PublishOutputEvent(jobID, output string, isStderr bool, lineCount int) error {
-	// Not implemented in NetQueueClient, so just return nil
-	return nil
+	return q.client.PublishOutputEvent(jobID, output, isStderr, lineCount)
 }
 
+// StartOutputConsumer calls handler with each chunk of live output published for jobID
 func (q *NetQueue) StartOutputConsumer(ctx context.Context, jobID string, handler func(OutputEvent)) error {
-	// Not implemented in NetQueueClient, so just return nil
-	return nil
+	return q.client.StartOutputConsumer(ctx, jobID, func(payload json.RawMessage) {
+		var event OutputEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			slog.Error("Invalid output event", "job_id", jobID, "error", err)
+			return
+		}
+		handler(event)
+	})
+}
+
+// CheckServer has one of the running workers carry out check and waits for the result
+func (q *NetQueue) CheckServer(ctx context.Context, check ServerCheck) (*ServerCheckResult, error) {
+	payload, err := q.client.RequestServerCheck(ctx, check.ID, check)
+	if err != nil {
+		return nil, err
+	}
+	var result ServerCheckResult
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return nil, fmt.Errorf("invalid server check result: %w", err)
+	}
+	return &result, nil
+}
+
+// StartServerCheckConsumer carries out the server checks handed to this worker with
+// handler, each in its own goroutine, and publishes their results
+func (q *NetQueue) StartServerCheckConsumer(ctx context.Context, handler func(context.Context, ServerCheck) ServerCheckResult) error {
+	return q.client.StartServerCheckConsumer(ctx, func(payload json.RawMessage) {
+		var check ServerCheck
+		if err := json.Unmarshal(payload, &check); err != nil || check.ID == "" {
+			slog.Error("Invalid server check", "error", err)
+			return
+		}
+		go func() {
+			result := handler(ctx, check)
+			if err := q.client.PublishServerCheckResult(check.ID, result); err != nil {
+				slog.Error("Failed to publish server check result", "check_id", check.ID, "server_id", check.ServerID, "error", err)
+			}
+		}()
+	})
 }
 
 func (q *NetQueue) Close() error {
@@ -0,0 +1,63 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCron_Next(t *testing.T) {
+	utc := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	cases := []struct {
+		expr, from, want string
+	}{
+		{"*/15 * * * *", "2025-01-01T10:07:30Z", "2025-01-01T10:15:00Z"},
+		{"30 2 * * 1-5", "2025-01-03T03:00:00Z", "2025-01-06T02:30:00Z"}, // Friday -> Monday
+		{"@daily", "2025-01-01T00:00:00Z", "2025-01-02T00:00:00Z"},
+		{"0 9 1 * mon", "2025-01-01T10:00:00Z", "2025-01-06T09:00:00Z"}, // day of month OR day of week
+		{"0 0 29 feb *", "2025-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"0 12 * * 7", "2025-01-01T00:00:00Z", "2025-01-05T12:00:00Z"}, // 7 is Sunday
+	}
+	for _, tc := range cases {
+		c, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tc.expr, err)
+		}
+		if got := c.Next(utc(tc.from)); !got.Equal(utc(tc.want)) {
+			t.Errorf("%q from %s: got %s, want %s", tc.expr, tc.from, got.Format(time.RFC3339), tc.want)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "5-1 * * * *", "*/0 * * * *", "0 0 * foo *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) should fail", expr)
+		}
+	}
+
+	if _, err := NextRun("0 0 30 2 *", "UTC", time.Now()); err == nil {
+		t.Error("expected an error for a schedule that never fires")
+	}
+}
+
+func TestCron_Timezone(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("timezone data not available")
+	}
+
+	// 02:30 doesn't exist on the day clocks go forward, so that day's run is skipped
+	next, err := NextRun("30 2 * * *", loc.String(), time.Date(2025, 3, 8, 12, 0, 0, 0, loc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2025, 3, 10, 2, 30, 0, 0, loc); !next.Equal(want) {
+		t.Errorf("got %s, want %s", next.In(loc), want)
+	}
+}
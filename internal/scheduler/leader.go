@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"job-executor/internal/models"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// leaderLeaseName identifies the scheduler's row in the leases table
+	leaderLeaseName = "scheduler"
+	// leaderLeaseTTL is how long leadership lasts without renewal. It is renewed every
+	// tick, so it only runs out when the leader dies.
+	leaderLeaseTTL = 30 * time.Second
+)
+
+// acquireLeadership takes over or renews the leader lease and reports whether this
+// instance holds it. Instances compare against their own clocks, so they should be
+// kept roughly in sync.
+func (s *Scheduler) acquireLeadership(now time.Time) (bool, error) {
+	expiresAt := now.Add(leaderLeaseTTL)
+
+	// Renew our own lease, or take over one that has run out
+	result := s.db.Model(&models.SchedulerLease{}).
+		Where("name = ? AND (holder = ? OR expires_at < ?)", leaderLeaseName, s.instanceID, now).
+		Updates(map[string]interface{}{"holder": s.instanceID, "expires_at": expiresAt})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	// Nobody has ever held it; whoever inserts the row first wins
+	result = s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.SchedulerLease{
+		Name:      leaderLeaseName,
+		Holder:    s.instanceID,
+		ExpiresAt: expiresAt,
+	})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// releaseLeadership lets another instance take over straight away on shutdown
+func (s *Scheduler) releaseLeadership() {
+	if !s.leader {
+		return
+	}
+	err := s.db.Model(&models.SchedulerLease{}).
+		Where("name = ? AND holder = ?", leaderLeaseName, s.instanceID).
+		Update("expires_at", time.Time{}).Error
+	if err != nil {
+		slog.Warn("Failed to release scheduler leadership", "error", err)
+	}
+	s.leader = false
+}
@@ -0,0 +1,260 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"job-executor/internal/models"
+	"job-executor/internal/queue"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// defaultTickInterval is how often the scheduler looks for due schedules
+const defaultTickInterval = 10 * time.Second
+
+// Scheduler fires due schedules by creating their jobs and pushing them to the queue.
+// Any number of instances may run; only the one holding the leader lease fires.
+type Scheduler struct {
+	db         *gorm.DB
+	queue      queue.NetQueue
+	instanceID string
+	interval   time.Duration
+	leader     bool
+}
+
+func New(db *gorm.DB, queue queue.NetQueue) *Scheduler {
+	hostname, _ := os.Hostname()
+	return &Scheduler{
+		db:         db,
+		queue:      queue,
+		instanceID: fmt.Sprintf("%s-%s", hostname, uuid.New().String()[:8]),
+		interval:   defaultTickInterval,
+	}
+}
+
+// SetInterval configures how often due schedules are checked
+func (s *Scheduler) SetInterval(interval time.Duration) {
+	if interval < time.Second {
+		interval = time.Second
+	}
+	s.interval = interval
+}
+
+// NextRun computes when a schedule fires next after the given time, evaluated in the
+// schedule's timezone
+func NextRun(cronExpr, timezone string, after time.Time) (time.Time, error) {
+	cron, err := ParseCron(cronExpr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timezone %q", timezone)
+	}
+	next := cron.Next(after.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression %q never matches", cronExpr)
+	}
+	return next.UTC(), nil
+}
+
+// Start runs the scheduler until ctx is done
+func (s *Scheduler) Start(ctx context.Context) {
+	slog.Info("Starting scheduler", "instance_id", s.instanceID, "interval", s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.tick(time.Now().UTC())
+
+		select {
+		case <-ctx.Done():
+			s.releaseLeadership()
+			slog.Info("Scheduler stopped", "instance_id", s.instanceID)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick fires every due schedule if this instance is the leader
+func (s *Scheduler) tick(now time.Time) {
+	leader, err := s.acquireLeadership(now)
+	if err != nil {
+		slog.Error("Scheduler leader election failed", "error", err)
+		leader = false
+	}
+	if leader != s.leader {
+		s.leader = leader
+		if leader {
+			slog.Info("Scheduler became leader", "instance_id", s.instanceID)
+		} else {
+			slog.Info("Scheduler lost leadership", "instance_id", s.instanceID)
+		}
+	}
+	if !leader {
+		return
+	}
+
+	var due []models.Schedule
+	if err := s.db.Where("enabled = ? AND next_run_at <= ?", true, now).Order("next_run_at").Find(&due).Error; err != nil {
+		slog.Error("Failed to fetch due schedules", "error", err)
+		return
+	}
+	for i := range due {
+		s.fire(&due[i], now)
+	}
+	s.releaseWaiting()
+}
+
+// fire starts one run of a schedule. Runs missed while no scheduler was up are not
+// made up for; the schedule fires once and moves on to its next time from now.
+func (s *Scheduler) fire(schedule *models.Schedule, now time.Time) {
+	next, err := NextRun(schedule.CronExpr, schedule.Timezone, now)
+	if err != nil {
+		// The schedule can never fire again, stop looking at it
+		slog.Error("Disabling schedule with invalid timing", "schedule_id", schedule.ID, "error", err)
+		s.db.Model(schedule).Updates(map[string]interface{}{"enabled": false, "next_run_at": nil})
+		return
+	}
+
+	// Claim this run by moving next_run_at on. If another scheduler already claimed it,
+	// nothing is updated and we leave it alone.
+	result := s.db.Model(&models.Schedule{}).
+		Where("id = ? AND next_run_at = ?", schedule.ID, schedule.NextRunAt).
+		Updates(map[string]interface{}{"next_run_at": next, "last_run_at": now})
+	if result.Error != nil {
+		slog.Error("Failed to claim schedule run", "schedule_id", schedule.ID, "error", result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+
+	var active []models.Job
+	if err := s.db.Where("schedule_id = ? AND status IN ?", schedule.ID, models.ActiveStatuses).Find(&active).Error; err != nil {
+		slog.Error("Failed to check for overlapping runs", "schedule_id", schedule.ID, "error", err)
+		return
+	}
+	if len(active) > 0 {
+		switch schedule.OverlapPolicy {
+		case models.OverlapQueue:
+			// One run waits for the previous one, more would only pile up
+			for i := range active {
+				if active[i].Status == models.StatusPending {
+					slog.Info("A run is already waiting for the previous one, skipping", "schedule_id", schedule.ID, "waiting_job_id", active[i].ID, "next_run_at", next)
+					return
+				}
+			}
+			slog.Info("Previous run still active, queueing another after it", "schedule_id", schedule.ID, "active_jobs", len(active))
+		case models.OverlapCancelPrevious:
+			for i := range active {
+				s.cancelJob(&active[i], now)
+			}
+		default:
+			slog.Info("Previous run still active, skipping", "schedule_id", schedule.ID, "active_jobs", len(active), "next_run_at", next)
+			return
+		}
+	}
+
+	job := schedule.NewJob()
+	if len(active) > 0 && schedule.OverlapPolicy == models.OverlapQueue {
+		// Held back until the previous run has finished, see releaseWaiting
+		job.Status = models.StatusPending
+	}
+	if err := s.db.Create(job).Error; err != nil {
+		slog.Error("Failed to create scheduled job", "schedule_id", schedule.ID, "error", err)
+		return
+	}
+	if job.Status == models.StatusPending {
+		slog.Info("Schedule fired, run waiting for the previous one",
+			"schedule_id", schedule.ID,
+			"schedule_name", schedule.Name,
+			"job_id", job.ID,
+			"next_run_at", next)
+		return
+	}
+	if !s.push(job) {
+		return
+	}
+
+	slog.Info("Schedule fired",
+		"schedule_id", schedule.ID,
+		"schedule_name", schedule.Name,
+		"job_id", job.ID,
+		"next_run_at", next)
+}
+
+// releaseWaiting queues the runs that were waiting for the previous run of their
+// schedule, once that run has finished
+func (s *Scheduler) releaseWaiting() {
+	var waiting []models.Job
+	if err := s.db.Where("schedule_id IS NOT NULL AND status = ?", models.StatusPending).Order("created_at").Find(&waiting).Error; err != nil {
+		slog.Error("Failed to fetch waiting scheduled jobs", "error", err)
+		return
+	}
+	for i := range waiting {
+		job := &waiting[i]
+		var active int64
+		if err := s.db.Model(&models.Job{}).
+			Where("schedule_id = ? AND id <> ? AND status IN ?", *job.ScheduleID, job.ID, models.ActiveStatuses).
+			Count(&active).Error; err != nil {
+			slog.Error("Failed to check for the previous run", "job_id", job.ID, "error", err)
+			continue
+		}
+		if active > 0 {
+			continue
+		}
+
+		// Claim the job, it may have been canceled while it waited
+		result := s.db.Model(&models.Job{}).
+			Where("id = ? AND status = ?", job.ID, models.StatusPending).
+			Update("status", models.StatusQueued)
+		if result.Error != nil {
+			slog.Error("Failed to release waiting scheduled job", "job_id", job.ID, "error", result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		job.Status = models.StatusQueued
+		if s.push(job) {
+			slog.Info("Previous run finished, released waiting run", "schedule_id", *job.ScheduleID, "job_id", job.ID)
+		}
+	}
+}
+
+// push hands a scheduled job to the queue, failing the job if that doesn't work
+func (s *Scheduler) push(job *models.Job) bool {
+	if err := s.queue.Push(job); err != nil {
+		slog.Error("Failed to push scheduled job to queue", "schedule_id", *job.ScheduleID, "job_id", job.ID, "error", err)
+		job.Status = models.StatusFailed
+		job.Error = "Failed to queue job: " + err.Error()
+		s.db.Save(job)
+		return false
+	}
+	return true
+}
+
+// cancelJob cancels a previous run that is still active, the same way the API does
+func (s *Scheduler) cancelJob(job *models.Job, now time.Time) {
+	result := s.db.Model(&models.Job{}).
+		Where("id = ? AND status IN ?", job.ID, models.ActiveStatuses).
+		Updates(map[string]interface{}{"status": models.StatusCanceled, "finished_at": now})
+	if result.Error != nil {
+		slog.Error("Failed to cancel previous run", "job_id", job.ID, "error", result.Error)
+		return
+	}
+	// Drop it from the queue, and stop it if a worker is running it
+	if err := s.queue.PublishCancelMessage(job.ID); err != nil {
+		slog.Error("Failed to publish cancel message", "job_id", job.ID, "error", err)
+	}
+	slog.Info("Canceled previous run", "job_id", job.ID, "schedule_id", *job.ScheduleID)
+}
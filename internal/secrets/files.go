@@ -0,0 +1,93 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"job-executor/internal/credentials"
+)
+
+// FileProvider reads each secret from a file named after it, such as the secrets
+// Docker and Kubernetes mount under /run/secrets
+type FileProvider struct {
+	dir string
+}
+
+func NewFileProvider(dir string) *FileProvider {
+	return &FileProvider{dir: dir}
+}
+
+func (p *FileProvider) Secret(ctx context.Context, name string) (string, error) {
+	content, err := readSecretFile(p.dir, name)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// EncryptedFileProvider reads secrets from files encrypted with the credentials master
+// keys, as written by WriteEncryptedFile
+type EncryptedFileProvider struct {
+	dir  string
+	keys *credentials.Keyring
+}
+
+func NewEncryptedFileProvider(dir string, keys *credentials.Keyring) *EncryptedFileProvider {
+	return &EncryptedFileProvider{dir: dir, keys: keys}
+}
+
+func (p *EncryptedFileProvider) Secret(ctx context.Context, name string) (string, error) {
+	content, err := readSecretFile(p.dir, name)
+	if err != nil {
+		return "", err
+	}
+	sealed := strings.TrimSpace(string(content))
+	if !credentials.IsEncrypted(sealed) {
+		return "", fmt.Errorf("secret %s is not encrypted", name)
+	}
+	return p.keys.Decrypt(sealed)
+}
+
+// WriteEncryptedFile encrypts secret and stores it under name
+func (p *EncryptedFileProvider) WriteEncryptedFile(name, secret string) error {
+	path, err := secretPath(p.dir, name)
+	if err != nil {
+		return err
+	}
+	if p.keys == nil {
+		return fmt.Errorf("no credentials master key configured")
+	}
+	sealed, err := p.keys.Encrypt(secret)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(sealed+"\n"), 0600)
+}
+
+func readSecretFile(dir, name string) ([]byte, error) {
+	path, err := secretPath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return content, err
+}
+
+// secretPath returns the file of a secret, making sure the name can't point outside dir
+func secretPath(dir, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	return filepath.Join(dir, clean), nil
+}
@@ -0,0 +1,158 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"job-executor/internal/config"
+	"job-executor/internal/credentials"
+)
+
+// Reference schemes. A secret:// reference is looked up with the configured provider,
+// env:// and file:// ones are read from the process's environment and file system.
+const (
+	SchemeSecret = "secret://"
+	SchemeEnv    = "env://"
+	SchemeFile   = "file://"
+)
+
+// EnvPrefix starts the names of the variables env:// references may read, so a
+// server's credentials can't point at the rest of the worker's environment
+const EnvPrefix = "REMORA_SECRET_"
+
+// ErrNotFound is returned by providers for secrets they don't hold
+var ErrNotFound = errors.New("secret not found")
+
+// SecretProvider looks up secrets by name
+type SecretProvider interface {
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// IsReference reports whether a credential is a reference to a secret rather than
+// the secret itself
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, SchemeSecret) ||
+		strings.HasPrefix(value, SchemeEnv) ||
+		strings.HasPrefix(value, SchemeFile)
+}
+
+// ValidateReference checks that an env:// or file:// reference only reads what it may:
+// a variable named EnvPrefix... or a file inside dir. Other values are valid.
+func ValidateReference(value, dir string) error {
+	switch {
+	case strings.HasPrefix(value, SchemeEnv):
+		_, err := envName(value)
+		return err
+	case strings.HasPrefix(value, SchemeFile):
+		_, err := filePath(value, dir)
+		return err
+	}
+	return nil
+}
+
+func envName(value string) (string, error) {
+	name := strings.TrimPrefix(value, SchemeEnv)
+	if !strings.HasPrefix(name, EnvPrefix) || name == EnvPrefix {
+		return "", fmt.Errorf("%s must name a variable starting with %s", value, EnvPrefix)
+	}
+	return name, nil
+}
+
+func filePath(value, dir string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("no secrets directory configured for %s", value)
+	}
+	baseDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(value, SchemeFile)))
+	if !strings.HasPrefix(path, baseDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the secrets directory", value)
+	}
+	return path, nil
+}
+
+// Resolver turns secret references into the secrets they point to
+type Resolver struct {
+	provider SecretProvider // backs secret:// references, nil if none is configured
+	dir      string         // file:// references must be inside it
+}
+
+// NewResolver returns a resolver that looks up secret:// references with provider,
+// which may be nil when only env:// and file:// references are used, and reads
+// file:// references from dir
+func NewResolver(provider SecretProvider, dir string) *Resolver {
+	return &Resolver{provider: provider, dir: dir}
+}
+
+// Resolve returns the secret value references. Anything that isn't a reference is
+// returned as it is, so a nil resolver still works for plain credentials.
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, SchemeSecret):
+		name := strings.TrimPrefix(value, SchemeSecret)
+		if r == nil || r.provider == nil {
+			return "", fmt.Errorf("no secrets provider configured for %s", value)
+		}
+		secret, err := r.provider.Secret(ctx, name)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", value, err)
+		}
+		return secret, nil
+
+	case strings.HasPrefix(value, SchemeEnv):
+		name, err := envName(value)
+		if err != nil {
+			return "", err
+		}
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("failed to resolve %s: %w", value, ErrNotFound)
+		}
+		return secret, nil
+
+	case strings.HasPrefix(value, SchemeFile):
+		dir := ""
+		if r != nil {
+			dir = r.dir
+		}
+		path, err := filePath(value, dir)
+		if err != nil {
+			return "", err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", value, err)
+		}
+		return string(content), nil
+	}
+	return value, nil
+}
+
+// NewProvider returns the provider for secret:// references picked by cfg, nil if none
+// is configured. keys decrypt the files of the encrypted-file provider.
+func NewProvider(cfg config.SecretsConfig, keys *credentials.Keyring) (SecretProvider, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "file":
+		return NewFileProvider(cfg.Dir), nil
+	case "encrypted-file":
+		if keys == nil {
+			return nil, errors.New("the encrypted-file secrets provider needs a credentials master key")
+		}
+		return NewEncryptedFileProvider(cfg.Dir, keys), nil
+	case "vault":
+		if cfg.VaultAddr == "" {
+			return nil, errors.New("the vault secrets provider needs VAULT_ADDR")
+		}
+		return NewVaultProvider(cfg.VaultAddr, cfg.VaultToken, cfg.VaultMount), nil
+	}
+	return nil, fmt.Errorf("unknown secrets provider %q", cfg.Provider)
+}
@@ -0,0 +1,155 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"job-executor/internal/credentials"
+)
+
+func TestResolveReferences(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "key.pem"), []byte("from-file"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REMORA_SECRET_TEST_SSH_PASSWORD", "from-env")
+
+	resolver := NewResolver(NewFileProvider(dir), dir)
+	for value, want := range map[string]string{
+		"plain":                                 "plain",
+		"env://REMORA_SECRET_TEST_SSH_PASSWORD": "from-env",
+		"file://" + dir + "/key.pem":            "from-file",
+		"secret://key.pem":                      "from-file",
+	} {
+		got, err := resolver.Resolve(ctx, value)
+		if err != nil || got != want {
+			t.Errorf("Resolve(%q) = %q, %v, want %q", value, got, err, want)
+		}
+	}
+
+	for _, value := range []string{"env://REMORA_SECRET_TEST_UNSET", "secret://missing", "secret://../key.pem"} {
+		if _, err := resolver.Resolve(ctx, value); err == nil {
+			t.Errorf("Resolve(%q) should fail", value)
+		}
+	}
+	if _, err := NewResolver(nil, dir).Resolve(ctx, "secret://key.pem"); err == nil {
+		t.Error("secret:// references need a provider")
+	}
+}
+
+func TestEnvReferenceOutsidePrefix(t *testing.T) {
+	t.Setenv("TEST_SSH_PASSWORD", "from-env")
+	for _, value := range []string{"env://TEST_SSH_PASSWORD", "env://PATH", "env://REMORA_SECRET_"} {
+		if err := ValidateReference(value, ""); err == nil {
+			t.Errorf("ValidateReference(%q) should fail", value)
+		}
+		if _, err := NewResolver(nil, "").Resolve(context.Background(), value); err == nil {
+			t.Errorf("Resolve(%q) should fail", value)
+		}
+	}
+}
+
+func TestFileReferenceOutsideDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "secrets")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "outside.pem"), []byte("outside"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, value := range []string{
+		"file://" + root + "/outside.pem",
+		"file://" + dir + "/../outside.pem",
+		"file://" + dir,
+		"file://outside.pem",
+		"file:///etc/passwd",
+	} {
+		if err := ValidateReference(value, dir); err == nil {
+			t.Errorf("ValidateReference(%q) should fail", value)
+		}
+		if _, err := NewResolver(nil, dir).Resolve(context.Background(), value); err == nil {
+			t.Errorf("Resolve(%q) should fail", value)
+		}
+	}
+	if err := ValidateReference("file://"+dir+"/key.pem", ""); err == nil {
+		t.Error("file:// references need a secrets directory")
+	}
+}
+
+func TestEncryptedFileProvider(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	keys, err := credentials.ParseKeys(base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	provider := NewEncryptedFileProvider(dir, keys)
+	if err := provider.WriteEncryptedFile("prod/db", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "prod", "db"))
+	if !credentials.IsEncrypted(string(content)) {
+		t.Fatalf("secret file should be encrypted, got %q", content)
+	}
+
+	secret, err := provider.Secret(context.Background(), "prod/db")
+	if err != nil || secret != "hunter2" {
+		t.Fatalf("Secret = %q, %v", secret, err)
+	}
+	if _, err := provider.Secret(context.Background(), "prod/other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestVaultProvider(t *testing.T) {
+	// A stub of Vault's KV version 2 read endpoint
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodGet || r.URL.Path != "/v1/kv/data/servers/web-1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]interface{}{"value": "pa55", "private_key": "-----BEGIN KEY-----"},
+				"metadata": map[string]interface{}{"version": 3},
+			},
+		})
+	}))
+	defer stub.Close()
+
+	ctx := context.Background()
+	provider := NewVaultProvider(stub.URL, "test-token", "kv")
+	if secret, err := provider.Secret(ctx, "servers/web-1"); err != nil || secret != "pa55" {
+		t.Errorf("Secret = %q, %v", secret, err)
+	}
+	if secret, err := provider.Secret(ctx, "servers/web-1#private_key"); err != nil || secret != "-----BEGIN KEY-----" {
+		t.Errorf("Secret with field = %q, %v", secret, err)
+	}
+	if _, err := provider.Secret(ctx, "servers/web-1#missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing field, got %v", err)
+	}
+	if _, err := provider.Secret(ctx, "servers/web-2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing secret, got %v", err)
+	}
+	if _, err := NewVaultProvider(stub.URL, "wrong", "kv").Secret(ctx, "servers/web-1"); err == nil {
+		t.Error("expected a rejected token to fail")
+	}
+}
@@ -0,0 +1,58 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentSocket returns the path of the local ssh-agent's socket
+func agentSocket() (string, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return "", errors.New("SSH_AUTH_SOCK is not set, no ssh-agent to use")
+	}
+	return socket, nil
+}
+
+// agentAuth authenticates with the keys held by the local ssh-agent. The returned
+// connection to the agent has to stay open until the handshake is done.
+func agentAuth() (ssh.AuthMethod, net.Conn, error) {
+	socket, err := agentSocket()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to ssh-agent: %w", err)
+	}
+	return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), conn, nil
+}
+
+// forwardAgent lets sessions on conn that ask for it use the local ssh-agent
+func forwardAgent(conn *ssh.Client) error {
+	socket, err := agentSocket()
+	if err != nil {
+		return err
+	}
+	if err := agent.ForwardToRemote(conn, socket); err != nil {
+		return fmt.Errorf("failed to set up agent forwarding: %w", err)
+	}
+	return nil
+}
+
+// prepareSession asks the server to forward the agent to the session if the client
+// forwards it
+func (c *Client) prepareSession(session *ssh.Session) error {
+	if !c.config.ForwardAgent {
+		return nil
+	}
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		return fmt.Errorf("failed to request agent forwarding: %w", err)
+	}
+	return nil
+}
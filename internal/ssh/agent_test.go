@@ -0,0 +1,72 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"job-executor/internal/config"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAgentAuth(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Serve a keyring holding the key as the local ssh-agent
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: private}); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	addr := startTestServerWithConfig(t, &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	})
+	host, port, _ := net.SplitHostPort(addr)
+
+	client := NewClient(&config.SSHConfig{Host: host, Port: port, User: "test", TrustOnFirstUse: true, UseAgent: true})
+	conn, err := client.dial(context.Background())
+	if err != nil {
+		t.Fatalf("agent authentication failed: %v", err)
+	}
+	conn.Close()
+
+	client = NewClient(&config.SSHConfig{Host: host, Port: port, User: "test", TrustOnFirstUse: true})
+	if _, err := client.dial(context.Background()); err == nil {
+		t.Error("expected authentication to fail without the agent")
+	}
+}
@@ -0,0 +1,155 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// certificateClockSkew backdates minted certificates so hosts with a slightly slow
+// clock accept them
+const certificateClockSkew = time.Minute
+
+// CertificateAuthority mints short-lived OpenSSH user certificates, so servers that
+// trust the CA accept jobs without any key being stored for them
+type CertificateAuthority struct {
+	signer ssh.Signer
+}
+
+// LoadCertificateAuthority loads the CA's private key, given as PEM content or as the
+// path of a file holding it
+func LoadCertificateAuthority(key string) (*CertificateAuthority, error) {
+	content := []byte(key)
+	if !strings.HasPrefix(key, "-----BEGIN") {
+		var err error
+		if content, err = ioutil.ReadFile(key); err != nil {
+			return nil, fmt.Errorf("failed to read certificate authority key: %w", err)
+		}
+	}
+	signer, err := ssh.ParsePrivateKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate authority key: %w", err)
+	}
+	return &CertificateAuthority{signer: signer}, nil
+}
+
+// PublicKey returns the CA's public key in authorized_keys format, as servers list it
+// in TrustedUserCAKeys
+func (ca *CertificateAuthority) PublicKey() string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ca.signer.PublicKey())))
+}
+
+// Issue creates a fresh key pair and a certificate for it that lets principal log in
+// for validFor. keyID is logged by the server, so it should say what the login is for.
+func (ca *CertificateAuthority) Issue(keyID, principal string, validFor time.Duration, forwardAgent bool) (ssh.Signer, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: []string{principal},
+		ValidAfter:      uint64(now.Add(-certificateClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(validFor).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{"permit-pty": ""},
+		},
+	}
+	if forwardAgent {
+		cert.Permissions.Extensions["permit-agent-forwarding"] = ""
+	}
+	if err := cert.SignCert(rand.Reader, ca.signer); err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	return ssh.NewCertSigner(cert, signer)
+}
+
+// ParseCertificate parses an OpenSSH user certificate in authorized_keys format, as
+// found in id_ed25519-cert.pub
+func ParseCertificate(certificate string) (*ssh.Certificate, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certificate))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("not an SSH certificate, got a plain public key")
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, errors.New("not a user certificate")
+	}
+	return cert, nil
+}
+
+// SetCertificateAuthority lets the client mint its own certificate for servers with
+// certificate authentication but no stored certificate. keyID ends up in the server's
+// logs and the certificate is valid for validFor.
+func (c *Client) SetCertificateAuthority(ca *CertificateAuthority, keyID string, validFor time.Duration) {
+	c.ca = ca
+	c.certKeyID = keyID
+	c.certValidFor = validFor
+}
+
+// MintsCertificate reports whether connecting needs a certificate from the client's CA,
+// for the server or one of its jump hosts
+func (c *Client) MintsCertificate() bool {
+	if c.config.MintCertificate {
+		return true
+	}
+	for _, hop := range c.config.JumpHosts {
+		if hop.MintCertificate {
+			return true
+		}
+	}
+	return false
+}
+
+// certificateSigners puts the client's certificate in front of the keys: the stored
+// certificate wraps the key it was issued for, otherwise one is minted
+func (c *Client) certificateSigners(keys []ssh.Signer) ([]ssh.Signer, error) {
+	if c.config.Certificate != "" {
+		cert, err := ParseCertificate(c.config.Certificate)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			// NewCertSigner fails unless the certificate is for this key
+			if certSigner, err := ssh.NewCertSigner(cert, key); err == nil {
+				return append([]ssh.Signer{certSigner}, keys...), nil
+			}
+		}
+		return nil, errors.New("the certificate isn't for any of the server's private keys")
+	}
+
+	if !c.config.MintCertificate {
+		return keys, nil
+	}
+	if c.ca == nil {
+		return nil, errors.New("no certificate stored and no certificate authority configured")
+	}
+	minted, err := c.ca.Issue(c.certKeyID, c.config.User, c.certValidFor, c.config.ForwardAgent)
+	if err != nil {
+		return nil, err
+	}
+	return append([]ssh.Signer{minted}, keys...), nil
+}
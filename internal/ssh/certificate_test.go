@@ -0,0 +1,94 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"job-executor/internal/config"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCertificateAuth(t *testing.T) {
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(caKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := LoadCertificateAuthority(string(pem.EncodeToMemory(block)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server trusts certificates signed by the CA, as with TrustedUserCAKeys
+	var keyIDs []string
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), ca.signer.PublicKey().Marshal())
+		},
+	}
+	addr := startTestServerWithConfig(t, &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			permissions, err := checker.Authenticate(conn, key)
+			if err == nil {
+				keyIDs = append(keyIDs, key.(*ssh.Certificate).KeyId)
+			}
+			return permissions, err
+		},
+	})
+	host, port, _ := net.SplitHostPort(addr)
+
+	// A certificate minted for the job
+	client := NewClient(&config.SSHConfig{Host: host, Port: port, User: "deploy", TrustOnFirstUse: true, MintCertificate: true})
+	client.SetCertificateAuthority(ca, "job-1", time.Minute)
+	conn, err := client.dial(context.Background())
+	if err != nil {
+		t.Fatalf("minted certificate was rejected: %v", err)
+	}
+	conn.Close()
+	if len(keyIDs) != 1 || keyIDs[0] != "job-1" {
+		t.Errorf("expected the server to see key ID job-1, got %v", keyIDs)
+	}
+
+	// A stored certificate together with the key it was issued for
+	_, userKey, _ := ed25519.GenerateKey(rand.Reader)
+	userSigner, _ := ssh.NewSignerFromKey(userKey)
+	userBlock, _ := ssh.MarshalPrivateKey(userKey, "")
+	cert := &ssh.Certificate{
+		Key:             userSigner.PublicKey(),
+		CertType:        ssh.UserCert,
+		KeyId:           "stored",
+		ValidPrincipals: []string{"deploy"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca.signer); err != nil {
+		t.Fatal(err)
+	}
+	stored := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert)))
+
+	client = NewClient(&config.SSHConfig{
+		Host: host, Port: port, User: "deploy", TrustOnFirstUse: true,
+		PrivateKey: string(pem.EncodeToMemory(userBlock)), Certificate: stored,
+	})
+	conn, err = client.dial(context.Background())
+	if err != nil {
+		t.Fatalf("stored certificate was rejected: %v", err)
+	}
+	conn.Close()
+
+	// Without a CA there's nothing to authenticate with
+	client = NewClient(&config.SSHConfig{Host: host, Port: port, User: "deploy", TrustOnFirstUse: true, MintCertificate: true})
+	if _, err := client.dial(context.Background()); err == nil {
+		t.Error("expected minting without a certificate authority to fail")
+	}
+}
@@ -5,8 +5,9 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"job-executor/internal/config"
+	"job-executor/internal/credentials"
+	"job-executor/internal/secrets"
 	"job-executor/internal/storage"
 	"strings"
 	"sync"
@@ -18,6 +19,21 @@ import (
 type Client struct {
 	config  *config.SSHConfig
 	storage storage.StorageService
+	pool    *Pool     // shared connections, nil dials per command
+	poolKey string    // server ID the pooled connections are kept under
+	version time.Time // server's updated_at, a change replaces pooled connections
+
+	ca           *CertificateAuthority // mints certificates when none is stored
+	certKeyID    string
+	certValidFor time.Duration
+
+	keys    *credentials.Keyring // decrypts the password and private keys
+	secrets *secrets.Resolver    // resolves credentials that reference a secret
+
+	mu      sync.Mutex
+	hostKey string // fingerprint of the host key seen on the last connection
+
+	jumpHostKeys []string // host keys the jump hosts presented on the last connection
 }
 
 type ExecutionResult struct {
@@ -43,78 +59,67 @@ func NewClientWithStorage(cfg *config.SSHConfig, storage storage.StorageService)
 	return &Client{config: cfg, storage: storage}
 }
 
-func (c *Client) Execute(ctx context.Context, command string, timeout time.Duration) (*ExecutionResult, error) {
-	startTime := time.Now()
-	
-	// Create SSH client config
-	sshConfig := &ssh.ClientConfig{
-		User:            c.config.User,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // Note: Use proper host key checking in production
-		Timeout:         10 * time.Second,
-	}
-
-	// Configure authentication
-	if c.config.Password != "" {
-		sshConfig.Auth = append(sshConfig.Auth, ssh.Password(c.config.Password))
-	}
+// SetPool makes the client run commands over pooled connections to the server with
+// the given ID. updatedAt is the server's last update, so edits to it evict the
+// connections opened before them.
+func (c *Client) SetPool(pool *Pool, serverID string, updatedAt time.Time) {
+	c.pool = pool
+	c.poolKey = serverID
+	c.version = updatedAt
+}
 
-	if c.config.PrivateKey != "" {
-		var key []byte
-		var err error
-		
-		// Check if it's a file path or the key content itself
-		if strings.HasPrefix(c.config.PrivateKey, "-----BEGIN") {
-			// It's the actual key content
-			key = []byte(c.config.PrivateKey)
-		} else {
-			// It's a file path
-			key, err = ioutil.ReadFile(c.config.PrivateKey)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read private key file: %w", err)
-			}
-		}
+// SetKeyring gives the client the master keys to decrypt encrypted passwords and
+// private keys with. They are only decrypted while connecting.
+func (c *Client) SetKeyring(keys *credentials.Keyring) {
+	c.keys = keys
+}
 
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse private key: %w", err)
-		}
+// SetSecrets gives the client the resolver for credentials stored as references to
+// secrets. They are looked up while connecting.
+func (c *Client) SetSecrets(resolver *secrets.Resolver) {
+	c.secrets = resolver
+}
 
-		sshConfig.Auth = append(sshConfig.Auth, ssh.PublicKeys(signer))
+// credential returns the plaintext of a stored credential, decrypting it and then
+// looking it up if it's a reference to a secret
+func (c *Client) credential(ctx context.Context, value string) (string, error) {
+	value, err := c.keys.Decrypt(value)
+	if err != nil {
+		return "", err
 	}
+	return c.secrets.Resolve(ctx, value)
+}
 
-	// Handle PEM file URL (download from object storage)
-	if c.config.PemFileURL != "" {
-		if c.storage == nil {
-			return nil, fmt.Errorf("storage service not available for PEM file URL")
-		}
-
-		key, err := c.storage.DownloadPemFile(ctx, c.config.PemFileURL)
-		if err != nil {
-			return nil, fmt.Errorf("failed to download PEM file from storage: %w", err)
-		}
-
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse downloaded PEM file: %w", err)
-		}
-
-		sshConfig.Auth = append(sshConfig.Auth, ssh.PublicKeys(signer))
+// fingerprint identifies the settings pooled connections are opened with, the jump
+// hosts' included. Agent forwarding isn't one of them, see pooledKey.
+func (c *Client) fingerprint() string {
+	parts := []string{c.version.UTC().Format(time.RFC3339Nano)}
+	for _, cfg := range append(append([]config.SSHConfig(nil), c.config.JumpHosts...), *c.config) {
+		parts = append(parts, cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.PrivateKey, cfg.PemFileURL,
+			strings.Join(cfg.HostKeys, ","), fmt.Sprint(cfg.UseAgent), cfg.Certificate)
 	}
+	return settingsFingerprint(parts...)
+}
 
-	// Connect to SSH server
-	addr := fmt.Sprintf("%s:%s", c.config.Host, c.config.Port)
-	conn, err := ssh.Dial("tcp", addr, sshConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to SSH server at %s: %w", addr, err)
+// pooledKey is the key the client's pooled connections are kept under. Connections
+// forwarding the agent are kept apart from the others, as forwarding is set up per
+// connection, so jobs with and without it don't replace each other's connections.
+func (c *Client) pooledKey() string {
+	if c.config.ForwardAgent {
+		return c.poolKey + "/fwd"
 	}
-	defer conn.Close()
+	return c.poolKey
+}
 
-	// Create session
-	session, err := conn.NewSession()
+func (c *Client) Execute(ctx context.Context, command string, timeout time.Duration) (*ExecutionResult, error) {
+	startTime := time.Now()
+	
+	// Open a session, over a pooled connection when the client has a pool
+	session, release, err := c.newSession(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+		return nil, err
 	}
-	defer session.Close()
+	defer release()
 
 	// Setup output buffers with larger initial capacity for better performance
 	var stdout, stderr bytes.Buffer
@@ -157,7 +162,7 @@ func (c *Client) Execute(ctx context.Context, command string, timeout time.Durat
 	case <-time.After(timeout):
 		session.Signal(ssh.SIGKILL)
 		executionTime := time.Since(startTime)
-		return nil, fmt.Errorf("command execution timeout after %v (limit: %v)", executionTime, timeout)
+		return nil, fmt.Errorf("command execution %w after %v (limit: %v)", ErrTimeout, executionTime, timeout)
 	}
 }
 
@@ -165,72 +170,12 @@ func (c *Client) Execute(ctx context.Context, command string, timeout time.Durat
 func (c *Client) ExecuteStreaming(ctx context.Context, command string, timeout time.Duration, callback StreamingCallback) (*StreamingResult, error) {
 	startTime := time.Now()
 	
-	// Create SSH client config
-	sshConfig := &ssh.ClientConfig{
-		User:            c.config.User,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
-	}
-
-	// Configure authentication
-	if c.config.Password != "" {
-		sshConfig.Auth = append(sshConfig.Auth, ssh.Password(c.config.Password))
-	}
-
-	if c.config.PrivateKey != "" {
-		var key []byte
-		var err error
-		
-		if strings.HasPrefix(c.config.PrivateKey, "-----BEGIN") {
-			key = []byte(c.config.PrivateKey)
-		} else {
-			key, err = ioutil.ReadFile(c.config.PrivateKey)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read private key file: %w", err)
-			}
-		}
-
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse private key: %w", err)
-		}
-
-		sshConfig.Auth = append(sshConfig.Auth, ssh.PublicKeys(signer))
-	}
-
-	// Handle PEM file URL (download from object storage)
-	if c.config.PemFileURL != "" {
-		if c.storage == nil {
-			return nil, fmt.Errorf("storage service not available for PEM file URL")
-		}
-
-		key, err := c.storage.DownloadPemFile(ctx, c.config.PemFileURL)
-		if err != nil {
-			return nil, fmt.Errorf("failed to download PEM file from storage: %w", err)
-		}
-
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse downloaded PEM file: %w", err)
-		}
-
-		sshConfig.Auth = append(sshConfig.Auth, ssh.PublicKeys(signer))
-	}
-
-	// Connect to SSH server
-	addr := fmt.Sprintf("%s:%s", c.config.Host, c.config.Port)
-	conn, err := ssh.Dial("tcp", addr, sshConfig)
+	// Open a session, over a pooled connection when the client has a pool
+	session, release, err := c.newSession(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to SSH server at %s: %w", addr, err)
+		return nil, err
 	}
-	defer conn.Close()
-
-	// Create session
-	session, err := conn.NewSession()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create SSH session: %w", err)
-	}
-	defer session.Close()
+	defer release()
 
 	// Create pipes for stdout and stderr
 	stdoutPipe, err := session.StdoutPipe()
@@ -406,77 +351,24 @@ func (c *Client) ExecuteStreaming(ctx context.Context, command string, timeout t
 	case <-time.After(timeout):
 		session.Signal(ssh.SIGKILL)
 		executionTime := time.Since(startTime)
-		result.Error = fmt.Errorf("command execution timeout after %v (limit: %v)", executionTime, timeout)
+		result.Error = fmt.Errorf("command execution %w after %v (limit: %v)", ErrTimeout, executionTime, timeout)
 		return result, result.Error
 	}
 }
 
 // TestConnection tests the SSH connection without executing any commands
 func (c *Client) TestConnection(ctx context.Context) error {
-	// Create SSH client config
-	sshConfig := &ssh.ClientConfig{
-		User:            c.config.User,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
-	}
-
-	// Configure authentication
-	if c.config.Password != "" {
-		sshConfig.Auth = append(sshConfig.Auth, ssh.Password(c.config.Password))
-	}
-
-	if c.config.PrivateKey != "" {
-		var key []byte
-		var err error
-		
-		if strings.HasPrefix(c.config.PrivateKey, "-----BEGIN") {
-			key = []byte(c.config.PrivateKey)
-		} else {
-			key, err = ioutil.ReadFile(c.config.PrivateKey)
-			if err != nil {
-				return fmt.Errorf("failed to read private key file: %w", err)
-			}
-		}
-
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			return fmt.Errorf("failed to parse private key: %w", err)
-		}
-
-		sshConfig.Auth = append(sshConfig.Auth, ssh.PublicKeys(signer))
-	}
-
-	// Handle PEM file URL (download from object storage)
-	if c.config.PemFileURL != "" {
-		if c.storage == nil {
-			return fmt.Errorf("storage service not available for PEM file URL")
-		}
-
-		key, err := c.storage.DownloadPemFile(ctx, c.config.PemFileURL)
-		if err != nil {
-			return fmt.Errorf("failed to download PEM file from storage: %w", err)
-		}
-
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			return fmt.Errorf("failed to parse downloaded PEM file: %w", err)
-		}
-
-		sshConfig.Auth = append(sshConfig.Auth, ssh.PublicKeys(signer))
-	}
-
-	// Connect to SSH server
-	addr := fmt.Sprintf("%s:%s", c.config.Host, c.config.Port)
-	conn, err := ssh.Dial("tcp", addr, sshConfig)
+	// Always dial, so the test checks the current credentials rather than a pooled connection
+	conn, err := c.dial(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to connect to SSH server at %s: %w", addr, err)
+		return err
 	}
 	defer conn.Close()
 
 	// Test creating a session
 	session, err := conn.NewSession()
 	if err != nil {
-		return fmt.Errorf("failed to create SSH session: %w", err)
+		return fmt.Errorf("%w: %w", ErrSession, err)
 	}
 	defer session.Close()
 
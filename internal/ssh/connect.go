@@ -0,0 +1,280 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Failures worth telling apart when deciding whether to try again. Check for them with
+// errors.Is, the messages carry the details.
+var (
+	// ErrConnect is returned when the server, or a jump host on the way, couldn't be reached
+	ErrConnect = errors.New("failed to connect to SSH server")
+	// ErrAuth is returned when the server rejected every credential offered
+	ErrAuth = errors.New("SSH authentication failed")
+	// ErrSession is returned when a connection was made but no channel could be opened on it
+	ErrSession = errors.New("failed to create SSH session")
+	// ErrTimeout is returned when a command or transfer ran past its time limit
+	ErrTimeout = errors.New("timeout")
+)
+
+// clientConfig builds the SSH client config with every authentication method the
+// server has credentials for. The returned func releases what authentication needs
+// during the handshake, such as the connection to the ssh-agent.
+func (c *Client) clientConfig(ctx context.Context) (*ssh.ClientConfig, func(), error) {
+	sshConfig := &ssh.ClientConfig{
+		User:            c.config.User,
+		HostKeyCallback: c.hostKeyCallback(),
+		Timeout:         10 * time.Second,
+	}
+
+	// Configure authentication
+	if c.config.Password != "" {
+		password, err := c.credential(ctx, c.config.Password)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get password: %w", err)
+		}
+		sshConfig.Auth = append(sshConfig.Auth, ssh.Password(password))
+	}
+
+	// Keys are collected first, a certificate has to be matched up with its key
+	var signers []ssh.Signer
+	if c.config.PrivateKey != "" {
+		var key []byte
+		privateKey, err := c.credential(ctx, c.config.PrivateKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get private key: %w", err)
+		}
+
+		// Check if it's a file path or the key content itself
+		if strings.HasPrefix(privateKey, "-----BEGIN") {
+			key = []byte(privateKey)
+		} else {
+			key, err = ioutil.ReadFile(privateKey)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read private key file: %w", err)
+			}
+		}
+
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+
+		signers = append(signers, signer)
+	}
+
+	// Handle PEM file URL (download from object storage)
+	if c.config.PemFileURL != "" {
+		if c.storage == nil {
+			return nil, nil, fmt.Errorf("storage service not available for PEM file URL")
+		}
+
+		key, err := c.storage.DownloadPemFile(ctx, c.config.PemFileURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to download PEM file from storage: %w", err)
+		}
+
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse downloaded PEM file: %w", err)
+		}
+
+		signers = append(signers, signer)
+	}
+
+	signers, err := c.certificateSigners(signers)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(signers) > 0 {
+		sshConfig.Auth = append(sshConfig.Auth, ssh.PublicKeys(signers...))
+	}
+
+	// Sign with the keys of the local ssh-agent
+	release := func() {}
+	if c.config.UseAgent {
+		method, agentConn, err := agentAuth()
+		if err != nil {
+			return nil, nil, err
+		}
+		sshConfig.Auth = append(sshConfig.Auth, method)
+		release = func() { agentConn.Close() }
+	}
+
+	return sshConfig, release, nil
+}
+
+// dial opens a new authenticated connection to the server, through its jump hosts
+// if it has any. The keys the jump hosts presented are kept for JumpHostKeys.
+func (c *Client) dial(ctx context.Context) (*ssh.Client, error) {
+	var hops []*ssh.Client
+	closeHops := func() {
+		for i := len(hops) - 1; i >= 0; i-- {
+			hops[i].Close()
+		}
+	}
+	hopKeys := make([]string, 0, len(c.config.JumpHosts))
+	defer func() {
+		c.mu.Lock()
+		c.jumpHostKeys = hopKeys
+		c.mu.Unlock()
+	}()
+
+	// Each hop is reached over a channel of the connection to the one before it
+	var through *ssh.Client
+	for i := range c.config.JumpHosts {
+		hop := &Client{config: &c.config.JumpHosts[i], storage: c.storage, ca: c.ca, certKeyID: c.certKeyID, certValidFor: c.certValidFor, keys: c.keys, secrets: c.secrets}
+		conn, err := hop.connect(ctx, through)
+		if err != nil {
+			closeHops()
+			return nil, fmt.Errorf("jump host %d of %d: %w", i+1, len(c.config.JumpHosts), err)
+		}
+		hops = append(hops, conn)
+		hopKeys = append(hopKeys, hop.HostKey())
+		through = conn
+	}
+
+	conn, err := c.connect(ctx, through)
+	if err != nil {
+		closeHops()
+		return nil, err
+	}
+	if c.config.ForwardAgent {
+		if err := forwardAgent(conn); err != nil {
+			conn.Close()
+			closeHops()
+			return nil, err
+		}
+	}
+	if len(hops) > 0 {
+		// The jump host connections live as long as the one through them
+		go func() {
+			conn.Wait()
+			closeHops()
+		}()
+	}
+	return conn, nil
+}
+
+// connect does the SSH handshake with the server, directly or, when through is given,
+// over a connection to a jump host
+func (c *Client) connect(ctx context.Context, through *ssh.Client) (*ssh.Client, error) {
+	sshConfig, release, err := c.clientConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	addr := fmt.Sprintf("%s:%s", c.config.Host, c.config.Port)
+	var conn *ssh.Client
+	if through == nil {
+		conn, err = ssh.Dial("tcp", addr, sshConfig)
+	} else {
+		conn, err = dialThrough(through, addr, sshConfig)
+	}
+	if err != nil {
+		if IsHostKeyError(err) {
+			return nil, fmt.Errorf("host key verification failed for %s: %w", addr, err)
+		}
+		if isAuthError(err) {
+			return nil, fmt.Errorf("%w at %s: %w", ErrAuth, addr, err)
+		}
+		return nil, fmt.Errorf("%w at %s: %w", ErrConnect, addr, err)
+	}
+	return conn, nil
+}
+
+// isAuthError reports whether a handshake failed because no credential was accepted.
+// The ssh package has no error type for it, only this message.
+func isAuthError(err error) bool {
+	return strings.Contains(err.Error(), "unable to authenticate")
+}
+
+// dialThrough opens an SSH connection to addr tunneled through another connection
+func dialThrough(through *ssh.Client, addr string, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	netConn, err := through.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(netConn, addr, sshConfig)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshConn, channels, requests), nil
+}
+
+// newSession opens a session for one command. With a pool the session runs over a
+// shared connection to the server, otherwise over a connection of its own. The
+// returned func closes the session and gives the connection back.
+func (c *Client) newSession(ctx context.Context) (*ssh.Session, func(), error) {
+	var session *ssh.Session
+	release, err := c.openChannel(ctx, func(conn *ssh.Client) (io.Closer, error) {
+		var err error
+		if session, err = conn.NewSession(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrSession, err)
+		}
+		return session, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.prepareSession(session); err != nil {
+		release()
+		return nil, nil, err
+	}
+	return session, release, nil
+}
+
+// openChannel calls open with a connection to the server, a pooled one when the client
+// has a pool. A failing open is taken for a broken connection. The returned func closes
+// what open opened and gives the connection back.
+func (c *Client) openChannel(ctx context.Context, open func(*ssh.Client) (io.Closer, error)) (func(), error) {
+	if c.pool == nil {
+		conn, err := c.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		channel, err := open(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return func() {
+			channel.Close()
+			conn.Close()
+		}, nil
+	}
+
+	dial := func() (*ssh.Client, error) { return c.dial(ctx) }
+	key, fingerprint := c.pooledKey(), c.fingerprint()
+
+	// A pooled connection may have died since its last health check, so retry once
+	// on a fresh one before giving up
+	for attempt := 1; ; attempt++ {
+		conn, err := c.pool.acquire(key, fingerprint, dial)
+		if err != nil {
+			return nil, err
+		}
+		channel, err := open(conn.client)
+		if err != nil {
+			c.pool.release(key, conn, true)
+			if attempt < 2 {
+				continue
+			}
+			return nil, err
+		}
+		return func() {
+			channel.Close()
+			c.pool.release(key, conn, false)
+		}, nil
+	}
+}
@@ -0,0 +1,37 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"job-executor/internal/config"
+)
+
+func TestDialErrors(t *testing.T) {
+	addr := startTestServerWithConfig(t, &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, errors.New("wrong password")
+		},
+	})
+	host, port, _ := net.SplitHostPort(addr)
+	_, err := NewClient(&config.SSHConfig{Host: host, Port: port, User: "test", Password: "guess", TrustOnFirstUse: true}).dial(context.Background())
+	if !errors.Is(err, ErrAuth) || errors.Is(err, ErrConnect) {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+
+	// Nothing listens on a port that was just closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ = net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+	_, err = NewClient(&config.SSHConfig{Host: "127.0.0.1", Port: port, User: "test", Password: "guess", TrustOnFirstUse: true}).dial(context.Background())
+	if !errors.Is(err, ErrConnect) {
+		t.Errorf("expected a connection error, got %v", err)
+	}
+}
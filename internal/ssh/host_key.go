@@ -0,0 +1,69 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrHostKeyMismatch is returned when a server presents a host key other than the
+// ones pinned for it, which may mean the connection is being intercepted
+var ErrHostKeyMismatch = errors.New("host key mismatch")
+
+// ErrHostKeyNotPinned is returned when a server has no pinned host key and keys
+// aren't trusted on first use
+var ErrHostKeyNotPinned = errors.New("no host key pinned")
+
+// IsHostKeyError reports whether err is a failed host key verification. Such
+// failures are never worth retrying.
+func IsHostKeyError(err error) bool {
+	return errors.Is(err, ErrHostKeyMismatch) || errors.Is(err, ErrHostKeyNotPinned)
+}
+
+// hostKeyCallback checks the server's host key against the pinned fingerprints. With
+// none pinned and trust on first use, the key is accepted and remembered so the
+// caller can pin it.
+func (c *Client) hostKeyCallback() ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		if len(c.config.HostKeys) == 0 {
+			if !c.config.TrustOnFirstUse {
+				return fmt.Errorf("%w for %s, test the server connection to pin its key", ErrHostKeyNotPinned, hostname)
+			}
+			c.mu.Lock()
+			c.hostKey = fingerprint
+			c.mu.Unlock()
+			return nil
+		}
+
+		for _, pinned := range c.config.HostKeys {
+			if pinned == fingerprint {
+				c.mu.Lock()
+				c.hostKey = fingerprint
+				c.mu.Unlock()
+				return nil
+			}
+		}
+		return fmt.Errorf("%w for %s: server offered %s %s, pinned %s",
+			ErrHostKeyMismatch, hostname, key.Type(), fingerprint, strings.Join(c.config.HostKeys, ", "))
+	}
+}
+
+// HostKey returns the fingerprint of the host key the server presented, empty if
+// the client hasn't connected yet
+func (c *Client) HostKey() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hostKey
+}
+
+// JumpHostKeys returns the fingerprints of the host keys the jump hosts presented, in
+// the order of the config's JumpHosts. Hops the last connection didn't get to are missing.
+func (c *Client) JumpHostKeys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.jumpHostKeys...)
+}
@@ -0,0 +1,77 @@
+package ssh
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"job-executor/internal/config"
+)
+
+func TestHostKeyVerification(t *testing.T) {
+	addr := startTestServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	newClient := func(hostKeys []string, tofu bool) *Client {
+		return NewClient(&config.SSHConfig{Host: host, Port: port, User: "test", HostKeys: hostKeys, TrustOnFirstUse: tofu})
+	}
+
+	// Without a pinned key the first connection learns it
+	first := newClient(nil, true)
+	conn, err := first.dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	pinned := first.HostKey()
+	if pinned == "" {
+		t.Fatal("expected the host key to be recorded on first use")
+	}
+
+	conn, err = newClient([]string{"SHA256:other", pinned}, false).dial(context.Background())
+	if err != nil {
+		t.Fatalf("pinned key rejected: %v", err)
+	}
+	conn.Close()
+
+	if _, err := newClient([]string{"SHA256:other"}, true).dial(context.Background()); !IsHostKeyError(err) {
+		t.Errorf("expected a host key mismatch, got %v", err)
+	}
+	if _, err := newClient(nil, false).dial(context.Background()); !IsHostKeyError(err) {
+		t.Errorf("expected an error for a server without a pinned key, got %v", err)
+	}
+}
+
+func TestDialThroughJumpHosts(t *testing.T) {
+	jumpHost, jumpPort, _ := net.SplitHostPort(startTestServer(t))
+	host, port, _ := net.SplitHostPort(startTestServer(t))
+
+	hop := config.SSHConfig{Host: jumpHost, Port: jumpPort, User: "jump", TrustOnFirstUse: true}
+	client := NewClient(&config.SSHConfig{
+		Host:            host,
+		Port:            port,
+		User:            "test",
+		TrustOnFirstUse: true,
+		JumpHosts:       []config.SSHConfig{hop, hop},
+	})
+	conn, err := client.dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := keepalive(conn); err != nil {
+		t.Fatalf("connection through jump hosts doesn't work: %v", err)
+	}
+
+	// The keys the jump hosts presented on first use are there to pin
+	keys := client.JumpHostKeys()
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] || keys[0] == client.HostKey() {
+		t.Errorf("expected the jump host's key for both hops, got %v (server %s)", keys, client.HostKey())
+	}
+
+	// A jump host that fails verification stops the connection
+	hop.HostKeys = []string{"SHA256:other"}
+	client = NewClient(&config.SSHConfig{Host: host, Port: port, User: "test", TrustOnFirstUse: true, JumpHosts: []config.SSHConfig{hop}})
+	if _, err := client.dial(context.Background()); !IsHostKeyError(err) {
+		t.Errorf("expected a host key error from the jump host, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package ssh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultMaxSessions    = 10 // OpenSSH's default MaxSessions
+	defaultIdleTimeout    = 5 * time.Minute
+	defaultHealthInterval = 30 * time.Second
+	keepaliveTimeout      = 5 * time.Second
+)
+
+// Pool keeps authenticated connections open per server so jobs only pay for a new
+// session instead of a full handshake. A connection carries up to maxSessions
+// sessions at once, more are spread over extra connections. Connections are
+// replaced once their server's settings change, and idle ones are health-checked
+// and closed after idleTimeout.
+type Pool struct {
+	mu             sync.Mutex
+	conns          map[string][]*pooledConn // by server ID
+	maxSessions    int
+	idleTimeout    time.Duration
+	healthInterval time.Duration
+}
+
+type pooledConn struct {
+	client      *ssh.Client
+	fingerprint string // of the settings the connection was opened with
+	sessions    int
+	lastUsed    time.Time
+	retired     bool // no longer handed out, closed once its last session ends
+}
+
+func NewPool() *Pool {
+	return &Pool{
+		conns:          make(map[string][]*pooledConn),
+		maxSessions:    defaultMaxSessions,
+		idleTimeout:    defaultIdleTimeout,
+		healthInterval: defaultHealthInterval,
+	}
+}
+
+// SetMaxSessions configures how many sessions share one connection
+func (p *Pool) SetMaxSessions(n int) {
+	if n < 1 {
+		n = 1
+	}
+	p.maxSessions = n
+}
+
+// SetIdleTimeout configures how long an unused connection stays open
+func (p *Pool) SetIdleTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultIdleTimeout
+	}
+	p.idleTimeout = timeout
+}
+
+// SetHealthInterval configures how often idle connections are checked
+func (p *Pool) SetHealthInterval(interval time.Duration) {
+	if interval < time.Second {
+		interval = time.Second
+	}
+	p.healthInterval = interval
+}
+
+// Start health-checks idle connections until ctx is done, then closes the pool
+func (p *Pool) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			p.Close()
+			return
+		case <-ticker.C:
+			p.checkIdle()
+		}
+	}
+}
+
+// acquire reserves a session slot on a connection for key, dialing a new connection
+// if none with the same settings has room
+func (p *Pool) acquire(key, fingerprint string, dial func() (*ssh.Client, error)) (*pooledConn, error) {
+	p.mu.Lock()
+	for _, conn := range p.conns[key] {
+		if conn.fingerprint != fingerprint {
+			// The server was updated since this connection was opened
+			p.retireLocked(key, conn)
+			continue
+		}
+		if conn.sessions < p.maxSessions {
+			conn.sessions++
+			conn.lastUsed = time.Now()
+			p.mu.Unlock()
+			return conn, nil
+		}
+	}
+	p.mu.Unlock()
+
+	client, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	conn := &pooledConn{client: client, fingerprint: fingerprint, sessions: 1, lastUsed: time.Now()}
+
+	p.mu.Lock()
+	p.conns[key] = append(p.conns[key], conn)
+	p.mu.Unlock()
+
+	slog.Debug("Opened pooled SSH connection", "server_id", key)
+	return conn, nil
+}
+
+// release gives back a session slot. A broken connection is dropped from the pool.
+func (p *Pool) release(key string, conn *pooledConn, broken bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conn.sessions--
+	conn.lastUsed = time.Now()
+	if broken {
+		p.retireLocked(key, conn)
+	}
+	if conn.retired && conn.sessions == 0 {
+		conn.client.Close()
+	}
+}
+
+// Close closes every idle connection and retires the busy ones
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, conns := range p.conns {
+		for _, conn := range append([]*pooledConn(nil), conns...) {
+			p.retireLocked(key, conn)
+		}
+	}
+}
+
+// retireLocked takes a connection out of the pool, closing it right away if it's idle
+func (p *Pool) retireLocked(key string, conn *pooledConn) {
+	if conn.retired {
+		return
+	}
+	conn.retired = true
+
+	conns := p.conns[key]
+	for i, c := range conns {
+		if c == conn {
+			conns = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(conns) == 0 {
+		delete(p.conns, key)
+	} else {
+		p.conns[key] = conns
+	}
+
+	if conn.sessions == 0 {
+		conn.client.Close()
+	}
+}
+
+// checkIdle closes connections that have been idle too long and sends a keepalive
+// over the others, dropping any that don't answer
+func (p *Pool) checkIdle() {
+	type idleConn struct {
+		key  string
+		conn *pooledConn
+	}
+	var idle []idleConn
+
+	p.mu.Lock()
+	now := time.Now()
+	for key, conns := range p.conns {
+		for _, conn := range append([]*pooledConn(nil), conns...) {
+			if conn.sessions > 0 {
+				continue
+			}
+			if now.Sub(conn.lastUsed) >= p.idleTimeout {
+				p.retireLocked(key, conn)
+				continue
+			}
+			idle = append(idle, idleConn{key, conn})
+		}
+	}
+	p.mu.Unlock()
+
+	// Keepalives go out without the lock, a slow server shouldn't hold up jobs
+	for _, c := range idle {
+		if err := keepalive(c.conn.client); err != nil {
+			slog.Info("Dropping unhealthy pooled SSH connection", "server_id", c.key, "error", err)
+			p.mu.Lock()
+			p.retireLocked(c.key, c.conn)
+			p.mu.Unlock()
+		}
+	}
+}
+
+// keepalive checks that the server still answers on a connection
+func keepalive(client *ssh.Client) error {
+	errc := make(chan error, 1)
+	go func() {
+		// Servers reject the unknown request, but any reply shows the connection works
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		errc <- err
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(keepaliveTimeout):
+		return errors.New("keepalive timed out")
+	}
+}
+
+// settingsFingerprint identifies the settings a connection is opened with, so a
+// changed server gets a new connection
+func settingsFingerprint(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
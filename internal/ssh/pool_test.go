@@ -0,0 +1,164 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"job-executor/internal/config"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startTestServer runs an SSH server that accepts anyone, answers every request and
+// forwards TCP connections, so it can serve as a jump host
+func startTestServer(t *testing.T) string {
+	return startTestServerWithConfig(t, &ssh.ServerConfig{NoClientAuth: true})
+}
+
+// startTestServerWithConfig runs the test server with its own authentication settings
+func startTestServerWithConfig(t *testing.T, serverConfig *ssh.ServerConfig) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for channel := range channels {
+					if channel.ChannelType() != "direct-tcpip" {
+						channel.Reject(ssh.Prohibited, "no sessions in tests")
+						continue
+					}
+					go forward(channel)
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestPoolReusesAndReplacesConnections(t *testing.T) {
+	addr := startTestServer(t)
+	dials := 0
+	dial := func() (*ssh.Client, error) {
+		dials++
+		return ssh.Dial("tcp", addr, &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	}
+
+	pool := NewPool()
+	pool.SetMaxSessions(2)
+	defer pool.Close()
+
+	first, err := pool.acquire("server", "v1", dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := pool.acquire("server", "v1", dial)
+	if first != second || dials != 1 {
+		t.Fatalf("expected both sessions on one connection, got %d dials", dials)
+	}
+
+	// The connection is full, so a third session gets a connection of its own
+	third, _ := pool.acquire("server", "v1", dial)
+	if third == first || dials != 2 {
+		t.Fatalf("expected a second connection, got %d dials", dials)
+	}
+	pool.release("server", first, false)
+	pool.release("server", second, false)
+	pool.release("server", third, false)
+
+	if err := keepalive(first.client); err != nil {
+		t.Fatalf("keepalive on a healthy connection: %v", err)
+	}
+
+	// Changed settings retire the old connections
+	updated, _ := pool.acquire("server", "v2", dial)
+	if updated == first || !first.retired || !third.retired || dials != 3 {
+		t.Fatalf("expected old connections to be replaced, got %d dials", dials)
+	}
+	if err := keepalive(first.client); err == nil {
+		t.Error("retired idle connection should be closed")
+	}
+
+	// A broken connection is dropped instead of being handed out again
+	pool.release("server", updated, true)
+	if len(pool.conns["server"]) != 0 {
+		t.Errorf("expected no pooled connections, got %d", len(pool.conns["server"]))
+	}
+}
+
+func TestPoolKeepsAgentForwardingApart(t *testing.T) {
+	plain := NewClient(&config.SSHConfig{Host: "example.com", Port: "22", User: "deploy"})
+	forwarding := NewClient(&config.SSHConfig{Host: "example.com", Port: "22", User: "deploy", ForwardAgent: true})
+	plain.SetPool(NewPool(), "server", time.Time{})
+	forwarding.SetPool(plain.pool, "server", time.Time{})
+
+	// Jobs alternating agent forwarding use connections of their own, without
+	// retiring each other's
+	if plain.pooledKey() == forwarding.pooledKey() {
+		t.Errorf("expected different pool keys, both got %q", plain.pooledKey())
+	}
+	if plain.fingerprint() != forwarding.fingerprint() {
+		t.Error("agent forwarding shouldn't change the settings fingerprint")
+	}
+}
+
+// forward connects a direct-tcpip channel to the address it asks for
+func forward(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		io.Copy(conn, channel)
+		conn.Close()
+	}()
+	go func() {
+		io.Copy(channel, conn)
+		channel.Close()
+	}()
+}
@@ -0,0 +1,136 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// FileTransfer is an SFTP session with the server, to copy a job's input files to it
+// and its artifacts back
+type FileTransfer struct {
+	client  *sftp.Client
+	release func()
+	stop    func() bool
+	once    sync.Once
+}
+
+// sftpSession closes the SFTP client along with the session it runs in
+type sftpSession struct {
+	client  *sftp.Client
+	session *ssh.Session
+}
+
+func (s sftpSession) Close() error {
+	s.client.Close()
+	return s.session.Close()
+}
+
+// OpenFileTransfer starts an SFTP session, over a pooled connection when the client
+// has a pool. The session is closed when ctx is done, which aborts transfers in progress.
+func (c *Client) OpenFileTransfer(ctx context.Context) (*FileTransfer, error) {
+	var client *sftp.Client
+	release, err := c.openChannel(ctx, func(conn *ssh.Client) (io.Closer, error) {
+		session, err := conn.NewSession()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrSession, err)
+		}
+		if client, err = newSFTPClient(session); err != nil {
+			session.Close()
+			return nil, err
+		}
+		return sftpSession{client: client, session: session}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	t := &FileTransfer{client: client, release: release}
+	t.stop = context.AfterFunc(ctx, t.close)
+	return t, nil
+}
+
+// newSFTPClient runs the SFTP subsystem in session
+func newSFTPClient(session *ssh.Session) (*sftp.Client, error) {
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return nil, fmt.Errorf("failed to start SFTP, is it enabled on the server? %w", err)
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClientPipe(stdout, stdin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start SFTP: %w", err)
+	}
+	return client, nil
+}
+
+// Upload writes content to path on the server with the given permissions, creating
+// missing parent directories. Relative paths are in the login user's home directory.
+func (t *FileTransfer) Upload(remotePath string, content io.Reader, mode os.FileMode) (int64, error) {
+	if dir := path.Dir(remotePath); dir != "." && dir != "/" {
+		if err := t.client.MkdirAll(dir); err != nil {
+			return 0, fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	file, err := t.client.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", remotePath, err)
+	}
+	defer file.Close()
+
+	// Set the permissions before writing, so a secret is never readable by others
+	if err := file.Chmod(mode); err != nil {
+		return 0, fmt.Errorf("failed to set permissions of %s: %w", remotePath, err)
+	}
+	written, err := file.ReadFrom(content)
+	if err != nil {
+		return written, fmt.Errorf("failed to write %s: %w", remotePath, err)
+	}
+	return written, file.Close()
+}
+
+// Glob returns the paths on the server matching pattern, in the syntax of path.Match.
+// A pattern without wildcards matches itself if it exists.
+func (t *FileTransfer) Glob(pattern string) ([]string, error) {
+	return t.client.Glob(pattern)
+}
+
+// Download copies the regular file at path on the server to w
+func (t *FileTransfer) Download(remotePath string, w io.Writer) (int64, error) {
+	info, err := t.client.Stat(remotePath)
+	if err != nil {
+		return 0, err
+	}
+	if !info.Mode().IsRegular() {
+		return 0, fmt.Errorf("%s is not a regular file", remotePath)
+	}
+	file, err := t.client.Open(remotePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return file.WriteTo(w)
+}
+
+// Close ends the SFTP session and gives the connection back
+func (t *FileTransfer) Close() error {
+	t.stop()
+	t.close()
+	return nil
+}
+
+func (t *FileTransfer) close() {
+	t.once.Do(t.release)
+}
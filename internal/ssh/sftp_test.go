@@ -0,0 +1,129 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"job-executor/internal/config"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// startSFTPServer runs an SSH server that accepts anyone and serves SFTP with dir as
+// the login directory
+func startSFTPServer(t *testing.T, dir string) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for newChannel := range channels {
+					if newChannel.ChannelType() != "session" {
+						newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+						continue
+					}
+					channel, requests, err := newChannel.Accept()
+					if err != nil {
+						continue
+					}
+					go func() {
+						for req := range requests {
+							ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+							req.Reply(ok, nil)
+							if ok {
+								server, _ := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(dir))
+								go func() {
+									server.Serve()
+									channel.Close()
+								}()
+							}
+						}
+					}()
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestFileTransfer(t *testing.T) {
+	dir := t.TempDir()
+	host, port, _ := net.SplitHostPort(startSFTPServer(t, dir))
+
+	pool := NewPool()
+	defer pool.Close()
+	client := NewClient(&config.SSHConfig{Host: host, Port: port, User: "deploy", TrustOnFirstUse: true})
+	client.SetPool(pool, "server", time.Now())
+
+	transfer, err := client.OpenFileTransfer(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Missing directories are created, relative paths are in the login directory
+	written, err := transfer.Upload("inputs/config.yml", strings.NewReader("key: value\n"), 0600)
+	if err != nil || written != 11 {
+		t.Fatalf("Upload = %d, %v", written, err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "inputs", "config.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %o", info.Mode().Perm())
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "inputs", "report.txt"), []byte("report"), 0644)
+	matches, err := transfer.Glob("inputs/*.txt")
+	if err != nil || len(matches) != 1 || matches[0] != "inputs/report.txt" {
+		t.Fatalf("Glob = %v, %v", matches, err)
+	}
+
+	var downloaded bytes.Buffer
+	if _, err := transfer.Download(matches[0], &downloaded); err != nil || downloaded.String() != "report" {
+		t.Fatalf("Download = %q, %v", downloaded.String(), err)
+	}
+	if _, err := transfer.Download("inputs", ioutil.Discard); err == nil {
+		t.Error("expected downloading a directory to fail")
+	}
+
+	transfer.Close()
+	if conns := pool.conns["server"]; len(conns) != 1 || conns[0].sessions != 0 {
+		t.Error("expected the pooled connection to be given back")
+	}
+}
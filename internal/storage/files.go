@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// filesDir holds job input files and artifacts. Jobs can only read files from here, so
+// they can't be pointed at the PEM files next to it.
+const filesDir = "files"
+
+// PutFile stores content as the file called name and returns its URL
+func (s *S3StorageService) PutFile(ctx context.Context, name string, content io.ReadSeeker) (string, error) {
+	key := s.keyPrefix + filesDir + "/" + name
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(s.bucket),
+		Key:                  aws.String(key),
+		Body:                 content,
+		ContentType:          aws.String("application/octet-stream"),
+		ServerSideEncryption: s.sse,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload file to S3: %w", err)
+	}
+	return fmt.Sprintf("s3://%s/%s", s.bucket, key), nil
+}
+
+func (s *S3StorageService) GetFile(ctx context.Context, url string) (io.ReadCloser, error) {
+	bucket, key, err := parseS3URL(url)
+	if err != nil {
+		return nil, err
+	}
+	if bucket != s.bucket || !strings.HasPrefix(key, s.keyPrefix+filesDir+"/") {
+		return nil, fmt.Errorf("file is outside the storage's files: %s", url)
+	}
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file from S3: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// PutFile stores content as the file called name and returns its URL
+func (l *LocalStorageService) PutFile(ctx context.Context, name string, content io.ReadSeeker) (string, error) {
+	baseDir, err := filepath.Abs(l.baseDir)
+	if err != nil {
+		return "", err
+	}
+	url := "file://" + filepath.ToSlash(filepath.Join(baseDir, filesDir, filepath.FromSlash(name)))
+	path, err := l.pathIn(filesDir, url)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create file directory: %w", err)
+	}
+	if err := copyFileAtomic(path, content); err != nil {
+		return "", fmt.Errorf("failed to store file: %w", err)
+	}
+	return url, nil
+}
+
+func (l *LocalStorageService) GetFile(ctx context.Context, url string) (io.ReadCloser, error) {
+	path, err := l.pathIn(filesDir, url)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return file, nil
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"job-executor/internal/credentials"
+
+	"github.com/google/uuid"
+)
+
+// LocalStorageService keeps PEM files in a directory, addressed by file:// URLs. Files
+// are only readable by the owner and optionally encrypted with the credentials master
+// key. The API server and workers need to share the directory, e.g. as a volume.
+type LocalStorageService struct {
+	baseDir string
+	keys    *credentials.Keyring // encrypts the files, nil stores them as they are
+	logger  *slog.Logger
+}
+
+func NewLocalStorageService(baseDir string, logger *slog.Logger) *LocalStorageService {
+	return &LocalStorageService{
+		baseDir: baseDir,
+		logger:  logger,
+	}
+}
+
+// SetKeyring makes the service encrypt files it stores with the given master keys
+func (l *LocalStorageService) SetKeyring(keys *credentials.Keyring) {
+	l.keys = keys
+}
+
+func (l *LocalStorageService) UploadPemFile(ctx context.Context, file multipart.File, filename string) (string, error) {
+	fileExt, err := pemFileExt(filename)
+	if err != nil {
+		return "", err
+	}
+
+	// Reset file reader position
+	if _, err := file.Seek(0, 0); err != nil {
+		return "", fmt.Errorf("failed to reset file position: %w", err)
+	}
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read PEM file: %w", err)
+	}
+	if l.keys != nil {
+		sealed, err := l.keys.Encrypt(string(content))
+		if err != nil {
+			return "", fmt.Errorf("failed to encrypt PEM file: %w", err)
+		}
+		content = []byte(sealed)
+	}
+
+	baseDir, err := filepath.Abs(l.baseDir)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(baseDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	path := filepath.Join(baseDir, uuid.New().String()+fileExt)
+	if err := writeFileAtomic(path, content); err != nil {
+		return "", fmt.Errorf("failed to store PEM file: %w", err)
+	}
+
+	url := "file://" + filepath.ToSlash(path)
+	l.logger.Info("Stored PEM file locally",
+		slog.String("url", url),
+		slog.Bool("encrypted", l.keys != nil))
+	return url, nil
+}
+
+func (l *LocalStorageService) DownloadPemFile(ctx context.Context, url string) ([]byte, error) {
+	path, err := l.localPath(url)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PEM file: %w", err)
+	}
+
+	// Files stored before encryption was enabled are read as they are
+	if credentials.IsEncrypted(strings.TrimSpace(string(content))) {
+		plaintext, err := l.keys.Decrypt(strings.TrimSpace(string(content)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt PEM file: %w", err)
+		}
+		content = []byte(plaintext)
+	}
+	return content, nil
+}
+
+func (l *LocalStorageService) DeletePemFile(ctx context.Context, url string) error {
+	path, err := l.localPath(url)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete PEM file: %w", err)
+	}
+	l.logger.Info("Deleted local PEM file", slog.String("url", url))
+	return nil
+}
+
+// localPath returns the file a file:// URL points to, which has to be in the storage
+// directory so servers can't be pointed at other files on the worker
+func (l *LocalStorageService) localPath(url string) (string, error) {
+	if !strings.HasPrefix(url, "file://") {
+		return "", fmt.Errorf("invalid local storage URL format: %s", url)
+	}
+	baseDir, err := filepath.Abs(l.baseDir)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(url, "file://")))
+	if filepath.Dir(path) != baseDir {
+		return "", fmt.Errorf("PEM file is outside the storage directory: %s", url)
+	}
+	return path, nil
+}
+
+// writeFileAtomic writes content to a temporary file next to path and renames it into
+// place, so readers never see a partly written key
+func writeFileAtomic(path string, content []byte) error {
+	return copyFileAtomic(path, bytes.NewReader(content))
+}
+
+// copyFileAtomic is writeFileAtomic for content streamed from a reader
+func copyFileAtomic(path string, content io.Reader) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// TempFile creates the file with mode 0600 already
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// pemFileExt returns the extension to store an uploaded key under, .pem if it has none
+func pemFileExt(filename string) (string, error) {
+	fileExt := filepath.Ext(filename)
+	if fileExt == "" {
+		fileExt = ".pem"
+	}
+	if !strings.EqualFold(fileExt, ".pem") && !strings.EqualFold(fileExt, ".key") {
+		return "", fmt.Errorf("invalid file type: only .pem and .key files are allowed")
+	}
+	return fileExt, nil
+}
+
+// memoryFile lets content already in memory be uploaded like a multipart file
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error { return nil }
+
+// CopyPemFile copies the PEM file at url from one storage to another and returns its
+// URL in the new storage. The original is left in place.
+func CopyPemFile(ctx context.Context, from, to StorageService, url string) (string, error) {
+	content, err := from.DownloadPemFile(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	fileExt := filepath.Ext(url)
+	if fileExt == "" {
+		fileExt = ".pem"
+	}
+	return to.UploadPemFile(ctx, memoryFile{bytes.NewReader(content)}, "migrated"+fileExt)
+}
@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// logChunkName is the name of a log's chunk under its URL. Zero padding keeps the
+// chunks in order when listed.
+func logChunkName(index int) string {
+	return fmt.Sprintf("%06d", index)
+}
+
+// LogURL returns where the chunks of the log called name are stored
+func (s *S3StorageService) LogURL(name string) string {
+	return fmt.Sprintf("s3://%s/%slogs/%s", s.bucket, s.keyPrefix, name)
+}
+
+func (s *S3StorageService) PutLogChunk(ctx context.Context, logURL string, index int, data []byte) error {
+	bucket, key, err := parseS3URL(logURL)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key + "/" + logChunkName(index)),
+		Body:                 bytes.NewReader(data),
+		ContentType:          aws.String("application/octet-stream"),
+		ServerSideEncryption: s.sse,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload log chunk to S3: %w", err)
+	}
+	return nil
+}
+
+func (s *S3StorageService) GetLogChunk(ctx context.Context, logURL string, index int) (io.ReadCloser, error) {
+	bucket, key, err := parseS3URL(logURL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key + "/" + logChunkName(index)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download log chunk from S3: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// parseS3URL splits an s3://bucket/key URL
+func parseS3URL(url string) (string, string, error) {
+	if !strings.HasPrefix(url, "s3://") {
+		return "", "", fmt.Errorf("invalid S3 URL format: %s", url)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(url, "s3://"), "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid S3 URL format: %s", url)
+	}
+	return parts[0], parts[1], nil
+}
+
+// LogURL returns where the chunks of the log called name are stored
+func (l *LocalStorageService) LogURL(name string) string {
+	baseDir, err := filepath.Abs(l.baseDir)
+	if err != nil {
+		baseDir = l.baseDir
+	}
+	return "file://" + filepath.ToSlash(filepath.Join(baseDir, "logs", filepath.FromSlash(name)))
+}
+
+func (l *LocalStorageService) PutLogChunk(ctx context.Context, logURL string, index int, data []byte) error {
+	dir, err := l.logDir(logURL)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, logChunkName(index)), data); err != nil {
+		return fmt.Errorf("failed to store log chunk: %w", err)
+	}
+	return nil
+}
+
+func (l *LocalStorageService) GetLogChunk(ctx context.Context, logURL string, index int) (io.ReadCloser, error) {
+	dir, err := l.logDir(logURL)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(dir, logChunkName(index)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log chunk: %w", err)
+	}
+	return file, nil
+}
+
+// logDir returns the directory a log's file:// URL points to, which has to be in the
+// logs directory of the storage
+func (l *LocalStorageService) logDir(logURL string) (string, error) {
+	return l.pathIn("logs", logURL)
+}
+
+// pathIn returns the path a file:// URL points to, which has to be in the given
+// directory of the storage
+func (l *LocalStorageService) pathIn(dir, url string) (string, error) {
+	if !strings.HasPrefix(url, "file://") {
+		return "", fmt.Errorf("invalid local storage URL format: %s", url)
+	}
+	baseDir, err := filepath.Abs(l.baseDir)
+	if err != nil {
+		return "", err
+	}
+	prefix := filepath.Join(baseDir, dir) + string(filepath.Separator)
+	path := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(url, "file://")))
+	if !strings.HasPrefix(path, prefix) {
+		return "", fmt.Errorf("%s is outside the storage's %s directory", url, dir)
+	}
+	return path, nil
+}
+
+// LogReader reads a log stored in chunks of chunkSize bytes, the last one possibly
+// shorter, as one stream. It can seek, so it serves HTTP range requests.
+type LogReader struct {
+	ctx       context.Context
+	storage   StorageService
+	url       string
+	size      int64
+	chunkSize int64
+
+	offset int64
+	chunk  io.ReadCloser // open at offset, nil until the next Read
+}
+
+// NewLogReader returns a reader for the first size bytes of the log at url
+func NewLogReader(ctx context.Context, storage StorageService, url string, size int64, chunkSize int) *LogReader {
+	return &LogReader{ctx: ctx, storage: storage, url: url, size: size, chunkSize: int64(chunkSize)}
+}
+
+func (r *LogReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.chunk == nil {
+		index := r.offset / r.chunkSize
+		chunk, err := r.storage.GetLogChunk(r.ctx, r.url, int(index))
+		if err != nil {
+			return 0, err
+		}
+		// Skip to the offset within the chunk
+		if _, err := io.CopyN(io.Discard, chunk, r.offset-index*r.chunkSize); err != nil {
+			chunk.Close()
+			return 0, fmt.Errorf("log chunk %d is shorter than expected: %w", index, err)
+		}
+		r.chunk = chunk
+	}
+
+	// Don't read past the chunk or the end of the log
+	chunkEnd := (r.offset/r.chunkSize + 1) * r.chunkSize
+	if chunkEnd > r.size {
+		chunkEnd = r.size
+	}
+	if int64(len(p)) > chunkEnd-r.offset {
+		p = p[:chunkEnd-r.offset]
+	}
+
+	n, err := r.chunk.Read(p)
+	r.offset += int64(n)
+	if r.offset == chunkEnd || errors.Is(err, io.EOF) {
+		r.chunk.Close()
+		r.chunk = nil
+		if r.offset < chunkEnd {
+			return n, fmt.Errorf("log chunk %d is shorter than expected: %w", r.offset/r.chunkSize, io.ErrUnexpectedEOF)
+		}
+		err = nil
+	}
+	return n, err
+}
+
+func (r *LogReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return r.offset, nil
+}
+
+// Close releases the chunk being read
+func (r *LogReader) Close() error {
+	if r.chunk == nil {
+		return nil
+	}
+	err := r.chunk.Close()
+	r.chunk = nil
+	return err
+}
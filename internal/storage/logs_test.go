@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogReader(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalStorageService(t.TempDir(), slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+
+	// 26 bytes in chunks of 10, the last one shorter
+	log := "abcdefghijklmnopqrstuvwxyz"
+	url := local.LogURL("job-1/1/stdout")
+	for i := 0; i*10 < len(log); i++ {
+		end := (i + 1) * 10
+		if end > len(log) {
+			end = len(log)
+		}
+		if err := local.PutLogChunk(ctx, url, i, []byte(log[i*10:end])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader := NewLogReader(ctx, local, url, int64(len(log)), 10)
+	defer reader.Close()
+	all, err := io.ReadAll(reader)
+	if err != nil || string(all) != log {
+		t.Fatalf("ReadAll = %q, %v", all, err)
+	}
+
+	// A range across a chunk boundary
+	if _, err := reader.Seek(8, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	part := make([]byte, 5)
+	if _, err := io.ReadFull(reader, part); err != nil || string(part) != "ijklm" {
+		t.Errorf("range read = %q, %v", part, err)
+	}
+
+	// The end of the log
+	if _, err := reader.Seek(-3, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	end, err := io.ReadAll(reader)
+	if err != nil || string(end) != "xyz" {
+		t.Errorf("tail read = %q, %v", end, err)
+	}
+
+	// Only what's been stored so far is read, even if more chunks exist
+	partial, err := io.ReadAll(NewLogReader(ctx, local, url, 15, 10))
+	if err != nil || string(partial) != log[:15] {
+		t.Errorf("partial read = %q, %v", partial, err)
+	}
+
+	if err := local.PutLogChunk(ctx, strings.Replace(url, "/logs/", "/", 1), 0, nil); err == nil {
+		t.Error("expected logs outside the logs directory to be refused")
+	}
+}
@@ -6,13 +6,16 @@ import (
 	"io"
 	"log/slog"
 	"mime/multipart"
-	"path/filepath"
 	"strings"
 	"time"
 
+	"job-executor/internal/credentials"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	awscredentials "github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/uuid"
 )
 
@@ -20,55 +23,129 @@ type StorageService interface {
 	UploadPemFile(ctx context.Context, file multipart.File, filename string) (string, error)
 	DownloadPemFile(ctx context.Context, url string) ([]byte, error)
 	DeletePemFile(ctx context.Context, url string) error
+
+	// Logs are stored as numbered chunk objects under a URL, see NewLogReader
+	LogURL(name string) string
+	PutLogChunk(ctx context.Context, logURL string, index int, data []byte) error
+	GetLogChunk(ctx context.Context, logURL string, index int) (io.ReadCloser, error)
+
+	// Job input files and artifacts, GetFile only reads files stored with PutFile
+	PutFile(ctx context.Context, name string, content io.ReadSeeker) (string, error)
+	GetFile(ctx context.Context, url string) (io.ReadCloser, error)
 }
 
 type S3StorageService struct {
-	client *s3.Client
-	bucket string
-	logger *slog.Logger
+	client    *s3.Client
+	bucket    string
+	keyPrefix string
+	sse       types.ServerSideEncryption
+	logger    *slog.Logger
 }
 
 type StorageConfig struct {
 	AWSRegion    string
 	S3Bucket     string
 	S3KeyPrefix  string
-	AWSAccessKey string
+	AWSAccessKey string // with AWSSecretKey, used instead of the default credential chain
 	AWSSecretKey string
+
+	// S3Endpoint points the client at an S3-compatible store such as MinIO. Those
+	// usually need S3UsePathStyle, as buckets aren't resolved as subdomains.
+	S3Endpoint     string
+	S3UsePathStyle bool
+	// S3ServerSideEncryption is the encryption requested for uploads, "AES256" unless
+	// set. "none" leaves it out, for stores without server-side encryption.
+	S3ServerSideEncryption string
+
+	Type      string               // "local", "s3" or empty to try S3 and fall back to local
+	LocalPath string               // directory of local storage
+	Keys      *credentials.Keyring // encrypts files in local storage, nil stores them as they are
 }
 
+// NewStorageService sets up the storage picked by cfg.Type. Without a type S3 is used
+// if it can be set up, and local storage otherwise.
+func NewStorageService(cfg *StorageConfig, logger *slog.Logger) (StorageService, error) {
+	local := NewLocalStorageService(cfg.LocalPath, logger)
+	local.SetKeyring(cfg.Keys)
+
+	switch cfg.Type {
+	case "local":
+		return local, nil
+	case "s3":
+		return NewS3StorageService(cfg, logger)
+	case "":
+		s3Service, err := NewS3StorageService(cfg, logger)
+		if err != nil {
+			logger.Warn("S3 storage unavailable, storing PEM files locally",
+				slog.String("path", cfg.LocalPath),
+				slog.Any("error", err))
+			return local, nil
+		}
+		return s3Service, nil
+	}
+	return nil, fmt.Errorf("unknown storage type %q", cfg.Type)
+}
+
+// NewS3StorageService connects to the bucket and checks that it exists and can be
+// accessed, so a misconfigured bucket is found at startup rather than on the first upload
 func NewS3StorageService(cfg *StorageConfig, logger *slog.Logger) (*S3StorageService, error) {
+	if cfg.S3Bucket == "" {
+		return nil, fmt.Errorf("no S3 bucket configured")
+	}
+
 	// Load AWS config
-	awsCfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(cfg.AWSRegion),
-	)
+	options := []func(*config.LoadOptions) error{config.WithRegion(cfg.AWSRegion)}
+	if cfg.AWSAccessKey != "" && cfg.AWSSecretKey != "" {
+		options = append(options, config.WithCredentialsProvider(
+			awscredentials.NewStaticCredentialsProvider(cfg.AWSAccessKey, cfg.AWSSecretKey, "")))
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.TODO(), options...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
 	// Create S3 client
-	client := s3.NewFromConfig(awsCfg)
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.S3Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.S3Endpoint)
+		}
+		o.UsePathStyle = cfg.S3UsePathStyle
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(cfg.S3Bucket)}); err != nil {
+		return nil, fmt.Errorf("failed to access S3 bucket %s: %w", cfg.S3Bucket, err)
+	}
+
+	sse := types.ServerSideEncryptionAes256
+	switch cfg.S3ServerSideEncryption {
+	case "":
+	case "none":
+		sse = ""
+	default:
+		sse = types.ServerSideEncryption(cfg.S3ServerSideEncryption)
+	}
 
 	return &S3StorageService{
-		client: client,
-		bucket: cfg.S3Bucket,
-		logger: logger,
+		client:    client,
+		bucket:    cfg.S3Bucket,
+		keyPrefix: cfg.S3KeyPrefix,
+		sse:       sse,
+		logger:    logger,
 	}, nil
 }
 
 func (s *S3StorageService) UploadPemFile(ctx context.Context, file multipart.File, filename string) (string, error) {
-	// Generate unique key for the file
-	fileExt := filepath.Ext(filename)
-	if fileExt == "" {
-		fileExt = ".pem"
-	}
-	
-	key := fmt.Sprintf("pem-files/%s%s", uuid.New().String(), fileExt)
-
 	// Validate file extension
-	if !strings.EqualFold(fileExt, ".pem") && !strings.EqualFold(fileExt, ".key") {
-		return "", fmt.Errorf("invalid file type: only .pem and .key files are allowed")
+	fileExt, err := pemFileExt(filename)
+	if err != nil {
+		return "", err
 	}
 
+	// Generate unique key for the file
+	key := fmt.Sprintf("%s%s%s", s.keyPrefix, uuid.New().String(), fileExt)
+
 	// Reset file reader position
 	if _, err := file.Seek(0, 0); err != nil {
 		return "", fmt.Errorf("failed to reset file position: %w", err)
@@ -79,12 +156,12 @@ func (s *S3StorageService) UploadPemFile(ctx context.Context, file multipart.Fil
 		slog.String("key", key))
 
 	// Upload file to S3
-	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:        aws.String(s.bucket),
 		Key:           aws.String(key),
 		Body:          file,
 		ContentType:   aws.String("application/x-pem-file"),
-		ServerSideEncryption: "AES256", // Encrypt at rest
+		ServerSideEncryption: s.sse, // Encrypt at rest
 		Metadata: map[string]string{
 			"original-filename": filename,
 			"uploaded-at":       time.Now().UTC().Format(time.RFC3339),
@@ -182,30 +259,3 @@ func (s *S3StorageService) DeletePemFile(ctx context.Context, url string) error
 
 	return nil
 }
-
-// LocalStorageService provides a local file system implementation for development
-type LocalStorageService struct {
-	baseDir string
-	logger  *slog.Logger
-}
-
-func NewLocalStorageService(baseDir string, logger *slog.Logger) *LocalStorageService {
-	return &LocalStorageService{
-		baseDir: baseDir,
-		logger:  logger,
-	}
-}
-
-func (l *LocalStorageService) UploadPemFile(ctx context.Context, file multipart.File, filename string) (string, error) {
-	// This is a placeholder for local development
-	// In production, you should use the S3StorageService
-	return "", fmt.Errorf("local storage not implemented - use S3 storage service")
-}
-
-func (l *LocalStorageService) DownloadPemFile(ctx context.Context, url string) ([]byte, error) {
-	return nil, fmt.Errorf("local storage not implemented - use S3 storage service")
-}
-
-func (l *LocalStorageService) DeletePemFile(ctx context.Context, url string) error {
-	return fmt.Errorf("local storage not implemented - use S3 storage service")
-}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// startS3Stub serves a minimal path-style S3 API holding the objects of one bucket, as
+// MinIO would
+func startS3Stub(t *testing.T, bucket string) (*httptest.Server, map[string][]byte) {
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=minio-access/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/")
+		if path != bucket && !strings.HasPrefix(path, bucket+"/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		key := strings.TrimPrefix(strings.TrimPrefix(path, bucket), "/")
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodHead && key == "":
+		case r.Method == http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			objects[key] = body
+		case r.Method == http.MethodGet:
+			body, ok := objects[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(body)
+		case r.Method == http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(stub.Close)
+	return stub, objects
+}
+
+func TestS3StorageServiceWithCustomEndpoint(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(ioutil.Discard, nil))
+	stub, objects := startS3Stub(t, "keys")
+
+	cfg := &StorageConfig{
+		AWSRegion:              "us-east-1",
+		S3Bucket:               "keys",
+		S3KeyPrefix:            "tenant-a/",
+		AWSAccessKey:           "minio-access",
+		AWSSecretKey:           "minio-secret",
+		S3Endpoint:             stub.URL,
+		S3UsePathStyle:         true,
+		S3ServerSideEncryption: "none",
+	}
+	service, err := NewS3StorageService(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, err := service.UploadPemFile(ctx, memoryFile{bytes.NewReader([]byte(testKey))}, "deploy.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(url, "s3://keys/tenant-a/") {
+		t.Errorf("expected the key prefix in %s", url)
+	}
+	if len(objects) != 1 {
+		t.Fatalf("expected one stored object, got %d", len(objects))
+	}
+
+	content, err := service.DownloadPemFile(ctx, url)
+	if err != nil || string(content) != testKey {
+		t.Fatalf("DownloadPemFile = %q, %v", content, err)
+	}
+	if err := service.DeletePemFile(ctx, url); err != nil || len(objects) != 0 {
+		t.Fatalf("DeletePemFile = %v, %d objects left", err, len(objects))
+	}
+
+	// A bucket that doesn't exist is found at startup
+	missing := *cfg
+	missing.S3Bucket = "other"
+	if _, err := NewS3StorageService(&missing, logger); err == nil {
+		t.Error("expected a missing bucket to fail")
+	}
+}
@@ -0,0 +1,230 @@
+package worker
+
+import (
+	"context"
+	"job-executor/internal/models"
+	"job-executor/internal/storage"
+	"log/slog"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// outputTailSize is how much of the end of stored output the database keeps
+	outputTailSize = 64 << 10
+
+	defaultOutputInlineLimit = 1 << 20
+	defaultOutputChunkSize   = 1 << 20
+
+	// maxPendingChunks is how much output waits for storage at most while it's slow or
+	// failing, output beyond it is dropped and counted as lost
+	maxPendingChunks = 8
+
+	// The last chunks are tried a few more times before the job finishes without them
+	outputFlushAttempts   = 3
+	outputFlushRetryDelay = time.Second
+)
+
+// outputBuffer collects one output stream of a job. While the output fits the inline
+// limit it's kept whole for the database. Beyond that it's written to storage in
+// chunks, and only its tail is kept. Chunks are uploaded by a goroutine of their own,
+// so writing output never waits for storage.
+type outputBuffer struct {
+	storage   storage.StorageService
+	name      string // name of the log in storage
+	limit     int    // 0 keeps all output inline
+	chunkSize int
+
+	mu      sync.Mutex
+	inline  []byte // all output, until it's spilled into storage
+	url     string // the log's URL once spilled
+	pending []byte // output not written to storage yet
+	tail    []byte
+	stored  int64 // bytes written to storage
+	chunks  int
+	lost    int64 // bytes that couldn't be stored
+
+	ready   chan struct{} // wakes the uploader when there are full chunks
+	closing chan struct{} // stops the uploader
+	done    chan struct{} // closed once the uploader stopped
+}
+
+func newOutputBuffer(storage storage.StorageService, name string, limit, chunkSize int) *outputBuffer {
+	if storage == nil {
+		limit = 0
+	}
+	return &outputBuffer{storage: storage, name: name, limit: limit, chunkSize: chunkSize}
+}
+
+// Write adds output. Once it's over the limit, full chunks are handed to the uploader.
+func (b *outputBuffer) Write(output string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.url == "" {
+		b.inline = append(b.inline, output...)
+		if b.limit <= 0 || len(b.inline) <= b.limit {
+			return
+		}
+		// Over the limit, everything so far goes to storage
+		b.url = b.storage.LogURL(b.name)
+		b.pending, b.inline = b.inline, nil
+		b.tail = trimTail(append([]byte(nil), b.pending...))
+		b.ready, b.closing, b.done = make(chan struct{}, 1), make(chan struct{}), make(chan struct{})
+		go b.upload()
+	} else {
+		b.tail = trimTail(append(b.tail, output...))
+		if len(b.pending) >= maxPendingChunks*b.chunkSize {
+			// Storage has been failing for a while, don't hold on to output forever
+			if b.lost == 0 {
+				slog.Error("Job output is piling up, dropping it until storage works again", "log", b.name)
+			}
+			b.lost += int64(len(output))
+			return
+		}
+		b.pending = append(b.pending, output...)
+	}
+	if len(b.pending) >= b.chunkSize {
+		select {
+		case b.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// upload writes full chunks to storage as they come in, until Close. After a failure
+// it waits a moment before trying again.
+func (b *outputBuffer) upload() {
+	defer close(b.done)
+	for {
+		select {
+		case <-b.ready:
+		case <-b.closing:
+			return
+		}
+		if b.flush(context.Background(), false) != nil {
+			select {
+			case <-time.After(outputFlushRetryDelay):
+			case <-b.closing:
+				return
+			}
+			b.mu.Lock()
+			if len(b.pending) >= b.chunkSize {
+				select {
+				case b.ready <- struct{}{}:
+				default:
+				}
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+// Close writes what's left to storage. Output that still can't be stored after a few
+// attempts is counted as lost.
+func (b *outputBuffer) Close(ctx context.Context) {
+	b.mu.Lock()
+	spilled := b.url != ""
+	b.mu.Unlock()
+	if !spilled {
+		return
+	}
+	close(b.closing)
+	<-b.done
+
+	for attempt := 1; b.flush(ctx, true) != nil && attempt < outputFlushAttempts; attempt++ {
+		select {
+		case <-time.After(time.Duration(attempt) * outputFlushRetryDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending) > 0 {
+		b.lost += int64(len(b.pending))
+		b.pending = nil
+	}
+}
+
+// flush writes the pending output to storage in full chunks, and the last partial
+// one too when final. A chunk that fails stays pending for the next flush. Only one
+// flush runs at a time, and the lock isn't held while uploading.
+func (b *outputBuffer) flush(ctx context.Context, final bool) error {
+	for {
+		b.mu.Lock()
+		n := len(b.pending)
+		if n == 0 || (n < b.chunkSize && !final) {
+			b.mu.Unlock()
+			return nil
+		}
+		if n > b.chunkSize {
+			n = b.chunkSize
+		}
+		// Writes only append, so the chunk stays as it is while it's uploaded
+		chunk, index := b.pending[:n:n], b.chunks
+		b.mu.Unlock()
+
+		if err := b.storage.PutLogChunk(ctx, b.url, index, chunk); err != nil {
+			slog.Error("Failed to store job output", "log", b.name, "chunk", index, "error", err)
+			return err
+		}
+
+		b.mu.Lock()
+		b.pending = b.pending[n:]
+		b.stored += int64(n)
+		b.chunks++
+		b.mu.Unlock()
+	}
+}
+
+// Text is what the database keeps: the whole output, or its tail once it's in storage
+func (b *outputBuffer) Text() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.url == "" {
+		return string(b.inline)
+	}
+	return string(b.tail)
+}
+
+// Size is the total size of the output
+func (b *outputBuffer) Size() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.url == "" {
+		return int64(len(b.inline))
+	}
+	return b.stored + int64(len(b.pending)) + b.lost
+}
+
+// Lost is how much of the output couldn't be stored
+func (b *outputBuffer) Lost() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lost
+}
+
+// Log points at the output in storage, nil while it's kept inline
+func (b *outputBuffer) Log() *models.OutputLog {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.url == "" {
+		return nil
+	}
+	return &models.OutputLog{URL: b.url, Size: b.stored, ChunkSize: b.chunkSize, Lost: b.lost}
+}
+
+// trimTail cuts output down to its last outputTailSize bytes, starting at a whole
+// UTF-8 character so the database accepts it
+func trimTail(output []byte) []byte {
+	if len(output) <= outputTailSize {
+		return output
+	}
+	start := len(output) - outputTailSize
+	for start < len(output) && !utf8.RuneStart(output[start]) {
+		start++
+	}
+	return append([]byte(nil), output[start:]...)
+}
@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"job-executor/internal/storage"
+)
+
+// chunkStorage keeps log chunks in memory and fails while failures are left. Uploads
+// wait for unblock if it's set.
+type chunkStorage struct {
+	storage.StorageService
+	mu       sync.Mutex
+	chunks   map[int]string
+	failures int
+	unblock  chan struct{}
+}
+
+func (s *chunkStorage) LogURL(name string) string {
+	return "mem://" + name
+}
+
+func (s *chunkStorage) PutLogChunk(ctx context.Context, logURL string, index int, data []byte) error {
+	if s.unblock != nil {
+		<-s.unblock
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures != 0 {
+		s.failures--
+		return errors.New("storage unavailable")
+	}
+	s.chunks[index] = string(data)
+	return nil
+}
+
+func TestOutputBuffer_WritesDontWaitForStorage(t *testing.T) {
+	store := &chunkStorage{chunks: map[int]string{}, unblock: make(chan struct{})}
+	b := newOutputBuffer(store, "job/1/stdout", 4, 4)
+	var want strings.Builder
+	for i := 0; i < maxPendingChunks; i++ {
+		b.Write("abc\n")
+		want.WriteString("abc\n")
+	}
+
+	// Every write returned with the first upload still hanging
+	close(store.unblock)
+	b.Close(context.Background())
+
+	var got strings.Builder
+	for i := 0; i < len(store.chunks); i++ {
+		got.WriteString(store.chunks[i])
+	}
+	if got.String() != want.String() || b.Log().Size != int64(want.Len()) {
+		t.Errorf("Expected %q stored, got %q", want.String(), got.String())
+	}
+}
+
+func TestOutputBuffer_RetriesFinalFlush(t *testing.T) {
+	store := &chunkStorage{chunks: map[int]string{}}
+	b := newOutputBuffer(store, "job/1/stdout", 4, 4)
+	b.Write("0123456789")
+
+	store.mu.Lock()
+	store.failures = 1
+	store.mu.Unlock()
+	b.Close(context.Background())
+
+	log := b.Log()
+	if log.Size != 10 || log.Lost != 0 || b.Lost() != 0 {
+		t.Fatalf("Expected all output stored, got size %d, lost %d", log.Size, log.Lost)
+	}
+	if got := store.chunks[0] + store.chunks[1] + store.chunks[2]; got != "0123456789" {
+		t.Errorf("Expected the output in order, got %q", got)
+	}
+}
+
+func TestOutputBuffer_CountsLostOutput(t *testing.T) {
+	store := &chunkStorage{chunks: map[int]string{}, failures: -1}
+	b := newOutputBuffer(store, "job/1/stdout", 4, 4)
+	for i := 0; i < maxPendingChunks*2; i++ {
+		b.Write("abcd")
+	}
+	if pending := len(b.pending); pending > (maxPendingChunks+1)*4 {
+		t.Errorf("Expected pending output capped, got %d bytes", pending)
+	}
+	// A done context skips the waits between the last attempts
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.Close(ctx)
+
+	// Nothing could be stored, and the log says so rather than looking complete
+	log := b.Log()
+	if log.Size != 0 || log.Lost != int64(maxPendingChunks*2*4) || b.Size() != log.Lost {
+		t.Errorf("Expected all output lost, got size %d, lost %d of %d", log.Size, log.Lost, b.Size())
+	}
+	if !strings.HasSuffix(b.Text(), "abcd") {
+		t.Errorf("Expected the tail kept for the database, got %q", b.Text())
+	}
+}
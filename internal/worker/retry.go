@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"job-executor/internal/models"
+	"job-executor/internal/ssh"
+	"log/slog"
+	"time"
+)
+
+const (
+	// defaultRetryBackoff is the delay before the first retry when the job doesn't set one
+	defaultRetryBackoff = 10 * time.Second
+	// maxRetryBackoff caps the delay between attempts however many there have been
+	maxRetryBackoff = time.Hour
+)
+
+// retryBackoff is how long to wait after the given failed attempt: the job's base
+// backoff, doubled for every attempt before it
+func retryBackoff(policy models.RetryPolicy, attempt int) time.Duration {
+	backoff := time.Duration(policy.RetryBackoff) * time.Second
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	return backoff
+}
+
+// isRetryable reports whether a failed run may succeed if tried again: the server
+// couldn't be reached, the command timed out, or it exited with one of the job's
+// retryable exit codes. A canceled job, rejected credentials or a host key that failed
+// verification are never retried.
+func isRetryable(jobCtx context.Context, err error, result *ssh.StreamingResult, policy models.RetryPolicy) bool {
+	if jobCtx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		if ssh.IsHostKeyError(err) || errors.Is(err, ssh.ErrAuth) {
+			return false
+		}
+		return errors.Is(err, ssh.ErrConnect) || errors.Is(err, ssh.ErrSession) || errors.Is(err, ssh.ErrTimeout)
+	}
+	return result != nil && result.ExitCode != 0 && policy.RetriesExitCode(result.ExitCode)
+}
+
+// retryJob hands a failed job back to the queue to run again after delay
+func (w *Worker) retryJob(jobID string, delay time.Duration) {
+	if err := w.queue.Retry(jobID, delay); err != nil {
+		slog.Error("Failed to schedule job retry", "job_id", jobID, "error", err)
+	}
+}
+
+// deadLetterJob parks a job that ran out of retries on the queue's dead-letter list
+func (w *Worker) deadLetterJob(jobID, reason string) {
+	if err := w.queue.DeadLetter(jobID, reason); err != nil {
+		slog.Error("Failed to dead-letter job", "job_id", jobID, "error", err)
+	}
+}
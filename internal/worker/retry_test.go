@@ -0,0 +1,46 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"job-executor/internal/models"
+	"job-executor/internal/ssh"
+	"testing"
+)
+
+func TestIsRetryable(t *testing.T) {
+	policy := models.RetryPolicy{RetryExitCodes: "1,255"}
+	wrap := func(err error) error {
+		return fmt.Errorf("jump host 1 of 2: %w", err)
+	}
+
+	cases := []struct {
+		name   string
+		err    error
+		result *ssh.StreamingResult
+		want   bool
+	}{
+		{"unreachable", wrap(fmt.Errorf("%w at host:22: dial tcp: connection refused", ssh.ErrConnect)), nil, true},
+		{"no session", fmt.Errorf("%w: EOF", ssh.ErrSession), nil, true},
+		{"timed out", fmt.Errorf("command execution %w after 1m0s (limit: 1m0s)", ssh.ErrTimeout), &ssh.StreamingResult{}, true},
+		{"rejected credentials", wrap(fmt.Errorf("%w at host:22: ssh: unable to authenticate", ssh.ErrAuth)), nil, false},
+		{"host key mismatch", fmt.Errorf("host key verification failed for host:22: %w", ssh.ErrHostKeyMismatch), nil, false},
+		{"other error", errors.New("failed to parse private key: timeout in message"), nil, false},
+		{"retryable exit code", nil, &ssh.StreamingResult{ExitCode: 255}, true},
+		{"other exit code", nil, &ssh.StreamingResult{ExitCode: 2}, false},
+		{"success", nil, &ssh.StreamingResult{}, false},
+	}
+	for _, tc := range cases {
+		if got := isRetryable(context.Background(), tc.err, tc.result, policy); got != tc.want {
+			t.Errorf("%s: expected retryable %v, got %v", tc.name, tc.want, got)
+		}
+	}
+
+	// Nothing is retried once the job itself was canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if isRetryable(ctx, fmt.Errorf("%w: EOF", ssh.ErrConnect), nil, policy) {
+		t.Errorf("canceled job: expected no retry")
+	}
+}
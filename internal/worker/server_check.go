@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"job-executor/internal/models"
+	"job-executor/internal/queue"
+	"job-executor/internal/ssh"
+	"log/slog"
+	"time"
+)
+
+// serverCheckTimeout bounds a server check, connecting through every jump host included
+const serverCheckTimeout = time.Minute
+
+// CheckServer connects to a server for the API, which can't decrypt its credentials.
+// Host keys the server and its jump hosts present are pinned on first use, whether or
+// not the worker trusts them for jobs; with Repin the server's key replaces the pinned ones.
+func (w *Worker) CheckServer(ctx context.Context, check queue.ServerCheck) queue.ServerCheckResult {
+	ctx, cancel := context.WithTimeout(ctx, serverCheckTimeout)
+	defer cancel()
+
+	failed := func(err error) queue.ServerCheckResult {
+		status := "connection_failed"
+		if ssh.IsHostKeyError(err) {
+			status = "host_key_mismatch"
+		}
+		return queue.ServerCheckResult{Status: status, Error: err.Error()}
+	}
+
+	var server models.Server
+	if err := w.db.First(&server, "id = ?", check.ServerID).Error; err != nil {
+		return failed(fmt.Errorf("failed to fetch server: %w", err))
+	}
+	jumpChain, err := models.JumpChain(w.db, &server)
+	if err != nil {
+		return failed(err)
+	}
+
+	sshConfig := server.SSHConfig()
+	sshConfig.TrustOnFirstUse = true
+	if check.Repin {
+		sshConfig.HostKeys = nil
+	}
+	sshConfig.JumpHosts = models.JumpSSHConfigs(jumpChain)
+	for i := range sshConfig.JumpHosts {
+		sshConfig.JumpHosts[i].TrustOnFirstUse = true
+	}
+	sshClient := ssh.NewClientWithStorage(sshConfig, w.storage)
+	sshClient.SetKeyring(w.keys)
+	sshClient.SetSecrets(w.secrets)
+	if sshClient.MintsCertificate() {
+		sshClient.SetCertificateAuthority(w.ca, "check-"+server.ID, serverCheckTimeout)
+	}
+
+	if err := sshClient.TestConnection(ctx); err != nil {
+		slog.Error("SSH connection test failed", "server_id", server.ID, "hostname", server.Hostname, "error", err)
+		return failed(err)
+	}
+	result := queue.ServerCheckResult{Status: "connection_successful", HostKey: sshClient.HostKey()}
+
+	switch {
+	case check.Repin:
+		// Saving bumps updated_at, so pooled connections verified against the old keys are dropped
+		server.HostKeys = []string{result.HostKey}
+		if err := w.db.Model(&server).Select("host_keys", "updated_at").Updates(&server).Error; err != nil {
+			slog.Error("Failed to re-pin host key", "server_id", server.ID, "error", err)
+			result.Error = "failed to pin host key"
+			break
+		}
+		result.HostKeyPinned = true
+		slog.Info("Host key re-pinned", "server_id", server.ID, "host_key", result.HostKey)
+	case len(server.HostKeys) == 0 && result.HostKey != "":
+		result.HostKeyPinned = w.pinHostKey(&server, result.HostKey)
+	}
+	for i, hostKey := range sshClient.JumpHostKeys() {
+		if len(jumpChain[i].HostKeys) == 0 && hostKey != "" {
+			w.pinHostKey(&jumpChain[i], hostKey)
+		}
+	}
+
+	slog.Info("SSH connection test successful", "server_id", server.ID, "hostname", server.Hostname, "host_key", result.HostKey)
+	return result
+}
@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"job-executor/internal/models"
+	"job-executor/internal/ssh"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// uploadInputs copies the job's input files from storage to the target. Transfers are
+// bounded by the job's timeout, like the command.
+func (w *Worker) uploadInputs(ctx context.Context, sshClient *ssh.Client, job *models.Job, timeout time.Duration) error {
+	if len(job.Inputs) == 0 {
+		return nil
+	}
+	if w.storage == nil {
+		return errors.New("no storage configured to read input files from")
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	transfer, err := sshClient.OpenFileTransfer(ctx)
+	if err != nil {
+		return err
+	}
+	defer transfer.Close()
+
+	for _, input := range job.Inputs {
+		mode, err := input.FileMode()
+		if err != nil {
+			return err
+		}
+		content, err := w.storage.GetFile(ctx, input.URL)
+		if err != nil {
+			return fmt.Errorf("input %s: %w", input.Path, err)
+		}
+		written, err := transfer.Upload(input.Path, content, mode)
+		content.Close()
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("input %s: upload %w after %v", input.Path, ssh.ErrTimeout, timeout)
+			}
+			return err
+		}
+		slog.Info("Uploaded input file", "job_id", job.ID, "path", input.Path, "size", written)
+	}
+	return nil
+}
+
+// collectArtifacts copies the files at the job's artifact paths from the target to
+// storage and records them. A file that can't be collected is recorded with the
+// reason, it doesn't fail the job.
+func (w *Worker) collectArtifacts(ctx context.Context, sshClient *ssh.Client, job *models.Job, timeout time.Duration) {
+	if len(job.ArtifactPaths) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var artifacts []models.JobArtifact
+	failed := func(path string, err error) {
+		slog.Warn("Failed to collect artifact", "job_id", job.ID, "path", path, "error", err)
+		artifacts = append(artifacts, models.JobArtifact{JobID: job.ID, Attempt: job.Attempt, Path: path, Error: err.Error()})
+	}
+
+	transfer, err := sshClient.OpenFileTransfer(ctx)
+	if err == nil && w.storage == nil {
+		transfer.Close()
+		err = errors.New("no storage configured to keep artifacts in")
+	}
+	if err != nil {
+		for _, pattern := range job.ArtifactPaths {
+			failed(pattern, err)
+		}
+	} else {
+		defer transfer.Close()
+	collect:
+		for _, pattern := range job.ArtifactPaths {
+			matches, err := transfer.Glob(pattern)
+			if err == nil && len(matches) == 0 {
+				err = errors.New("no such file")
+			}
+			if err != nil {
+				failed(pattern, err)
+				continue
+			}
+			for _, path := range matches {
+				if len(artifacts) == models.MaxJobArtifacts {
+					slog.Warn("Too many artifacts, skipping the rest", "job_id", job.ID, "max", models.MaxJobArtifacts)
+					break collect
+				}
+				artifact, err := w.collectArtifact(ctx, transfer, job, path)
+				if err != nil {
+					failed(path, err)
+					continue
+				}
+				artifacts = append(artifacts, *artifact)
+			}
+		}
+	}
+
+	if err := w.db.Create(&artifacts).Error; err != nil {
+		slog.Error("Failed to record artifacts", "job_id", job.ID, "error", err)
+		return
+	}
+	slog.Info("Artifacts collected", "job_id", job.ID, "count", len(artifacts))
+}
+
+// collectArtifact downloads a file from the target into a temporary file, as uploads
+// to storage need to know its size, and stores it from there
+func (w *Worker) collectArtifact(ctx context.Context, transfer *ssh.FileTransfer, job *models.Job, path string) (*models.JobArtifact, error) {
+	tmp, err := ioutil.TempFile("", "artifact-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := transfer.Download(path, tmp)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	url, err := w.storage.PutFile(ctx, models.ArtifactName(job.ID, job.Attempt, path), tmp)
+	if err != nil {
+		return nil, err
+	}
+	return &models.JobArtifact{JobID: job.ID, Attempt: job.Attempt, Path: path, URL: url, Size: size}, nil
+}
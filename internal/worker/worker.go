@@ -3,9 +3,10 @@ package worker
 import (
 	"context"
 	"fmt"
-	"job-executor/internal/config"
+	"job-executor/internal/credentials"
 	"job-executor/internal/models"
 	"job-executor/internal/queue"
+	"job-executor/internal/secrets"
 	"job-executor/internal/ssh"
 	"job-executor/internal/storage"
 	"log/slog"
@@ -26,9 +27,22 @@ type Worker struct {
 	mu         sync.RWMutex
 	jobChan    chan *models.Job
 	workerPool int
+	prefetch   int           // jobs reserved from the queue ahead of processing, 0 means auto
 	activeJobs int64         // Counter for active jobs
 	jobCountMu sync.RWMutex  // Mutex for job counter
 	semaphore  chan struct{} // Semaphore to limit concurrent jobs
+	sshPool    *ssh.Pool     // SSH connections shared by jobs on the same server
+	strictKeys bool          // refuse servers without a pinned host key
+
+	// ca issues per-job certificates, nil if not configured
+	ca *ssh.CertificateAuthority
+	// keys decrypt server credentials, nil if they are stored as plaintext
+	keys *credentials.Keyring
+	// secrets resolves server credentials stored as references
+	secrets *secrets.Resolver
+	// Output beyond outputLimit bytes goes to storage in chunks of outputChunkSize
+	outputLimit     int
+	outputChunkSize int
 }
 
 func New(db *gorm.DB, queue queue.NetQueue, storage storage.StorageService) *Worker {
@@ -50,6 +64,23 @@ func New(db *gorm.DB, queue queue.NetQueue, storage storage.StorageService) *Wor
 		jobChan:    make(chan *models.Job, bufferSize), // Larger buffered channel
 		workerPool: workerPoolSize,
 		semaphore:  make(chan struct{}, workerPoolSize), // Initialize semaphore
+		sshPool:    ssh.NewPool(),
+
+		outputLimit:     defaultOutputInlineLimit,
+		outputChunkSize: defaultOutputChunkSize,
+	}
+}
+
+// SetOutputLimits configures how much of a job's output the database keeps whole.
+// Output beyond inlineLimit bytes is written to storage in chunks of chunkSize bytes,
+// an inlineLimit of 0 keeps all output in the database. Negative or zero values
+// respectively keep the defaults.
+func (w *Worker) SetOutputLimits(inlineLimit, chunkSize int) {
+	if inlineLimit >= 0 {
+		w.outputLimit = inlineLimit
+	}
+	if chunkSize > 0 {
+		w.outputChunkSize = chunkSize
 	}
 }
 
@@ -62,6 +93,74 @@ func (w *Worker) SetWorkerPoolSize(size int) {
 	w.semaphore = make(chan struct{}, size) // Update semaphore size
 }
 
+// SetPrefetch configures how many jobs the worker reserves from the queue ahead of
+// processing, so jobChan stays filled without a round trip per job
+func (w *Worker) SetPrefetch(n int) {
+	if n < 0 {
+		n = 0
+	}
+	w.prefetch = n
+}
+
+// SetStrictHostKeys makes jobs fail on servers without a pinned host key rather than
+// pinning the first key they see
+func (w *Worker) SetStrictHostKeys(strict bool) {
+	w.strictKeys = strict
+}
+
+// pinHostKey pins the host key a server presented on its first connection and reports
+// whether it did. The update only applies while no key is pinned, so concurrent jobs
+// can't overwrite a key pinned in the meantime. The pinned key is part of the pooled
+// connections' settings, so the next job replaces the connection opened before it with
+// one that verifies the key.
+func (w *Worker) pinHostKey(server *models.Server, fingerprint string) bool {
+	server.HostKeys = []string{fingerprint}
+	result := w.db.Model(&models.Server{}).
+		Where("id = ? AND (host_keys IS NULL OR host_keys = '' OR host_keys = '[]')", server.ID).
+		UpdateColumns(&models.Server{HostKeys: server.HostKeys})
+	if result.Error != nil {
+		slog.Error("Failed to pin host key", "server_id", server.ID, "error", result.Error)
+		return false
+	}
+	if result.RowsAffected > 0 {
+		slog.Info("Pinned host key on first use", "server_id", server.ID, "host_key", fingerprint)
+	}
+	return result.RowsAffected > 0
+}
+
+// SetCertificateAuthority makes the worker issue a certificate per job for servers with
+// certificate authentication and no stored certificate
+func (w *Worker) SetCertificateAuthority(ca *ssh.CertificateAuthority) {
+	w.ca = ca
+}
+
+// SetKeyring gives the worker the master keys server credentials are encrypted with
+func (w *Worker) SetKeyring(keys *credentials.Keyring) {
+	w.keys = keys
+}
+
+// SetSecrets gives the worker the resolver for server credentials stored as references
+func (w *Worker) SetSecrets(resolver *secrets.Resolver) {
+	w.secrets = resolver
+}
+
+// SSHPool returns the pool of SSH connections the worker runs jobs over
+func (w *Worker) SSHPool() *ssh.Pool {
+	return w.sshPool
+}
+
+// effectivePrefetch defaults to two jobs per pool slot, bounded by the channel buffer
+func (w *Worker) effectivePrefetch() int {
+	prefetch := w.prefetch
+	if prefetch == 0 {
+		prefetch = w.workerPool * 2
+	}
+	if prefetch > cap(w.jobChan) {
+		prefetch = cap(w.jobChan)
+	}
+	return prefetch
+}
+
 func (w *Worker) Start(ctx context.Context) {
 	slog.Info("Starting job worker with thread pool", "worker_pool_size", w.workerPool)
 
@@ -88,17 +187,35 @@ func (w *Worker) Start(ctx context.Context) {
 		}(i + 1)
 	}
 
+	// Jobs are handed to the pool asynchronously, so keep each reservation until
+	// processJob is done with it. If this worker dies, the lease lapses and the
+	// queue redelivers the job to someone else.
+	w.queue.SetAutoAck(false)
+	w.queue.SetPrefetch(w.effectivePrefetch())
+
 	// Start consuming jobs from NetQueue
 	slog.Info("Attempting to start queue consumer")
 	if err := w.queue.StartConsumer(ctx, w.processJobWrapper); err != nil {
 		slog.Error("Failed to start queue consumer", "error", err)
 		return
 	}
-	slog.Info("Queue consumer started successfully")
+	slog.Info("Queue consumer started successfully", "prefetch", w.effectivePrefetch())
 
-	// Start cancellation polling (since NetQueue doesn't support proper cancel consumption)
-	slog.Info("Starting cancellation polling")
-	go w.startCancellationPolling(ctx)
+	// Cancellations are broadcast by the queue to every worker
+	if err := w.queue.StartCancelConsumer(ctx, w.handleCancelMessage); err != nil {
+		slog.Error("Failed to start cancel consumer", "error", err)
+		return
+	}
+	slog.Info("Cancel consumer started successfully")
+
+	// Connection tests and re-pinning from the API are run by whichever worker gets them
+	if err := w.queue.StartServerCheckConsumer(ctx, w.CheckServer); err != nil {
+		slog.Error("Failed to start server check consumer", "error", err)
+		return
+	}
+
+	// Health-check pooled SSH connections, closing them all on shutdown
+	go w.sshPool.Start(ctx)
 
 	// Start periodic stats logging
 	statsTicker := time.NewTicker(30 * time.Second) // Log stats every 30 seconds
@@ -147,11 +264,31 @@ func (w *Worker) processJobWrapper(job *models.Job) {
 			slog.Debug("Job sent to worker pool after retry", "job_id", job.ID)
 		case <-time.After(5 * time.Second):
 			slog.Error("Failed to send job to worker pool, channel blocked", "job_id", job.ID)
+			w.releaseJob(job.ID)
 		}
 	}
 }
 
 func (w *Worker) processJob(ctx context.Context, job *models.Job) {
+	// Settle the queue reservation when we're done. Jobs that never got to run are
+	// released so another worker can pick them up, failures worth retrying go back
+	// with a delay, jobs out of retries are dead-lettered, and everything else is acked.
+	release := true
+	var retryAfter time.Duration
+	var deadReason string
+	defer func() {
+		switch {
+		case release:
+			w.releaseJob(job.ID)
+		case retryAfter > 0:
+			w.retryJob(job.ID, retryAfter)
+		case deadReason != "":
+			w.deadLetterJob(job.ID, deadReason)
+		default:
+			w.ackJob(job.ID)
+		}
+	}()
+
 	// Acquire semaphore to limit concurrent jobs
 	select {
 	case w.semaphore <- struct{}{}:
@@ -188,19 +325,53 @@ func (w *Worker) processJob(ctx context.Context, job *models.Job) {
 		"active_jobs", currentActive,
 		"queue_size", len(w.jobChan))
 
+	// Register the job before checking its status so a cancel broadcast that races
+	// with the check still reaches it
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Store cancel function for potential cancellation
+	w.mu.Lock()
+	w.running[job.ID] = cancel
+	w.mu.Unlock()
+	defer w.removeRunningJob(job.ID)
+
 	// Check if job was already canceled while in queue
 	// Refresh job status from database to get latest state
 	var currentJob models.Job
 	if err := w.db.First(&currentJob, "id = ?", job.ID).Error; err != nil {
 		slog.Error("Failed to fetch current job status", "job_id", job.ID, "error", err)
+		// A job that no longer exists can never run, anything else is worth another try
+		release = err != gorm.ErrRecordNotFound
 		return
 	}
+	release = false
+
+	// The queued payload is a copy from submission time; the database tracks retries
+	job.Attempt = currentJob.Attempt
+	job.RetryPolicy = currentJob.RetryPolicy
+	job.OutputLines = currentJob.OutputLines
+	if job.Attempt < 1 {
+		job.Attempt = 1
+	}
 
 	if currentJob.Status == models.StatusCanceled {
 		slog.Info("Job was canceled while in queue, skipping execution", "job_id", job.ID)
 		return
 	}
 
+	// A redelivered job may already have finished if the previous worker died before acking
+	if currentJob.Status == models.StatusCompleted || currentJob.Status == models.StatusFailed {
+		slog.Info("Job already finished, skipping redelivery", "job_id", job.ID, "status", currentJob.Status)
+		return
+	}
+
+	// A cancel broadcast may have arrived while we were checking
+	if jobCtx.Err() != nil {
+		slog.Info("Job canceled before start, skipping execution", "job_id", job.ID)
+		return
+	}
+
 	// Update job status to running
 	now := time.Now().UTC()
 	job.Status = models.StatusRunning
@@ -211,15 +382,6 @@ func (w *Worker) processJob(ctx context.Context, job *models.Job) {
 		"job_id", job.ID,
 		"started_at", job.StartedAt.Format(time.RFC3339))
 
-	// Create cancelable context for this job
-	jobCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	// Store cancel function for potential cancellation
-	w.mu.Lock()
-	w.running[job.ID] = cancel
-	w.mu.Unlock()
-
 	// Fetch server configuration for this job
 	var server models.Server
 	if err := w.db.First(&server, "id = ?", job.ServerID).Error; err != nil {
@@ -246,29 +408,56 @@ func (w *Worker) processJob(ctx context.Context, job *models.Job) {
 		"port", server.Port,
 		"user", server.User)
 
+	// Resolve the jump hosts the server is reached through
+	jumpChain, err := models.JumpChain(w.db, &server)
+	if err != nil {
+		finishedAt := time.Now().UTC()
+		job.Status = models.StatusFailed
+		job.Error = fmt.Sprintf("Failed to resolve jump hosts: %v", err)
+		job.FinishedAt = &finishedAt
+
+		slog.Error("Failed to resolve jump hosts",
+			"job_id", job.ID,
+			"server_id", job.ServerID,
+			"error", err)
+
+		w.updateJob(job)
+		w.removeRunningJob(job.ID)
+		return
+	}
+
 	// Create SSH client with server configuration
-	sshConfig := &config.SSHConfig{
-		Host:       server.Hostname,
-		Port:       fmt.Sprintf("%d", server.Port),
-		User:       server.User,
-		Password:   server.Password,
-		PrivateKey: server.PrivateKey,
-		PemFileURL: server.PemFileURL,
-	}
-
-	// Use PEM file if provided (legacy support)
-	if server.PemFile != "" {
-		sshConfig.PrivateKey = server.PemFile
-		slog.Debug("Using PEM file for authentication", "job_id", job.ID)
-	}
-
-	// Create SSH client with storage service for PEM file URL support
-	var sshClient *ssh.Client
-	if server.PemFileURL != "" {
-		sshClient = ssh.NewClientWithStorage(sshConfig, w.storage)
-		slog.Debug("Using PEM file URL for authentication", "job_id", job.ID, "pem_file_url", server.PemFileURL)
+	sshConfig := server.SSHConfig()
+	sshConfig.TrustOnFirstUse = !w.strictKeys
+	sshConfig.ForwardAgent = job.ForwardAgent
+	sshConfig.JumpHosts = models.JumpSSHConfigs(jumpChain)
+	for i := range sshConfig.JumpHosts {
+		sshConfig.JumpHosts[i].TrustOnFirstUse = !w.strictKeys
+	}
+	if len(jumpChain) > 0 {
+		slog.Debug("Connecting through jump hosts", "job_id", job.ID, "hops", len(jumpChain))
+	}
+
+	// Set timeout
+	timeout := time.Duration(job.Timeout) * time.Second
+	if job.Timeout <= 0 {
+		timeout = 5 * time.Minute // default timeout
+	}
+
+	slog.Debug("Command timeout set",
+		"job_id", job.ID,
+		"timeout", timeout)
+
+	// The storage service is needed for PEM file URLs, on the server or a jump host
+	sshClient := ssh.NewClientWithStorage(sshConfig, w.storage)
+	sshClient.SetKeyring(w.keys)
+	sshClient.SetSecrets(w.secrets)
+	if sshClient.MintsCertificate() {
+		// The certificate names the job in its key ID and expires with it, so the
+		// connection isn't shared with other jobs
+		sshClient.SetCertificateAuthority(w.ca, "job-"+job.ID, timeout)
 	} else {
-		sshClient = ssh.NewClient(sshConfig)
+		sshClient.SetPool(w.sshPool, server.ID, server.UpdatedAt)
 	}
 
 	// Build full command
@@ -289,74 +478,76 @@ func (w *Worker) processJob(ctx context.Context, job *models.Job) {
 		"job_id", job.ID,
 		"full_command", fullCommand)
 
-	// Set timeout
-	timeout := time.Duration(job.Timeout) * time.Second
-	if job.Timeout <= 0 {
-		timeout = 5 * time.Minute // default timeout
-	}
-
-	slog.Debug("Command timeout set",
-		"job_id", job.ID,
-		"timeout", timeout)
-
-	// Track output for database storage
-	var outputBuilder strings.Builder
-	var errorBuilder strings.Builder
-	var lineCount int
+	// Track output for database storage, large output is moved to storage
+	logName := fmt.Sprintf("%s/%d", job.ID, job.Attempt)
+	stdout := newOutputBuffer(w.storage, logName+"/stdout", w.outputLimit, w.outputChunkSize)
+	stderr := newOutputBuffer(w.storage, logName+"/stderr", w.outputLimit, w.outputChunkSize)
+	lineCount := job.OutputLines // numbered on from the previous attempt
 	var lastUpdateTime time.Time
+	var outputMu sync.Mutex // stdout and stderr are streamed from separate goroutines
 
 	// Streaming callback for real-time output
 	streamCallback := func(output string, isStderr bool) {
+		outputMu.Lock()
+		defer outputMu.Unlock()
 		lineCount++
 		now := time.Now()
 
-		// Store in builders for final database update
-		if isStderr {
-			errorBuilder.WriteString(output)
-		} else {
-			outputBuilder.WriteString(output)
+		// Publish the chunk for live viewers; the database copy below catches up anyway
+		if err := w.queue.PublishOutputEvent(job.ID, output, isStderr, lineCount); err != nil {
+			slog.Debug("Failed to publish output event", "job_id", job.ID, "error", err)
 		}
 
 		// Update the job's output field incrementally for live monitoring
 		if isStderr {
-			job.Stderr = errorBuilder.String()
+			stderr.Write(output)
+			job.Stderr = stderr.Text()
+			job.StderrLog = stderr.Log()
 		} else {
-			job.Stdout = outputBuilder.String()
-			job.Output = outputBuilder.String() // Keep for backward compatibility
+			stdout.Write(output)
+			job.Stdout = stdout.Text()
+			job.StdoutLog = stdout.Log()
 		}
 
+		job.OutputLines = lineCount
+
 		// Update job in database periodically (every 10 lines or every 2 seconds)
 		if lineCount%10 == 0 || now.Sub(lastUpdateTime) >= 2*time.Second {
-			w.updateJob(job)
+			w.updateJobOutput(job)
 			lastUpdateTime = now
 		}
 	}
 
-	// Execute command via SSH with streaming
-	// Also start a goroutine to check for cancellation during execution
-	cancelCheckTicker := time.NewTicker(1 * time.Second)
-	defer cancelCheckTicker.Stop()
-
-	go func() {
-		for {
-			select {
-			case <-jobCtx.Done():
-				return
-			case <-cancelCheckTicker.C:
-				// Check if job has been marked as canceled in database
-				var checkJob models.Job
-				if err := w.db.First(&checkJob, "id = ?", job.ID).Error; err == nil {
-					if checkJob.Status == models.StatusCanceled {
-						slog.Info("Job marked as canceled during execution, canceling context", "job_id", job.ID)
-						cancel()
-						return
-					}
-				}
-			}
+	// Input files go onto the target first, the command doesn't run without them
+	var result *ssh.StreamingResult
+	if err = w.uploadInputs(jobCtx, sshClient, job, timeout); err != nil {
+		err = fmt.Errorf("failed to upload input files: %w", err)
+	} else {
+		// Execute command via SSH with streaming
+		// A cancel broadcast for this job cancels jobCtx and stops the command
+		result, err = sshClient.ExecuteStreaming(jobCtx, fullCommand, timeout, streamCallback)
+	}
+	if len(server.HostKeys) == 0 && sshClient.HostKey() != "" {
+		w.pinHostKey(&server, sshClient.HostKey())
+	}
+	for i, hostKey := range sshClient.JumpHostKeys() {
+		if len(jumpChain[i].HostKeys) == 0 && hostKey != "" {
+			w.pinHostKey(&jumpChain[i], hostKey)
 		}
-	}()
+	}
 
-	result, err := sshClient.ExecuteStreaming(jobCtx, fullCommand, timeout, streamCallback)
+	// Artifacts are collected whatever the exit code, as long as the command ran to the end
+	if err == nil {
+		w.collectArtifacts(jobCtx, sshClient, job, timeout)
+	}
+
+	// The rest of the output goes to storage even if the job was canceled
+	outputMu.Lock()
+	stdout.Close(context.Background())
+	stderr.Close(context.Background())
+	job.Stdout, job.StdoutLog = stdout.Text(), stdout.Log()
+	job.Stderr, job.StderrLog = stderr.Text(), stderr.Log()
+	outputMu.Unlock()
 
 	// Update job with results
 	finishedAt := time.Now().UTC()
@@ -364,7 +555,13 @@ func (w *Worker) processJob(ctx context.Context, job *models.Job) {
 	duration := finishedAt.Sub(*job.StartedAt)
 
 	if err != nil {
-		if strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "context canceled") {
+		if ssh.IsHostKeyError(err) {
+			job.Status = models.StatusFailed
+			slog.Error("Host key verification failed, not running job",
+				"job_id", job.ID,
+				"server_id", server.ID,
+				"error", err)
+		} else if strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "context canceled") {
 			job.Status = models.StatusCanceled
 			slog.Warn("Job execution canceled/timeout",
 				"job_id", job.ID,
@@ -379,13 +576,10 @@ func (w *Worker) processJob(ctx context.Context, job *models.Job) {
 		}
 		job.Error = err.Error()
 	} else {
-		// Store the accumulated output from streaming
-		finalOutput := outputBuilder.String()
-		finalError := errorBuilder.String()
+		// The accumulated output from streaming, or its tail if it's in storage
+		finalOutput := job.Stdout
+		finalError := job.Stderr
 
-		job.Output = finalOutput // Keep for backward compatibility
-		job.Stdout = finalOutput
-		job.Stderr = finalError
 		job.Error = finalError // Keep for backward compatibility
 		job.ExitCode = &result.ExitCode
 
@@ -395,16 +589,16 @@ func (w *Worker) processJob(ctx context.Context, job *models.Job) {
 				"job_id", job.ID,
 				"exit_code", result.ExitCode,
 				"duration", duration,
-				"stdout_length", len(finalOutput),
-				"stderr_length", len(finalError))
+				"stdout_length", stdout.Size(),
+				"stderr_length", stderr.Size())
 		} else {
 			job.Status = models.StatusFailed
 			slog.Warn("Job completed with non-zero exit code",
 				"job_id", job.ID,
 				"exit_code", result.ExitCode,
 				"duration", duration,
-				"stdout_length", len(finalOutput),
-				"stderr_length", len(finalError))
+				"stdout_length", stdout.Size(),
+				"stderr_length", stderr.Size())
 		}
 
 		// Log output summary (first 200 chars) for debugging
@@ -429,6 +623,36 @@ func (w *Worker) processJob(ctx context.Context, job *models.Job) {
 		}
 	}
 
+	// Give failures that may go away on their own another go, within the job's retry policy
+	if job.Status != models.StatusCompleted && isRetryable(jobCtx, err, result, job.RetryPolicy) {
+		failure := job.Error
+		if err == nil {
+			failure = fmt.Sprintf("exit code %d", result.ExitCode)
+		}
+		if job.Attempt <= job.MaxRetries {
+			retryAfter = retryBackoff(job.RetryPolicy, job.Attempt)
+			slog.Warn("Job attempt failed, retrying",
+				"job_id", job.ID,
+				"attempt", job.Attempt,
+				"max_retries", job.MaxRetries,
+				"retry_in", retryAfter,
+				"failure", failure)
+			job.Error = fmt.Sprintf("attempt %d failed: %s", job.Attempt, failure)
+			job.Status = models.StatusQueued
+			job.StartedAt = nil
+			job.FinishedAt = nil
+			job.Attempt++
+		} else if job.MaxRetries > 0 {
+			deadReason = fmt.Sprintf("failed after %d attempts: %s", job.Attempt, failure)
+			slog.Error("Job out of retries, dead-lettering", "job_id", job.ID, "attempts", job.Attempt, "failure", failure)
+		}
+	}
+
+	// The stored logs are missing output storage failed to take, say so with the job
+	if lost := stdout.Lost() + stderr.Lost(); lost > 0 {
+		job.Error = strings.TrimLeft(job.Error+fmt.Sprintf("\n%d bytes of output couldn't be stored, the stored logs are incomplete", lost), "\n")
+	}
+
 	slog.Info("Job processing completed",
 		"job_id", job.ID,
 		"final_status", job.Status,
@@ -453,6 +677,16 @@ func (w *Worker) updateJob(job *models.Job) {
 	}
 }
 
+// updateJobOutput saves just the output of a running job
+func (w *Worker) updateJobOutput(job *models.Job) {
+	err := w.db.Model(job).Select("stdout", "stderr", "stdout_log", "stderr_log", "output_lines").Updates(job).Error
+	if err != nil {
+		slog.Error("Failed to update job output in database",
+			"job_id", job.ID,
+			"error", err)
+	}
+}
+
 func (w *Worker) CancelJob(jobID string) error {
 	slog.Info("Attempting to cancel job", "job_id", jobID)
 
@@ -522,7 +756,24 @@ func (w *Worker) handleCancelMessage(jobID string) {
 			"job_id", jobID,
 			"canceled_at", now.Format(time.RFC3339))
 	} else {
-		slog.Warn("Received cancel message for job that is not running", "job_id", jobID)
+		// Cancels are broadcast to every worker, so this is the common case
+		slog.Debug("Received cancel message for job not running on this worker", "job_id", jobID)
+	}
+}
+
+// ackJob tells the queue the job is done so its reservation is dropped
+func (w *Worker) ackJob(jobID string) {
+	if err := w.queue.Ack(jobID); err != nil {
+		slog.Error("Failed to ack job", "job_id", jobID, "error", err)
+	}
+}
+
+// releaseJob hands a job we couldn't start back to the queue
+func (w *Worker) releaseJob(jobID string) {
+	if err := w.queue.Nack(jobID); err != nil {
+		slog.Error("Failed to release job back to queue", "job_id", jobID, "error", err)
+	} else {
+		slog.Info("Job released back to queue", "job_id", jobID)
 	}
 }
 
@@ -557,53 +808,3 @@ func (w *Worker) LogWorkerStats() {
 		"queue_capacity", stats["queue_capacity"],
 		"running_jobs", stats["running_jobs"])
 }
-
-// startCancellationPolling polls the database for jobs that need to be canceled
-func (w *Worker) startCancellationPolling(ctx context.Context) {
-	ticker := time.NewTicker(2 * time.Second) // Check every 2 seconds
-	defer ticker.Stop()
-
-	slog.Info("Cancellation polling started")
-
-	for {
-		select {
-		case <-ctx.Done():
-			slog.Info("Cancellation polling stopped")
-			return
-		case <-ticker.C:
-			w.checkForCancellations()
-		}
-	}
-}
-
-// checkForCancellations checks for jobs that have been marked for cancellation
-func (w *Worker) checkForCancellations() {
-	// Get all running jobs that have been marked for cancellation
-	var jobs []models.Job
-	if err := w.db.Where("status = ?", models.StatusCanceled).Find(&jobs).Error; err != nil {
-		slog.Error("Failed to fetch canceled jobs", "error", err)
-		return
-	}
-
-	for _, job := range jobs {
-		// Check if this job is currently running in our worker
-		w.mu.RLock()
-		cancel, exists := w.running[job.ID]
-		w.mu.RUnlock()
-
-		if exists {
-			slog.Info("Canceling running job via polling", "job_id", job.ID)
-			cancel()
-
-			// Update job status
-			now := time.Now().UTC()
-			job.FinishedAt = &now
-
-			if err := w.db.Save(&job).Error; err != nil {
-				slog.Error("Failed to update canceled job status", "job_id", job.ID, "error", err)
-			} else {
-				slog.Info("Job successfully canceled via polling", "job_id", job.ID)
-			}
-		}
-	}
-}
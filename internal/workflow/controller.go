@@ -0,0 +1,370 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"job-executor/internal/models"
+	"job-executor/internal/queue"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// defaultPollInterval is how often running workflows are checked for finished steps
+const defaultPollInterval = 2 * time.Second
+
+// ErrNotRunning is returned when canceling a workflow that has already finished
+var ErrNotRunning = errors.New("workflow is not running")
+
+// errStepClaimed aborts starting a step that another controller started first
+var errStepClaimed = errors.New("step already started")
+
+// Controller moves workflows forward: it follows the jobs of running steps and starts
+// each pending step once the steps it depends on have finished. Every change is a
+// conditional update, so several controllers can safely work on the same workflows.
+type Controller struct {
+	db       *gorm.DB
+	queue    queue.NetQueue
+	interval time.Duration
+}
+
+func New(db *gorm.DB, queue queue.NetQueue) *Controller {
+	return &Controller{
+		db:       db,
+		queue:    queue,
+		interval: defaultPollInterval,
+	}
+}
+
+// SetInterval configures how often running workflows are checked
+func (c *Controller) SetInterval(interval time.Duration) {
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	c.interval = interval
+}
+
+// Start runs the controller until ctx is done
+func (c *Controller) Start(ctx context.Context) {
+	slog.Info("Starting workflow controller", "interval", c.interval)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Workflow controller stopped")
+			return
+		case <-ticker.C:
+		}
+
+		var ids []string
+		if err := c.db.Model(&models.Workflow{}).Where("status = ?", models.WorkflowRunning).Pluck("id", &ids).Error; err != nil {
+			slog.Error("Failed to fetch running workflows", "error", err)
+			continue
+		}
+		for _, id := range ids {
+			if err := c.Advance(id); err != nil {
+				slog.Error("Failed to advance workflow", "workflow_id", id, "error", err)
+			}
+		}
+	}
+}
+
+// Advance brings a workflow up to date with its jobs and starts every step that is ready
+func (c *Controller) Advance(workflowID string) error {
+	var workflow models.Workflow
+	if err := c.db.Preload("Steps").First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return err
+	}
+	if workflow.Status != models.WorkflowRunning {
+		return nil
+	}
+
+	if err := c.syncSteps(workflow.Steps); err != nil {
+		return err
+	}
+
+	// Starting or skipping a step can make the steps after it ready, so keep going
+	// until nothing changes
+	byName := make(map[string]*models.WorkflowStep, len(workflow.Steps))
+	for i := range workflow.Steps {
+		byName[workflow.Steps[i].Name] = &workflow.Steps[i]
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := range workflow.Steps {
+			step := &workflow.Steps[i]
+			if step.Status != models.StepPending {
+				continue
+			}
+			ready, run := evaluate(step, byName)
+			if !ready {
+				continue
+			}
+			if run {
+				if err := c.startStep(step); err != nil {
+					return err
+				}
+			} else {
+				c.setStepStatus(step, models.StepSkipped)
+			}
+			changed = true
+		}
+	}
+
+	return c.finish(&workflow)
+}
+
+// evaluate reports whether all of a step's dependencies have finished and, if so,
+// whether their conditions allow it to run
+func evaluate(step *models.WorkflowStep, byName map[string]*models.WorkflowStep) (ready, run bool) {
+	run = true
+	for _, dep := range step.DependsOn {
+		upstream, ok := byName[dep.Step]
+		if !ok {
+			return true, false
+		}
+		if !upstream.Status.Finished() {
+			return false, false
+		}
+		if !dep.Condition.Satisfied(upstream.Status) {
+			run = false
+		}
+	}
+	return true, run
+}
+
+// syncSteps copies the status of each started step's job onto the step
+func (c *Controller) syncSteps(steps []models.WorkflowStep) error {
+	var jobIDs []string
+	for _, step := range steps {
+		if step.JobID != nil && !step.Status.Finished() {
+			jobIDs = append(jobIDs, *step.JobID)
+		}
+	}
+	if len(jobIDs) == 0 {
+		return nil
+	}
+
+	var jobs []models.Job
+	if err := c.db.Select("id", "status").Where("id IN ?", jobIDs).Find(&jobs).Error; err != nil {
+		return err
+	}
+	statuses := make(map[string]models.JobStatus, len(jobs))
+	for _, job := range jobs {
+		statuses[job.ID] = job.Status
+	}
+
+	for i := range steps {
+		step := &steps[i]
+		if step.JobID == nil || step.Status.Finished() {
+			continue
+		}
+		jobStatus, ok := statuses[*step.JobID]
+		if !ok {
+			// The job was deleted from under the step
+			c.setStepStatus(step, models.StepCanceled)
+			continue
+		}
+		if status := stepStatus(jobStatus); status != step.Status {
+			c.setStepStatus(step, status)
+		}
+	}
+	return nil
+}
+
+// stepStatus maps a job status onto the status of the step that runs it
+func stepStatus(status models.JobStatus) models.StepStatus {
+	switch status {
+	case models.StatusRunning:
+		return models.StepRunning
+	case models.StatusCompleted:
+		return models.StepCompleted
+	case models.StatusFailed:
+		return models.StepFailed
+	case models.StatusCanceled:
+		return models.StepCanceled
+	default:
+		return models.StepQueued
+	}
+}
+
+// setStepStatus updates a step's status unless another controller got there first
+func (c *Controller) setStepStatus(step *models.WorkflowStep, status models.StepStatus) {
+	err := c.db.Model(&models.WorkflowStep{}).
+		Where("id = ? AND status = ?", step.ID, step.Status).
+		Update("status", status).Error
+	if err != nil {
+		slog.Error("Failed to update workflow step", "step_id", step.ID, "error", err)
+		return
+	}
+	step.Status = status
+}
+
+// startStep creates the step's job and pushes it to the queue
+func (c *Controller) startStep(step *models.WorkflowStep) error {
+	job := step.NewJob()
+
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(job).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&models.WorkflowStep{}).
+			Where("id = ? AND status = ?", step.ID, models.StepPending).
+			Updates(map[string]interface{}{"status": models.StepQueued, "job_id": job.ID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// Another controller started it, drop our job
+			return errStepClaimed
+		}
+		return nil
+	})
+	if err == errStepClaimed {
+		step.Status = models.StepQueued
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to start step %q: %w", step.Name, err)
+	}
+
+	step.Status = models.StepQueued
+	step.JobID = &job.ID
+
+	if err := c.queue.Push(job); err != nil {
+		slog.Error("Failed to push workflow job to queue", "step_id", step.ID, "job_id", job.ID, "error", err)
+		// The step fails through its job on the next pass
+		job.Status = models.StatusFailed
+		job.Error = "Failed to queue job: " + err.Error()
+		c.db.Save(job)
+		return nil
+	}
+
+	slog.Info("Workflow step started", "workflow_id", step.WorkflowID, "step", step.Name, "job_id", job.ID)
+	return nil
+}
+
+// finish marks the workflow finished once all of its steps are
+func (c *Controller) finish(workflow *models.Workflow) error {
+	status := models.WorkflowCompleted
+	for _, step := range workflow.Steps {
+		if !step.Status.Finished() {
+			return nil
+		}
+		if step.Status == models.StepFailed || step.Status == models.StepCanceled {
+			status = models.WorkflowFailed
+		}
+	}
+
+	now := time.Now().UTC()
+	err := c.db.Model(&models.Workflow{}).
+		Where("id = ? AND status = ?", workflow.ID, models.WorkflowRunning).
+		Updates(map[string]interface{}{"status": status, "finished_at": now}).Error
+	if err != nil {
+		return err
+	}
+	workflow.Status = status
+	workflow.FinishedAt = &now
+
+	slog.Info("Workflow finished", "workflow_id", workflow.ID, "status", status)
+	return nil
+}
+
+// Cancel stops a running workflow: pending steps won't start and active jobs are canceled
+func (c *Controller) Cancel(workflowID string) error {
+	var workflow models.Workflow
+	if err := c.db.Preload("Steps").First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	result := c.db.Model(&models.Workflow{}).
+		Where("id = ? AND status = ?", workflowID, models.WorkflowRunning).
+		Updates(map[string]interface{}{"status": models.WorkflowCanceled, "finished_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotRunning
+	}
+
+	for i := range workflow.Steps {
+		step := &workflow.Steps[i]
+		if step.Status.Finished() {
+			continue
+		}
+		if step.JobID != nil {
+			err := c.db.Model(&models.Job{}).
+				Where("id = ? AND status IN ?", *step.JobID, models.ActiveStatuses).
+				Updates(map[string]interface{}{"status": models.StatusCanceled, "finished_at": now}).Error
+			if err != nil {
+				slog.Error("Failed to cancel workflow job", "job_id", *step.JobID, "error", err)
+			}
+			if err := c.queue.PublishCancelMessage(*step.JobID); err != nil {
+				slog.Error("Failed to publish cancel message", "job_id", *step.JobID, "error", err)
+			}
+		}
+		c.setStepStatus(step, models.StepCanceled)
+	}
+
+	slog.Info("Workflow canceled", "workflow_id", workflowID)
+	return nil
+}
+
+// ValidateSteps checks that step names are unique, every dependency names another step
+// and the dependencies don't form a cycle
+func ValidateSteps(steps []models.WorkflowStepRequest) error {
+	deps := make(map[string][]string, len(steps))
+	for _, step := range steps {
+		if _, dup := deps[step.Name]; dup {
+			return fmt.Errorf("duplicate step name %q", step.Name)
+		}
+		deps[step.Name] = nil
+	}
+	for _, step := range steps {
+		for _, dep := range step.DependsOn {
+			if _, ok := deps[dep.Step]; !ok {
+				return fmt.Errorf("step %q depends on unknown step %q", step.Name, dep.Step)
+			}
+			if dep.Step == step.Name {
+				return fmt.Errorf("step %q depends on itself", step.Name)
+			}
+			deps[step.Name] = append(deps[step.Name], dep.Step)
+		}
+	}
+
+	// Depth-first search, a step seen again while it is still on the path closes a cycle
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	state := make(map[string]int, len(steps))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case onPath:
+			return fmt.Errorf("dependency cycle through step %q", name)
+		case done:
+			return nil
+		}
+		state[name] = onPath
+		for _, dep := range deps[name] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		return nil
+	}
+	for _, step := range steps {
+		if err := visit(step.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
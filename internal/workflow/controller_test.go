@@ -0,0 +1,59 @@
+package workflow
+
+import (
+	"job-executor/internal/models"
+	"testing"
+)
+
+func TestValidateSteps(t *testing.T) {
+	dep := func(step string) []models.StepDependency {
+		return []models.StepDependency{{Step: step}}
+	}
+
+	valid := []models.WorkflowStepRequest{
+		{Name: "build"},
+		{Name: "deploy", DependsOn: dep("build")},
+		{Name: "smoke", DependsOn: dep("deploy")},
+	}
+	if err := ValidateSteps(valid); err != nil {
+		t.Fatalf("valid workflow rejected: %v", err)
+	}
+
+	invalid := map[string][]models.WorkflowStepRequest{
+		"duplicate": {{Name: "a"}, {Name: "a"}},
+		"unknown":   {{Name: "a", DependsOn: dep("b")}},
+		"self":      {{Name: "a", DependsOn: dep("a")}},
+		"cycle":     {{Name: "a", DependsOn: dep("c")}, {Name: "b", DependsOn: dep("a")}, {Name: "c", DependsOn: dep("b")}},
+	}
+	for name, steps := range invalid {
+		if err := ValidateSteps(steps); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	steps := map[string]*models.WorkflowStep{
+		"build":   {Name: "build", Status: models.StepFailed},
+		"cleanup": {Name: "cleanup", Status: models.StepRunning},
+	}
+
+	cases := []struct {
+		name      string
+		deps      []models.StepDependency
+		ready     bool
+		shouldRun bool
+	}{
+		{"no dependencies", nil, true, true},
+		{"on_success after failure", []models.StepDependency{{Step: "build", Condition: models.OnSuccess}}, true, false},
+		{"on_failure after failure", []models.StepDependency{{Step: "build", Condition: models.OnFailure}}, true, true},
+		{"always after failure", []models.StepDependency{{Step: "build", Condition: models.Always}}, true, true},
+		{"upstream still running", []models.StepDependency{{Step: "build", Condition: models.Always}, {Step: "cleanup", Condition: models.Always}}, false, false},
+	}
+	for _, tc := range cases {
+		ready, run := evaluate(&models.WorkflowStep{Name: "next", DependsOn: tc.deps}, steps)
+		if ready != tc.ready || run != tc.shouldRun {
+			t.Errorf("%s: got ready=%v run=%v, want ready=%v run=%v", tc.name, ready, run, tc.ready, tc.shouldRun)
+		}
+	}
+}
@@ -43,6 +43,10 @@ export const AnimatedJobRow = memo(function AnimatedJobRow({
     switch (status) {
       case "queued":
         return <Clock className="h-4 w-4 text-yellow-500" />;
+      case "pending":
+        return <Clock className="h-4 w-4 text-gray-500" />;
+      case "scheduled":
+        return <Clock className="h-4 w-4 text-purple-500" />;
       case "running":
         return <Clock className="h-4 w-4 text-blue-500 animate-spin" />;
       case "completed":
@@ -62,6 +66,16 @@ export const AnimatedJobRow = memo(function AnimatedJobRow({
         className:
           "bg-yellow-500 hover:bg-yellow-600 transition-colors duration-200",
       },
+      pending: {
+        variant: "secondary" as const,
+        className:
+          "bg-gray-500 hover:bg-gray-600 transition-colors duration-200",
+      },
+      scheduled: {
+        variant: "secondary" as const,
+        className:
+          "bg-purple-500 hover:bg-purple-600 transition-colors duration-200",
+      },
       running: {
         variant: "default" as const,
         className:
@@ -4,7 +4,14 @@ import type { Job } from "@/types";
 
 interface JobStatusUpdate {
   id: string;
-  status: "queued" | "running" | "completed" | "failed" | "canceled";
+  status:
+    | "queued"
+    | "pending"
+    | "scheduled"
+    | "running"
+    | "completed"
+    | "failed"
+    | "canceled";
   command: string;
   args: string;
   server_id: string;
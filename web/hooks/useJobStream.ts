@@ -31,7 +31,7 @@ export const useJobStream = (jobId: string | null) => {
   useEffect(() => {
     if (!jobId) return;
 
-    const url = `${BACKEND_BASE_URL}/api/jobs/${jobId}/stream`;
+    const url = `${BACKEND_BASE_URL}/api/v1/jobs/${jobId}/stream`;
     const source = new EventSource(url, { withCredentials: true });
     sourceRef.current = source;
 
@@ -45,7 +45,14 @@ export interface GoJob {
   command: string;
   args: string;
   server_id: string;
-  status: "queued" | "running" | "completed" | "failed" | "canceled";
+  status:
+    | "queued"
+    | "pending"
+    | "scheduled"
+    | "running"
+    | "completed"
+    | "failed"
+    | "canceled";
   priority: number;
   output: string;
   error: string;
@@ -28,6 +28,8 @@ export interface Job {
   args?: string;
   status:
     | "queued"
+    | "pending"
+    | "scheduled"
     | "running"
     | "completed"
     | "failed"